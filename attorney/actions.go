@@ -5,60 +5,187 @@ package attorney
 
 import (
 	"encoding/json"
-	"strings"
+	"errors"
 
 	"github.com/freehandle/breeze/crypto"
 	"github.com/freehandle/breeze/protocol/actions"
 	"github.com/freehandle/breeze/util"
 )
 
-const voidTailSize = 2*crypto.TokenSize + 2*crypto.SignatureSize + 8
-
 var AxeProtocolCode = [4]byte{1, 0, 0, 0}
 
+// ActionValidator is the view of the state against which actions validate
+// themselves. Queries consider both the state and the mutations accepted so
+// far, and each Set method records a mutation if it is consistent with them.
 type ActionValidator interface {
 	Epoch() uint64
-	HasCaption(crypto.Hash) bool
-	HasMember(crypto.Hash) bool
-	SetNewMember(crypto.Hash, crypto.Hash) bool
-	PowerOfAttorney(crypto.Hash) bool
-	SetNewGrantPower(crypto.Hash) bool
-	SetNewRevokePower(crypto.Hash) bool
-}
-
-func GetHashes(data []byte) []crypto.Hash {
-	tokens := GetTokens(data)
-	hashes := make([]crypto.Hash, len(tokens))
-	for n, token := range tokens {
-		hashes[n] = crypto.HashToken(token)
+	HasMember(crypto.Token) bool
+	HasHandle(string) bool
+	HandleOf(crypto.Token) (string, bool)
+	IsRetired(crypto.Token) bool
+	PowerOfAttorney(token, attorney crypto.Token) bool
+	PowerOfAttorneyFor(token, attorney crypto.Token, kind byte, protocol uint32) bool
+	RequiredWork(handle string) uint64
+	IsWorkSeed(seed crypto.Hash) bool
+	CanJoin() bool
+	SetNewMember(token crypto.Token, handle string, work uint64) bool
+	SetNewProfile(token crypto.Token, details string) bool
+	SetNewGrantPower(token, attorney crypto.Token, scope Scope, fingerprint []byte) bool
+	SetNewRevokePower(token, attorney crypto.Token) bool
+	SetNewRotateKey(old, token crypto.Token) bool
+	SetNewGuardians(token crypto.Token, guardians Guardians) bool
+	SetNewRecovery(request *RequestRecovery) bool
+	SetCancelRecovery(token crypto.Token) bool
+	SetCompleteRecovery(author, token crypto.Token) bool
+	SetNewTransfer(author crypto.Token, handle string, recipient crypto.Token) bool
+	SetNewRelease(author crypto.Token, handle string) bool
+	CollectiveOf(crypto.Token) (Collective, bool)
+	SetNewCollective(token crypto.Token, collective Collective) bool
+	IsRecent(crypto.Hash) bool
+	// Atomic runs validate against a scratch copy of the validator and keeps
+	// its mutations only if validate returns nil.
+	Atomic(validate func(ActionValidator) error) error
+}
+
+// Action is a parsed attorney action.
+type Action interface {
+	Kind() byte
+	Tokens() []crypto.Token
+	Serialize() []byte
+	Validate(ActionValidator) error
+}
+
+// breezeTailSize is the size of the wallet, fee and signature breeze appends
+// to an action when it dresses it for a block (see actions.Dress).
+const breezeTailSize = crypto.TokenSize + 8 + crypto.SignatureSize
+
+// ParseAction parses an attorney action of any kind, either bare or dressed
+// by breeze. It returns nil if data is not a valid action.
+func ParseAction(data []byte) Action {
+	action, _, _ := parseAction(data)
+	return action
+}
+
+// parseAction parses an attorney action dressed by breeze or, failing that,
+// a bare one. It returns the action with its bare bytes, which identify the
+// action whatever wallet dressed it.
+func parseAction(data []byte) (Action, []byte, error) {
+	var dressedErr error
+	if len(data) > breezeTailSize {
+		bare := data[:len(data)-breezeTailSize]
+		action, err := parseBareAction(bare)
+		if err == nil {
+			return action, bare, nil
+		}
+		dressedErr = err
 	}
-	return hashes
+	action, err := parseBareAction(data)
+	if errors.Is(err, ErrMalformed) && dressedErr != nil {
+		// the trailing bytes are most likely the breeze tail of an action
+		// rejected for another reason
+		return nil, data, dressedErr
+	}
+	return action, data, err
 }
 
-func GetTokens(data []byte) []crypto.Token {
-	kind := Kind(data)
-	switch kind {
+// parseBareAction parses an attorney action without the breeze tail.
+func parseBareAction(data []byte) (Action, error) {
+	var action Action
+	var err error
+	switch Kind(data) {
+	case VoidType:
+		var void *Void
+		if void, err = parseVoid(data); err == nil {
+			action = void
+		}
 	case JoinNetworkType:
-		if join := ParseJoinNetwork(data); join != nil {
-			return join.Tokens()
+		var join *JoinNetwork
+		if join, err = parseJoinNetwork(data); err == nil {
+			action = join
 		}
-
 	case UpdateInfoType:
-		if update := ParseUpdateInfo(data); update != nil {
-			return update.Tokens()
+		var update *UpdateInfo
+		if update, err = parseUpdateInfo(data); err == nil {
+			action = update
 		}
 	case GrantPowerOfAttorneyType:
-		if grant := ParseGrantPowerOfAttorney(data); grant != nil {
-			return grant.Tokens()
+		var grant *GrantPowerOfAttorney
+		if grant, err = parseGrantPowerOfAttorney(data); err == nil {
+			action = grant
 		}
 	case RevokePowerOfAttorneyType:
-		if revoke := ParseRevokePowerOfAttorney(data); revoke != nil {
-			return revoke.Tokens()
+		var revoke *RevokePowerOfAttorney
+		if revoke, err = parseRevokePowerOfAttorney(data); err == nil {
+			action = revoke
 		}
-	case VoidType:
-		if void := ParseVoid(data); void != nil {
-			return void.Tokens()
+	case RotateKeyType:
+		var rotate *RotateKey
+		if rotate, err = parseRotateKey(data); err == nil {
+			action = rotate
+		}
+	case RegisterGuardiansType:
+		var register *RegisterGuardians
+		if register, err = parseRegisterGuardians(data); err == nil {
+			action = register
+		}
+	case RequestRecoveryType:
+		var request *RequestRecovery
+		if request, err = parseRequestRecovery(data); err == nil {
+			action = request
+		}
+	case CancelRecoveryType:
+		var cancel *CancelRecovery
+		if cancel, err = parseCancelRecovery(data); err == nil {
+			action = cancel
+		}
+	case CompleteRecoveryType:
+		var complete *CompleteRecovery
+		if complete, err = parseCompleteRecovery(data); err == nil {
+			action = complete
+		}
+	case TransferHandleType:
+		var transfer *TransferHandle
+		if transfer, err = parseTransferHandle(data); err == nil {
+			action = transfer
+		}
+	case ReleaseHandleType:
+		var release *ReleaseHandle
+		if release, err = parseReleaseHandle(data); err == nil {
+			action = release
+		}
+	case KeyExchangeType:
+		var exchange *KeyExchange
+		if exchange, err = parseKeyExchange(data); err == nil {
+			action = exchange
 		}
+	case JoinCollectiveType:
+		var join *JoinCollective
+		if join, err = parseJoinCollective(data); err == nil {
+			action = join
+		}
+	case BatchType:
+		var batch *Batch
+		if batch, err = parseBatch(data); err == nil {
+			action = batch
+		}
+	default:
+		err = ErrUnknownKind
+	}
+	return action, err
+}
+
+func GetHashes(data []byte) []crypto.Hash {
+	tokens := GetTokens(data)
+	hashes := make([]crypto.Hash, len(tokens))
+	for n, token := range tokens {
+		hashes[n] = crypto.HashToken(token)
+	}
+	return hashes
+}
+
+func GetTokens(data []byte) []crypto.Token {
+	if action := ParseAction(data); action != nil {
+		return action.Tokens()
 	}
 	return nil
 }
@@ -69,24 +196,47 @@ const (
 	UpdateInfoType
 	GrantPowerOfAttorneyType
 	RevokePowerOfAttorneyType
+	RotateKeyType
+	RegisterGuardiansType
+	RequestRecoveryType
+	CancelRecoveryType
+	CompleteRecoveryType
+	TransferHandleType
+	ReleaseHandleType
+	KeyExchangeType
+	JoinCollectiveType
+	BatchType
 	Invalid
 )
 
+// ExtendedFlag is set on the kind byte of actions carrying the optional
+// fields of their kind: the work seed of a JoinNetwork and the scope of a
+// GrantPowerOfAttorney. Actions without them keep the format they had before
+// these fields existed.
+const ExtendedFlag byte = 0x40
+
+// Kind returns the kind of an attorney action, regardless of the flags of its
+// kind byte.
 func Kind(data []byte) byte {
-	if len(data) < 14 {
+	if len(data) < 15 {
 		return Invalid
 	}
 	if data[0] != 0 || data[1] != actions.IVoid || data[10] != 1 {
 		return Invalid
 	}
-	return data[14]
+	return data[14] &^ (CollectiveFlag | ExtendedFlag)
 }
 
+// JoinNetwork claims Handle for Author. Joins of handles requiring proof of
+// work bind it to a work seed (see JoinWork). The seed is only serialized,
+// behind ExtendedFlag, if it is not zero, so that joins of handles that need
+// no work keep their original format.
 type JoinNetwork struct {
 	Epoch     uint64
 	Author    crypto.Token
 	Handle    string
 	Details   string
+	Seed      crypto.Hash // work seed the proof of work is bound to
 	Signature crypto.Signature
 }
 
@@ -94,19 +244,28 @@ func (j *JoinNetwork) Tokens() []crypto.Token {
 	return []crypto.Token{j.Author}
 }
 
-func (j *JoinNetwork) Validate(v ActionValidator) bool {
-	captionHash := crypto.Hasher([]byte(j.Handle))
-	if v.HasCaption(captionHash) {
-		return false
+func (j *JoinNetwork) Validate(v ActionValidator) error {
+	if !json.Valid([]byte(j.Details)) {
+		return ErrMalformedJSON
 	}
-	authorHash := crypto.Hasher(j.Author[:])
-	if v.HasMember(authorHash) {
-		return false
+	if v.HasMember(j.Author) || v.IsRetired(j.Author) {
+		return ErrConflict
 	}
-	if !json.Valid([]byte(j.Details)) {
-		return false
+	if v.HasHandle(j.Handle) {
+		return ErrHandleTaken
+	}
+	work := j.Work()
+	if err := checkWork(v, j.Seed, j.Handle, work); err != nil {
+		return err
 	}
-	return v.SetNewMember(authorHash, captionHash)
+	if !v.CanJoin() {
+		return ErrRateLimited
+	}
+	if !v.SetNewMember(j.Author, j.Handle, work) {
+		return ErrHandleTaken
+	}
+	v.SetNewProfile(j.Author, j.Details)
+	return nil
 }
 
 func (j *JoinNetwork) Kind() byte {
@@ -120,10 +279,17 @@ func (j *JoinNetwork) serializeToSign() []byte {
 	util.PutByte(0, &bytes)
 	util.PutByte(0, &bytes)
 	util.PutByte(0, &bytes)
-	util.PutByte(JoinNetworkType, &bytes)
+	if j.Seed == (crypto.Hash{}) {
+		util.PutByte(JoinNetworkType, &bytes)
+	} else {
+		util.PutByte(JoinNetworkType|ExtendedFlag, &bytes)
+	}
 	util.PutToken(j.Author, &bytes)
 	util.PutString(j.Handle, &bytes)
 	util.PutString(j.Details, &bytes)
+	if j.Seed != (crypto.Hash{}) {
+		util.PutHash(j.Seed, &bytes)
+	}
 	return bytes
 }
 
@@ -139,47 +305,62 @@ func (j *JoinNetwork) Sign(key crypto.PrivateKey) {
 }
 
 func ParseJoinNetwork(data []byte) *JoinNetwork {
-	if data[0] != 0 || data[1] != actions.IVoid || len(data) < 14 {
-		return nil
+	action, _ := ParseAction(data).(*JoinNetwork)
+	return action
+}
+
+func parseJoinNetwork(data []byte) (*JoinNetwork, error) {
+	if len(data) < 15 || data[0] != 0 || data[1] != actions.IVoid {
+		return nil, ErrMalformed
 	}
 	join := JoinNetwork{}
 	position := 2
 	join.Epoch, position = util.ParseUint64(data, position)
 	// check if it is pure axe protocol
 	if data[position] != 1 || data[position+1] != 0 || data[position+2] != 0 || data[position+3] != 0 {
-		return nil
+		return nil, ErrMalformed
 	}
-	if data[position+4] != JoinNetworkType {
-		return nil
+	if data[position+4]&^ExtendedFlag != JoinNetworkType {
+		return nil, ErrMalformed
 	}
+	seeded := data[position+4]&ExtendedFlag != 0
 	position = position + 5
 	join.Author, position = util.ParseToken(data, position)
 	join.Handle, position = util.ParseString(data, position)
-	join.Handle = strings.TrimSpace(join.Handle)
-	if len(join.Handle) == 0 {
-		return nil
+	canonical, ok := CanonicalHandle(join.Handle)
+	if !ok {
+		return nil, ErrInvalidHandle
 	}
 	join.Details, position = util.ParseString(data, position)
 	if len(join.Details) > 0 && !json.Valid([]byte(join.Details)) {
-		return nil
+		return nil, ErrMalformedJSON
+	}
+	if seeded {
+		join.Seed, position = util.ParseHash(data, position)
+		if join.Seed == (crypto.Hash{}) {
+			return nil, ErrMalformed
+		}
 	}
 	hashPosition := position
 	join.Signature, position = util.ParseSignature(data, position)
-	if position > len(data) {
-		return nil
+	if position != len(data) {
+		return nil, ErrMalformed
 	}
 	if !join.Author.Verify(data[0:hashPosition], join.Signature) {
-		return nil
+		return nil, ErrBadSignature
 	}
-	return &join
+	join.Handle = canonical
+	return &join, nil
 }
 
 type UpdateInfo struct {
-	Epoch     uint64
-	Author    crypto.Token
-	Details   string
-	Signer    crypto.Token
-	Signature crypto.Signature
+	Epoch        uint64
+	Author       crypto.Token
+	Details      string
+	Signer       crypto.Token
+	Signature    crypto.Signature
+	Collective   bool // signed by the collective controlling Author
+	Cosignatures Cosignatures
 }
 
 func (u *UpdateInfo) Tokens() []crypto.Token {
@@ -190,17 +371,21 @@ func (u *UpdateInfo) Tokens() []crypto.Token {
 	}
 }
 
-func (u *UpdateInfo) Validate(v ActionValidator) bool {
-	if !v.HasMember(crypto.HashToken(u.Author)) {
-		return false
+func (u *UpdateInfo) Validate(v ActionValidator) error {
+	if !v.HasMember(u.Author) {
+		return ErrNotMember
 	}
-	if !u.Signer.Equal(u.Author) {
-		hash := crypto.Hasher(append(u.Author[:], u.Signer[:]...))
-		if !v.PowerOfAttorney(hash) {
-			return false
-		}
+	if u.Collective && !isSignedByCollective(v, u.Author, u.Cosignatures) {
+		return ErrNotEnoughSignatures
+	}
+	if !v.PowerOfAttorneyFor(u.Author, u.Signer, UpdateInfoType, 0) {
+		return ErrNoPowerOfAttorney
+	}
+	if !json.Valid([]byte(u.Details)) {
+		return ErrMalformedJSON
 	}
-	return json.Valid([]byte(u.Details))
+	v.SetNewProfile(u.Author, u.Details)
+	return nil
 }
 
 func (u *UpdateInfo) Kind() byte {
@@ -209,7 +394,11 @@ func (u *UpdateInfo) Kind() byte {
 
 func (u *UpdateInfo) Serialize() []byte {
 	bytes := u.serializeToSign()
-	util.PutSignature(u.Signature, &bytes)
+	if u.Collective {
+		u.Cosignatures.serialize(&bytes)
+	} else {
+		util.PutSignature(u.Signature, &bytes)
+	}
 	return bytes
 }
 
@@ -220,71 +409,117 @@ func (u *UpdateInfo) serializeToSign() []byte {
 	util.PutByte(0, &bytes)
 	util.PutByte(0, &bytes)
 	util.PutByte(0, &bytes)
-	util.PutByte(UpdateInfoType, &bytes)
+	util.PutByte(collectiveKind(UpdateInfoType, u.Collective), &bytes)
 	util.PutToken(u.Author, &bytes)
 	util.PutString(u.Details, &bytes)
 	util.PutToken(u.Signer, &bytes)
 	return bytes
 }
 
+// Sign signs the update with the key of Signer. Updates of a collective are
+// signed by Author and each call adds the signature of a token of the
+// collective.
 func (u *UpdateInfo) Sign(pk crypto.PrivateKey) {
 	bytes := u.serializeToSign()
+	if u.Collective {
+		u.Cosignatures = cosign(u.Cosignatures, bytes, pk)
+		return
+	}
 	u.Signature = pk.Sign(bytes)
 }
 
 func ParseUpdateInfo(data []byte) *UpdateInfo {
-	if data[0] != 0 || data[1] != actions.IVoid || len(data) < 14 {
-		return nil
+	action, _ := ParseAction(data).(*UpdateInfo)
+	return action
+}
+
+func parseUpdateInfo(data []byte) (*UpdateInfo, error) {
+	if len(data) < 15 || data[0] != 0 || data[1] != actions.IVoid {
+		return nil, ErrMalformed
 	}
 	update := UpdateInfo{}
 	position := 2
 	update.Epoch, position = util.ParseUint64(data, position)
 	// check if it is pure axe protocol
 	if data[position] != 1 || data[position+1] != 0 || data[position+2] != 0 || data[position+3] != 0 {
-		return nil
+		return nil, ErrMalformed
 	}
-	if data[position+4] != UpdateInfoType {
-		return nil
+	if data[position+4]&^CollectiveFlag != UpdateInfoType {
+		return nil, ErrMalformed
 	}
+	update.Collective = data[position+4]&CollectiveFlag != 0
 	position = position + 5
 	update.Author, position = util.ParseToken(data, position)
 	update.Details, position = util.ParseString(data, position)
 	if !json.Valid([]byte(update.Details)) {
-		return nil
+		return nil, ErrMalformedJSON
 	}
 	update.Signer, position = util.ParseToken(data, position)
+	if update.Collective {
+		if !update.Signer.Equal(update.Author) {
+			return nil, ErrMalformed
+		}
+		var err error
+		update.Cosignatures, err = parseCosignatures(data, position)
+		if err != nil {
+			return nil, err
+		}
+		return &update, nil
+	}
 	hashPosition := position
 	update.Signature, position = util.ParseSignature(data, position)
-	if position > len(data) {
-		return nil
+	if position != len(data) {
+		return nil, ErrMalformed
 	}
-	if !update.Author.Verify(data[0:hashPosition], update.Signature) {
-		return nil
+	if !update.Signer.Verify(data[0:hashPosition], update.Signature) {
+		return nil, ErrBadSignature
 	}
-	return &update
+	return &update, nil
 }
 
+// GrantPowerOfAttorney grants attorney the power to sign actions on behalf
+// of author. The grant may be restricted by Scope. Only restricted grants
+// carry the scope, behind ExtendedFlag, so that unrestricted grants keep the
+// format of grants signed before scopes existed. Fingerprint must carry the attestation of
+// the attorney accepting the grant (see VerifyAttestation).
 type GrantPowerOfAttorney struct {
-	Epoch       uint64
-	Author      crypto.Token
-	Attorney    crypto.Token
-	Fingerprint []byte
-	Signature   crypto.Signature
+	Epoch        uint64
+	Author       crypto.Token
+	Attorney     crypto.Token
+	Fingerprint  []byte
+	Scope        Scope
+	Signature    crypto.Signature
+	Collective   bool // signed by the collective controlling Author
+	Cosignatures Cosignatures
 }
 
 func (g *GrantPowerOfAttorney) Tokens() []crypto.Token {
 	return []crypto.Token{g.Author, g.Attorney}
 }
 
-func (g *GrantPowerOfAttorney) Validate(v ActionValidator) bool {
-	if !v.HasMember(crypto.HashToken(g.Author)) {
-		return false
+// IsAttested checks if the fingerprint of the grant is a valid attestation by
+// the attorney.
+func (g *GrantPowerOfAttorney) IsAttested() bool {
+	return VerifyAttestation(g.Fingerprint, g.Author, g.Attorney, g.Scope)
+}
+
+func (g *GrantPowerOfAttorney) Validate(v ActionValidator) error {
+	if !g.IsAttested() {
+		return ErrBadSignature
 	}
-	hash := crypto.Hasher(append(g.Author[:], g.Attorney[:]...))
-	if v.PowerOfAttorney(hash) {
-		return false
+	if !v.HasMember(g.Author) {
+		return ErrNotMember
+	}
+	if g.Collective && !isSignedByCollective(v, g.Author, g.Cosignatures) {
+		return ErrNotEnoughSignatures
+	}
+	if v.IsRetired(g.Attorney) || v.PowerOfAttorney(g.Author, g.Attorney) {
+		return ErrConflict
+	}
+	if !v.SetNewGrantPower(g.Author, g.Attorney, g.Scope, g.Fingerprint) {
+		return ErrConflict
 	}
-	return v.SetNewGrantPower(hash)
+	return nil
 }
 
 func (g *GrantPowerOfAttorney) Kind() byte {
@@ -293,7 +528,11 @@ func (g *GrantPowerOfAttorney) Kind() byte {
 
 func (g *GrantPowerOfAttorney) Serialize() []byte {
 	bytes := g.serializeToSign()
-	util.PutSignature(g.Signature, &bytes)
+	if g.Collective {
+		g.Cosignatures.serialize(&bytes)
+	} else {
+		util.PutSignature(g.Signature, &bytes)
+	}
 	return bytes
 }
 
@@ -304,67 +543,108 @@ func (g *GrantPowerOfAttorney) serializeToSign() []byte {
 	util.PutByte(0, &bytes)
 	util.PutByte(0, &bytes)
 	util.PutByte(0, &bytes)
-	util.PutByte(GrantPowerOfAttorneyType, &bytes)
+	kind := collectiveKind(GrantPowerOfAttorneyType, g.Collective)
+	if !g.Scope.IsUnrestricted() {
+		kind |= ExtendedFlag
+	}
+	util.PutByte(kind, &bytes)
 	util.PutToken(g.Author, &bytes)
 	util.PutByteArray(g.Fingerprint, &bytes)
 	util.PutToken(g.Attorney, &bytes)
+	if !g.Scope.IsUnrestricted() {
+		g.Scope.serialize(&bytes)
+	}
 	return bytes
 }
 
+// Sign signs the grant with the key of Author. Grants of a collective are
+// cosigned: each call adds the signature of a token of the collective.
 func (g *GrantPowerOfAttorney) Sign(pk crypto.PrivateKey) {
 	bytes := g.serializeToSign()
+	if g.Collective {
+		g.Cosignatures = cosign(g.Cosignatures, bytes, pk)
+		return
+	}
 	g.Signature = pk.Sign(bytes)
 }
 
 func ParseGrantPowerOfAttorney(data []byte) *GrantPowerOfAttorney {
-	if data[0] != 0 || data[1] != actions.IVoid || len(data) < 14 {
-		return nil
+	action, _ := ParseAction(data).(*GrantPowerOfAttorney)
+	return action
+}
+
+func parseGrantPowerOfAttorney(data []byte) (*GrantPowerOfAttorney, error) {
+	if len(data) < 15 || data[0] != 0 || data[1] != actions.IVoid {
+		return nil, ErrMalformed
 	}
 	grant := GrantPowerOfAttorney{}
 	position := 2
 	grant.Epoch, position = util.ParseUint64(data, position)
 	// check if it is pure axe protocol
 	if data[position] != 1 || data[position+1] != 0 || data[position+2] != 0 || data[position+3] != 0 {
-		return nil
+		return nil, ErrMalformed
 	}
-	if data[position+4] != GrantPowerOfAttorneyType {
-		return nil
+	if data[position+4]&^(CollectiveFlag|ExtendedFlag) != GrantPowerOfAttorneyType {
+		return nil, ErrMalformed
 	}
+	grant.Collective = data[position+4]&CollectiveFlag != 0
+	scoped := data[position+4]&ExtendedFlag != 0
 	position = position + 5
 	grant.Author, position = util.ParseToken(data, position)
 	grant.Fingerprint, position = util.ParseByteArray(data, position)
 	grant.Attorney, position = util.ParseToken(data, position)
+	if scoped {
+		grant.Scope, position = parseScope(data, position)
+		if position > len(data) || grant.Scope.IsUnrestricted() {
+			return nil, ErrMalformed
+		}
+	}
+	if grant.Collective {
+		var err error
+		grant.Cosignatures, err = parseCosignatures(data, position)
+		if err != nil {
+			return nil, err
+		}
+		return &grant, nil
+	}
 	hashPosition := position
 	grant.Signature, position = util.ParseSignature(data, position)
-	if position > len(data) {
-		return nil
+	if position != len(data) {
+		return nil, ErrMalformed
 	}
 	if !grant.Author.Verify(data[0:hashPosition], grant.Signature) {
-		return nil
+		return nil, ErrBadSignature
 	}
-	return &grant
+	return &grant, nil
 }
 
 type RevokePowerOfAttorney struct {
-	Epoch     uint64
-	Author    crypto.Token
-	Attorney  crypto.Token
-	Signature crypto.Signature
+	Epoch        uint64
+	Author       crypto.Token
+	Attorney     crypto.Token
+	Signature    crypto.Signature
+	Collective   bool // signed by the collective controlling Author
+	Cosignatures Cosignatures
 }
 
 func (r *RevokePowerOfAttorney) Tokens() []crypto.Token {
 	return []crypto.Token{r.Author, r.Attorney}
 }
 
-func (r *RevokePowerOfAttorney) Validate(v ActionValidator) bool {
-	if !v.HasMember(crypto.HashToken(r.Author)) {
-		return false
+func (r *RevokePowerOfAttorney) Validate(v ActionValidator) error {
+	if !v.HasMember(r.Author) {
+		return ErrNotMember
 	}
-	hash := crypto.Hasher(append(r.Author[:], r.Attorney[:]...))
-	if !v.PowerOfAttorney(hash) {
-		return false
+	if r.Collective && !isSignedByCollective(v, r.Author, r.Cosignatures) {
+		return ErrNotEnoughSignatures
 	}
-	return v.SetNewRevokePower(hash)
+	if r.Author.Equal(r.Attorney) || !v.PowerOfAttorney(r.Author, r.Attorney) {
+		return ErrNoPowerOfAttorney
+	}
+	if !v.SetNewRevokePower(r.Author, r.Attorney) {
+		return ErrConflict
+	}
+	return nil
 }
 
 func (r *RevokePowerOfAttorney) Kind() byte {
@@ -378,7 +658,7 @@ func (r *RevokePowerOfAttorney) serializeToSign() []byte {
 	util.PutByte(0, &bytes)
 	util.PutByte(0, &bytes)
 	util.PutByte(0, &bytes)
-	util.PutByte(RevokePowerOfAttorneyType, &bytes)
+	util.PutByte(collectiveKind(RevokePowerOfAttorneyType, r.Collective), &bytes)
 	util.PutToken(r.Author, &bytes)
 	util.PutToken(r.Attorney, &bytes)
 	return bytes
@@ -386,50 +666,77 @@ func (r *RevokePowerOfAttorney) serializeToSign() []byte {
 
 func (r *RevokePowerOfAttorney) Serialize() []byte {
 	bytes := r.serializeToSign()
-	util.PutSignature(r.Signature, &bytes)
+	if r.Collective {
+		r.Cosignatures.serialize(&bytes)
+	} else {
+		util.PutSignature(r.Signature, &bytes)
+	}
 	return bytes
 }
 
+// Sign signs the revocation with the key of Author. Revocations of a
+// collective are cosigned: each call adds the signature of a token of the
+// collective.
 func (r *RevokePowerOfAttorney) Sign(pk crypto.PrivateKey) {
 	bytes := r.serializeToSign()
+	if r.Collective {
+		r.Cosignatures = cosign(r.Cosignatures, bytes, pk)
+		return
+	}
 	r.Signature = pk.Sign(bytes)
 }
 
 func ParseRevokePowerOfAttorney(data []byte) *RevokePowerOfAttorney {
-	if data[0] != 0 || data[1] != actions.IVoid || len(data) < 14 {
-		return nil
+	action, _ := ParseAction(data).(*RevokePowerOfAttorney)
+	return action
+}
+
+func parseRevokePowerOfAttorney(data []byte) (*RevokePowerOfAttorney, error) {
+	if len(data) < 15 || data[0] != 0 || data[1] != actions.IVoid {
+		return nil, ErrMalformed
 	}
 	revoke := RevokePowerOfAttorney{}
 	position := 2
 	revoke.Epoch, position = util.ParseUint64(data, position)
 	// check if it is pure axe protocol
 	if data[position] != 1 || data[position+1] != 0 || data[position+2] != 0 || data[position+3] != 0 {
-		return nil
+		return nil, ErrMalformed
 	}
-	if data[position+4] != RevokePowerOfAttorneyType {
-		return nil
+	if data[position+4]&^CollectiveFlag != RevokePowerOfAttorneyType {
+		return nil, ErrMalformed
 	}
+	revoke.Collective = data[position+4]&CollectiveFlag != 0
 	position = position + 5
 	revoke.Author, position = util.ParseToken(data, position)
 	revoke.Attorney, position = util.ParseToken(data, position)
+	if revoke.Collective {
+		var err error
+		revoke.Cosignatures, err = parseCosignatures(data, position)
+		if err != nil {
+			return nil, err
+		}
+		return &revoke, nil
+	}
 	hashPosition := position
 	revoke.Signature, position = util.ParseSignature(data, position)
-	if position > len(data) {
-		return nil
+	if position != len(data) {
+		return nil, ErrMalformed
 	}
 	if !revoke.Author.Verify(data[0:hashPosition], revoke.Signature) {
-		return nil
+		return nil, ErrBadSignature
 	}
-	return &revoke
+	return &revoke, nil
 }
 
 type Void struct {
-	Epoch     uint64
-	Protocol  uint32
-	Author    crypto.Token
-	Data      []byte
-	Signer    crypto.Token
-	Signature crypto.Signature
+	Epoch        uint64
+	Protocol     uint32
+	Author       crypto.Token
+	Data         []byte
+	Signer       crypto.Token
+	Signature    crypto.Signature
+	Collective   bool // signed by the collective controlling Author
+	Cosignatures Cosignatures
 }
 
 func (g *Void) Tokens() []crypto.Token {
@@ -440,31 +747,39 @@ func (g *Void) Tokens() []crypto.Token {
 	}
 }
 
-func (void *Void) Validate(v ActionValidator) bool {
-	if void.Epoch > v.Epoch() {
-		return false
+func (void *Void) Validate(v ActionValidator) error {
+	if !v.HasMember(void.Author) {
+		return ErrNotMember
 	}
-	if !v.HasMember(crypto.HashToken(void.Author)) {
-		return false
+	if void.Collective && !isSignedByCollective(v, void.Author, void.Cosignatures) {
+		return ErrNotEnoughSignatures
 	}
-	if !void.Signer.Equal(void.Author) {
-		hash := crypto.Hasher(append(void.Author[:], void.Signer[:]...))
-		if !v.PowerOfAttorney(hash) {
-			return false
-		}
+	protocol, _ := void.DownstreamProtocol()
+	if !v.PowerOfAttorneyFor(void.Author, void.Signer, VoidType, protocol) {
+		return ErrNoPowerOfAttorney
 	}
-	return true
+	return nil
 }
 
 func (v *Void) Kind() byte {
 	return VoidType
 }
 
+// DownstreamProtocol returns the code of the protocol carried by the void
+// action, read from the first four bytes of its data.
+func (v *Void) DownstreamProtocol() (uint32, bool) {
+	if len(v.Data) < 4 {
+		return 0, false
+	}
+	protocol, _ := util.ParseUint32(v.Data, 0)
+	return protocol, true
+}
+
 func (v *Void) serializeToSign() []byte {
 	bytes := []byte{0, actions.IVoid}
 	util.PutUint64(v.Epoch, &bytes)
 	util.PutUint32(v.Protocol, &bytes)
-	util.PutByte(VoidType, &bytes)
+	util.PutByte(collectiveKind(VoidType, v.Collective), &bytes)
 	util.PutToken(v.Author, &bytes)
 	bytes = append(bytes, v.Data...)
 	util.PutToken(v.Signer, &bytes)
@@ -473,53 +788,191 @@ func (v *Void) serializeToSign() []byte {
 
 func (v *Void) Serialize() []byte {
 	bytes := v.serializeToSign()
-	util.PutSignature(v.Signature, &bytes)
+	if v.Collective {
+		v.Cosignatures.serializeTrailing(&bytes)
+	} else {
+		util.PutSignature(v.Signature, &bytes)
+	}
 	return bytes
 }
 
+// Sign signs the void action with the key of Signer. Void actions of a
+// collective are signed by Author and each call adds the signature of a token
+// of the collective.
 func (v *Void) Sign(pk crypto.PrivateKey) {
 	bytes := v.serializeToSign()
+	if v.Collective {
+		v.Cosignatures = cosign(v.Cosignatures, bytes, pk)
+		return
+	}
 	v.Signature = pk.Sign(bytes)
 }
 
 func ParseVoid(data []byte) *Void {
-	if data[0] != 0 || data[1] != actions.IVoid || len(data) < 14 {
-		return nil
+	action, _ := ParseAction(data).(*Void)
+	return action
+}
+
+// parseVoid parses a bare void action. Its data runs up to the signer and
+// signature, or the cosignatures, that end the action.
+func parseVoid(data []byte) (*Void, error) {
+	if len(data) < 15 || data[0] != 0 || data[1] != actions.IVoid {
+		return nil, ErrMalformed
 	}
 	void := Void{}
 	position := 2
 	void.Epoch, position = util.ParseUint64(data, position)
 	void.Protocol, position = util.ParseUint32(data, position)
 	// Handles Void Type
-	if data[position] != VoidType {
-		return nil
+	if data[position]&^CollectiveFlag != VoidType {
+		return nil, ErrMalformed
+	}
+	void.Collective = data[position]&CollectiveFlag != 0
+	end := len(data) - crypto.SignatureSize
+	if void.Collective {
+		var err error
+		void.Cosignatures, end, err = parseTrailingCosignatures(data)
+		if err != nil {
+			return nil, err
+		}
 	}
 	position = position + 1
 	void.Author, position = util.ParseToken(data, position)
-	if len(data)-voidTailSize < position {
-		return nil
+	if end-crypto.TokenSize < position {
+		return nil, ErrMalformed
+	}
+	void.Data = data[position : end-crypto.TokenSize]
+	void.Signer, _ = util.ParseToken(data, end-crypto.TokenSize)
+	if void.Collective {
+		if !void.Signer.Equal(void.Author) {
+			return nil, ErrMalformed
+		}
+		return &void, nil
 	}
-	void.Data = data[position : len(data)-voidTailSize]
-	position = len(data) - voidTailSize
-	void.Signer, position = util.ParseToken(data, position)
-	hashPosition := position
-	void.Signature, position = util.ParseSignature(data, position)
-	if position > len(data) {
-		return nil
+	void.Signature, _ = util.ParseSignature(data, end)
+	if !void.Signer.Verify(data[0:end], void.Signature) {
+		return nil, ErrBadSignature
 	}
-	if !void.Signer.Verify(data[0:hashPosition], void.Signature) {
-		return nil
+	return &void, nil
+}
+
+// RotateKey moves the membership, handle, profile and outstanding grants of
+// Author to Token and retires Author. It is signed by Author and optionally
+// co-signed by a Recovery token holding a power of attorney from Author for
+// RotateKeyType actions.
+type RotateKey struct {
+	Epoch             uint64
+	Author            crypto.Token
+	Token             crypto.Token
+	Recovery          crypto.Token // zero token if not co-signed
+	Signature         crypto.Signature
+	RecoverySignature crypto.Signature
+}
+
+func (r *RotateKey) Tokens() []crypto.Token {
+	if r.HasRecovery() {
+		return []crypto.Token{r.Author, r.Token, r.Recovery}
 	}
-	return &void
+	return []crypto.Token{r.Author, r.Token}
 }
 
-type KeyExchange struct {
-	Epoch     uint64
-	Author    crypto.Token
-	To        crypto.Token
-	Ephemeral crypto.Token
-	Secret    []byte
-	Attorney  crypto.Token
+func (r *RotateKey) HasRecovery() bool {
+	return !r.Recovery.Equal(crypto.ZeroToken)
+}
+
+func (r *RotateKey) Validate(v ActionValidator) error {
+	if !v.HasMember(r.Author) {
+		return ErrNotMember
+	}
+	if r.HasRecovery() && !v.PowerOfAttorneyFor(r.Author, r.Recovery, RotateKeyType, 0) {
+		return ErrNoPowerOfAttorney
+	}
+	if !v.SetNewRotateKey(r.Author, r.Token) {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *RotateKey) Kind() byte {
+	return RotateKeyType
+}
+
+func (r *RotateKey) serializeToSign() []byte {
+	bytes := []byte{0, actions.IVoid}
+	util.PutUint64(r.Epoch, &bytes)
+	util.PutByte(1, &bytes)
+	util.PutByte(0, &bytes)
+	util.PutByte(0, &bytes)
+	util.PutByte(0, &bytes)
+	util.PutByte(RotateKeyType, &bytes)
+	util.PutToken(r.Author, &bytes)
+	util.PutToken(r.Token, &bytes)
+	util.PutToken(r.Recovery, &bytes)
+	return bytes
+}
+
+func (r *RotateKey) Serialize() []byte {
+	bytes := r.serializeToSign()
+	util.PutSignature(r.Signature, &bytes)
+	if r.HasRecovery() {
+		util.PutSignature(r.RecoverySignature, &bytes)
+	}
+	return bytes
+}
+
+// Sign signs the rotation with the key of Author.
+func (r *RotateKey) Sign(pk crypto.PrivateKey) {
+	bytes := r.serializeToSign()
+	r.Signature = pk.Sign(bytes)
+}
+
+// SignRecovery co-signs the rotation with the key of Recovery.
+func (r *RotateKey) SignRecovery(pk crypto.PrivateKey) {
+	bytes := r.serializeToSign()
+	r.RecoverySignature = pk.Sign(bytes)
+}
+
+func ParseRotateKey(data []byte) *RotateKey {
+	action, _ := ParseAction(data).(*RotateKey)
+	return action
+}
+
+func parseRotateKey(data []byte) (*RotateKey, error) {
+	if len(data) < 15 || data[0] != 0 || data[1] != actions.IVoid {
+		return nil, ErrMalformed
+	}
+	rotate := RotateKey{}
+	position := 2
+	rotate.Epoch, position = util.ParseUint64(data, position)
+	// check if it is pure axe protocol
+	if data[position] != 1 || data[position+1] != 0 || data[position+2] != 0 || data[position+3] != 0 {
+		return nil, ErrMalformed
+	}
+	if data[position+4] != RotateKeyType {
+		return nil, ErrMalformed
+	}
+	position = position + 5
+	rotate.Author, position = util.ParseToken(data, position)
+	rotate.Token, position = util.ParseToken(data, position)
+	rotate.Recovery, position = util.ParseToken(data, position)
+	hashPosition := position
+	rotate.Signature, position = util.ParseSignature(data, position)
+	if rotate.HasRecovery() {
+		rotate.RecoverySignature, position = util.ParseSignature(data, position)
+	}
+	if position != len(data) {
+		return nil, ErrMalformed
+	}
+	if rotate.Author.Equal(rotate.Token) || rotate.Token.Equal(crypto.ZeroToken) {
+		return nil, ErrMalformed
+	}
+	if !rotate.Author.Verify(data[0:hashPosition], rotate.Signature) {
+		return nil, ErrBadSignature
+	}
+	if rotate.HasRecovery() && !rotate.Recovery.Verify(data[0:hashPosition], rotate.RecoverySignature) {
+		return nil, ErrBadSignature
+	}
+	return &rotate, nil
 }
 
 // iisAxeNonVoid checks if a byte array has the header of an axé action different from
@@ -529,7 +982,7 @@ func IsAxeNonVoid(action []byte) bool {
 	if len(action) < 15 {
 		return false
 	}
-	if action[0] != 0 || action[1] != 0 || action[10] != 1 || action[11] != 0 || action[12] != 0 || action[13] != 0 || action[14] == 0 {
+	if action[0] != 0 || action[1] != 0 || action[10] != 1 || action[11] != 0 || action[12] != 0 || action[13] != 0 || action[14]&^(CollectiveFlag|ExtendedFlag) == 0 {
 		return false
 	}
 	return true
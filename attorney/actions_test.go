@@ -0,0 +1,144 @@
+package attorney
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/protocol/actions"
+	"github.com/freehandle/breeze/util"
+)
+
+// dress appends the wallet, fee and signature breeze appends to actions on
+// chain.
+func dress(data []byte) []byte {
+	_, wallet := crypto.RandomAsymetricKey()
+	return actions.Dress(data, wallet, 1)
+}
+
+func (m testMember) void(epoch uint64, author testMember, data []byte) []byte {
+	void := Void{Epoch: epoch, Protocol: 1, Author: author.token, Data: data, Signer: m.token}
+	void.Sign(m.key)
+	return void.Serialize()
+}
+
+func TestDressedActions(t *testing.T) {
+	alice, bob, attorney := newTestMember(), newTestMember(), newTestMember()
+	cancel := CancelRecovery{Epoch: 1, Author: alice.token}
+	cancel.Sign(alice.key)
+	collective := newTestCollective(1, alice, bob)
+	all := map[byte][]byte{
+		VoidType:                  alice.void(1, alice, []byte{2, 0, 0, 0, 1}),
+		JoinNetworkType:           alice.join(1, "alice"),
+		UpdateInfoType:            attorney.updateFor(1, alice, `{}`),
+		GrantPowerOfAttorneyType:  alice.grant(1, attorney, Scope{Expiry: 10}),
+		RevokePowerOfAttorneyType: alice.revoke(1, attorney),
+		RotateKeyType:             alice.rotate(1, bob),
+		RegisterGuardiansType:     alice.guard(1, 1, bob),
+		RequestRecoveryType:       alice.requestRecovery(1, attorney, bob),
+		CancelRecoveryType:        cancel.Serialize(),
+		CompleteRecoveryType:      alice.completeRecovery(1, attorney),
+		TransferHandleType:        alice.transfer(1, "alice", bob),
+		ReleaseHandleType:         alice.release(1, "alice"),
+		KeyExchangeType:           alice.exchange(1, alice, bob, "key"),
+		JoinCollectiveType:        joinCollective(1, "both", collective, alice),
+		BatchType:                 alice.batch(1, alice.join(1, "alice"), alice.grant(1, attorney, Scope{})),
+	}
+	collectives := [][]byte{
+		collectiveUpdate(1, collective, `{}`, alice),
+		collectiveGrant(1, collective, attorney, bob),
+		collectiveRevoke(1, collective, attorney, alice),
+		collectiveVoid(1, collective, bob),
+	}
+	for _, data := range collectives {
+		all[Kind(data)|CollectiveFlag] = data
+	}
+	for kind, data := range all {
+		bare := ParseAction(data)
+		dressed := ParseAction(dress(data))
+		if bare == nil || dressed == nil || dressed.Kind() != kind&^CollectiveFlag {
+			t.Fatalf("could not parse dressed action of kind %v", kind)
+		}
+		if !bytes.Equal(dressed.Serialize(), data) {
+			t.Fatalf("dressed action of kind %v parsed differently", kind)
+		}
+		if len(GetTokens(dress(data))) == 0 {
+			t.Fatalf("dressed action of kind %v not routed", kind)
+		}
+	}
+	if ParseRotateKey(dress(all[RotateKeyType])) == nil || ParseVoid(dress(all[VoidType])) == nil {
+		t.Fatal("dressed actions not parsed by their kind")
+	}
+	if ParseAction(append(all[UpdateInfoType], 0)) != nil {
+		t.Fatal("action with a trailing byte parsed")
+	}
+}
+
+func TestValidateDressedAction(t *testing.T) {
+	alice, attorney := newTestMember(), newTestMember()
+	state := NewGenesisState("")
+	join := alice.join(1, "alice")
+	incorporate(t, state, 1, dress(join))
+	update := attorney.updateFor(2, alice, `{"n":1}`)
+	if err := state.Validator().ValidateAction(dress(update)); !errors.Is(err, ErrNoPowerOfAttorney) {
+		t.Fatalf("expected dressed update of a non attorney rejected, got %v", err)
+	}
+	tampered := dress(alice.update(2, `{}`))
+	tampered[20] ^= 1
+	if err := state.Validator().ValidateAction(tampered); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected bad signature, got %v", err)
+	}
+	// the action is the same whichever wallet dresses it
+	validator := state.Validator()
+	for _, data := range [][]byte{join, dress(join)} {
+		if err := validator.ValidateAction(data); !errors.Is(err, ErrReplayed) {
+			t.Fatalf("expected replayed join, got %v", err)
+		}
+	}
+	void := dress(alice.void(2, alice, []byte{2, 0, 0, 0}))
+	if err := validator.ValidateAction(void); err != nil {
+		t.Fatalf("dressed void rejected: %v", err)
+	}
+	if err := validator.ValidateAction(dress(ParseVoid(void).Serialize())); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected void dressed by another wallet replayed, got %v", err)
+	}
+}
+
+// Joins without work and unrestricted grants keep the format they had before
+// work seeds and scopes.
+func TestLegacyFormats(t *testing.T) {
+	alice, attorney := newTestMember(), newTestMember()
+	join := JoinNetwork{Epoch: 1, Author: alice.token, Handle: "alice", Details: `{}`}
+	join.Sign(alice.key)
+	legacy := axeHeader(1, JoinNetworkType)
+	util.PutToken(alice.token, &legacy)
+	util.PutString("alice", &legacy)
+	util.PutString(`{}`, &legacy)
+	if !bytes.Equal(join.serializeToSign(), legacy) {
+		t.Fatal("join without work changed format")
+	}
+	join.Seed = crypto.Hasher([]byte("seed"))
+	join.Sign(alice.key)
+	if data := join.Serialize(); data[14] != JoinNetworkType|ExtendedFlag || ParseJoinNetwork(data).Seed != join.Seed {
+		t.Fatal("join with work does not carry its seed")
+	}
+	grant := GrantPowerOfAttorney{Epoch: 1, Author: alice.token, Attorney: attorney.token, Fingerprint: []byte{1}}
+	legacy = axeHeader(1, GrantPowerOfAttorneyType)
+	util.PutToken(alice.token, &legacy)
+	util.PutByteArray([]byte{1}, &legacy)
+	util.PutToken(attorney.token, &legacy)
+	if !bytes.Equal(grant.serializeToSign(), legacy) {
+		t.Fatal("unrestricted grant changed format")
+	}
+	grant.Scope = Scope{Expiry: 10}
+	grant.Sign(alice.key)
+	data := grant.Serialize()
+	if data[14] != GrantPowerOfAttorneyType|ExtendedFlag || ParseGrantPowerOfAttorney(data).Scope.Expiry != 10 {
+		t.Fatal("scoped grant does not carry its scope")
+	}
+	data[14] = GrantPowerOfAttorneyType
+	if ParseGrantPowerOfAttorney(data) != nil {
+		t.Fatal("scope parsed without its flag")
+	}
+}
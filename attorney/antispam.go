@@ -0,0 +1,191 @@
+package attorney
+
+import (
+	"math/bits"
+	"unicode/utf8"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// WorkHandleLength is the length from which a handle requires only the base
+// proof of work of the network. Shorter handles require JoinWorkStep more bits
+// of work for each missing character.
+const WorkHandleLength = 8
+
+var (
+	maxJoinsKey     = crypto.Hasher([]byte("max joins per epoch"))
+	joinWorkKey     = crypto.Hasher([]byte("join work"))
+	joinWorkStepKey = crypto.Hasher([]byte("join work step"))
+)
+
+// latestSeedKey is the key of the latest work seed on the seeds vault.
+var latestSeedKey = crypto.Hasher([]byte("latest work seed"))
+
+// JoinWork returns the proof of work of joining the network with handle at
+// epoch: the number of leading zero bits of the hash of the work seed, epoch,
+// author, the canonical handle and details. Clients look for enough work by
+// varying a field of details.
+//
+// The work seed chains the hashes of every action accepted by the network,
+// so work can only start once the seed is known and the signer cannot pick
+// it ahead. In a quiet network the latest seed stays the same until another
+// action is accepted.
+func JoinWork(seed crypto.Hash, epoch uint64, author crypto.Token, handle, details string) uint64 {
+	if canonical, ok := CanonicalHandle(handle); ok {
+		handle = canonical
+	}
+	bytes := []byte{}
+	util.PutHash(seed, &bytes)
+	util.PutUint64(epoch, &bytes)
+	util.PutToken(author, &bytes)
+	util.PutString(handle, &bytes)
+	util.PutString(details, &bytes)
+	hash := crypto.Hasher(bytes)
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return uint64(zeros)
+}
+
+// Work returns the proof of work of the join.
+func (j *JoinNetwork) Work() uint64 {
+	return JoinWork(j.Seed, j.Epoch, j.Author, j.Handle, j.Details)
+}
+
+// Work returns the proof of work of the join.
+func (j *JoinCollective) Work() uint64 {
+	return JoinWork(j.Seed, j.Epoch, j.Author, j.Handle, j.Details)
+}
+
+// checkWork checks the proof of work of a join for handle bound to seed. The
+// seed is only checked if handle requires work.
+func checkWork(v ActionValidator, seed crypto.Hash, handle string, work uint64) error {
+	required := v.RequiredWork(handle)
+	if required == 0 {
+		return nil
+	}
+	if !v.IsWorkSeed(seed) {
+		return ErrUnknownSeed
+	}
+	if work < required {
+		return ErrInsufficientWork
+	}
+	return nil
+}
+
+// nextSeed chains the hash of an accepted action into the work seed.
+func nextSeed(seed, hash crypto.Hash) crypto.Hash {
+	return crypto.Hasher(append(seed[:], hash[:]...))
+}
+
+// WorkSeed returns the latest work seed, the one new joins should bind their
+// work to. It is the zero hash until an action is accepted.
+func (s *State) WorkSeed() crypto.Hash {
+	var seed crypto.Hash
+	if data, ok := s.Seeds.Get(latestSeedKey); ok {
+		copy(seed[:], data)
+	}
+	return seed
+}
+
+// seedEpoch returns the epoch of the action that produced seed, while it is
+// kept by the state.
+func (s *State) seedEpoch(seed crypto.Hash) (uint64, bool) {
+	if seed == latestSeedKey {
+		return 0, false
+	}
+	data, ok := s.Seeds.Get(seed)
+	if !ok {
+		return 0, false
+	}
+	epoch, _ := util.ParseUint64(data, 0)
+	return epoch, true
+}
+
+// addSeeds chains the actions of log into the work seed and keeps every
+// intermediate seed.
+func (s *State) addSeeds(log []LogEntry) {
+	if len(log) == 0 {
+		return
+	}
+	seed := s.WorkSeed()
+	for _, entry := range log {
+		seed = nextSeed(seed, entry.Hashes[0])
+		bytes := []byte{}
+		util.PutUint64(entry.Epoch, &bytes)
+		s.Seeds.Set(seed, bytes)
+	}
+	s.Seeds.Set(latestSeedKey, seed[:])
+}
+
+// purgeSeeds forgets the seeds produced by stale actions. The latest seed is
+// always kept.
+func (s *State) purgeSeeds() {
+	s.Seeds.mu.RLock()
+	stale := make([]crypto.Hash, 0)
+	for hash, data := range s.Seeds.records {
+		epoch, _ := util.ParseUint64(data, 0)
+		if hash != latestSeedKey && IsStale(epoch, s.Epoch) {
+			stale = append(stale, hash)
+		}
+	}
+	s.Seeds.mu.RUnlock()
+	for _, hash := range stale {
+		s.Seeds.Remove(hash)
+	}
+}
+
+// RequiredWork returns the proof of work required to claim handle.
+func (s *State) RequiredWork(handle string) uint64 {
+	base := s.parameter(joinWorkKey)
+	step := s.parameter(joinWorkStepKey)
+	if length := utf8.RuneCountInString(handle); length < WorkHandleLength {
+		base += step * uint64(WorkHandleLength-length)
+	}
+	return base
+}
+
+// JoinsAt returns the number of members that joined on the block of epoch.
+// Counts are kept while epoch is within the validity window.
+func (s *State) JoinsAt(epoch uint64) uint64 {
+	data, ok := s.Joins.Get(joinsKey(epoch))
+	if !ok {
+		return 0
+	}
+	count, _ := util.ParseUint64(data, 8)
+	return count
+}
+
+func joinsKey(epoch uint64) crypto.Hash {
+	bytes := []byte{}
+	util.PutUint64(epoch, &bytes)
+	return crypto.Hasher(bytes)
+}
+
+func (s *State) addJoins(epoch, count uint64) {
+	bytes := []byte{}
+	util.PutUint64(epoch, &bytes)
+	util.PutUint64(s.JoinsAt(epoch)+count, &bytes)
+	s.Joins.Set(joinsKey(epoch), bytes)
+}
+
+// purgeJoins forgets the join counts of stale epochs.
+func (s *State) purgeJoins() {
+	s.Joins.mu.RLock()
+	stale := make([]crypto.Hash, 0)
+	for hash, data := range s.Joins.records {
+		epoch, _ := util.ParseUint64(data, 0)
+		if IsStale(epoch, s.Epoch) {
+			stale = append(stale, hash)
+		}
+	}
+	s.Joins.mu.RUnlock()
+	for _, hash := range stale {
+		s.Joins.Remove(hash)
+	}
+}
@@ -0,0 +1,100 @@
+package attorney
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// joinWithWork signs a join for handle whose work, bound to seed, meets
+// required.
+func (m testMember) joinWithWork(epoch uint64, handle string, seed crypto.Hash, required uint64) []byte {
+	join := JoinNetwork{Epoch: epoch, Author: m.token, Handle: handle, Seed: seed}
+	for nonce := 0; ; nonce++ {
+		join.Details = fmt.Sprintf(`{"nonce":%d}`, nonce)
+		if join.Work() >= required {
+			break
+		}
+	}
+	join.Sign(m.key)
+	return join.Serialize()
+}
+
+// joinShortOfWork signs a join for handle whose work, bound to seed, falls
+// short of required.
+func (m testMember) joinShortOfWork(epoch uint64, handle string, seed crypto.Hash, required uint64) []byte {
+	join := JoinNetwork{Epoch: epoch, Author: m.token, Handle: handle, Seed: seed}
+	for nonce := 0; ; nonce++ {
+		join.Details = fmt.Sprintf(`{"nonce":%d}`, nonce)
+		if join.Work() < required {
+			break
+		}
+	}
+	join.Sign(m.key)
+	return join.Serialize()
+}
+
+func TestJoinCapPerBlock(t *testing.T) {
+	state := NewGenesisStateWithParameters("", Parameters{MaxJoinsPerEpoch: 2})
+	// joins signed for different epochs still count against the same block
+	validator := validateBlock(t, state, 1, newTestMember().join(1, "first"), newTestMember().join(2, "second"))
+	if err := validator.ValidateAction(newTestMember().join(50, "third")); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected rate limited, got %v", err)
+	}
+	state.Incorporate(validator.Mutations())
+	if state.JoinsAt(1) != 2 {
+		t.Fatalf("unexpected join count %v", state.JoinsAt(1))
+	}
+	incorporate(t, state, 2, newTestMember().join(1, "third"))
+}
+
+func TestJoinWorkSeed(t *testing.T) {
+	state := NewGenesisStateWithParameters("", Parameters{JoinWork: 6})
+	alice, bob, carol := newTestMember(), newTestMember(), newTestMember()
+	genesis := state.WorkSeed()
+	incorporate(t, state, 1, alice.joinWithWork(1, "alicealice", genesis, 6))
+	seed := state.WorkSeed()
+	if seed == genesis {
+		t.Fatal("work seed did not move with accepted actions")
+	}
+	validator := state.Validator()
+	if err := validator.ValidateAction(bob.joinWithWork(2, "bobbobbob", genesis, 6)); !errors.Is(err, ErrUnknownSeed) {
+		t.Fatalf("expected superseded genesis seed rejected, got %v", err)
+	}
+	if err := validator.ValidateAction(bob.joinWithWork(2, "bobbobbob", crypto.Hasher([]byte("chosen")), 6)); !errors.Is(err, ErrUnknownSeed) {
+		t.Fatalf("expected chosen seed rejected, got %v", err)
+	}
+	if err := validator.ValidateAction(bob.joinShortOfWork(2, "bobbobbob", seed, 6)); !errors.Is(err, ErrInsufficientWork) {
+		t.Fatalf("expected insufficient work, got %v", err)
+	}
+	if err := validator.ValidateAction(bob.joinWithWork(2, "bobbobbob", seed, 6)); err != nil {
+		t.Fatalf("join bound to the latest seed rejected: %v", err)
+	}
+	// seeds of pending blocks are known to validators of later blocks
+	pending := state.Validator(validator.Mutations())
+	if pending.WorkSeed() == seed {
+		t.Fatal("pending actions did not move the work seed")
+	}
+	if err := pending.ValidateAction(carol.joinWithWork(3, "carolcarol", pending.WorkSeed(), 6)); err != nil {
+		t.Fatalf("join bound to a pending seed rejected: %v", err)
+	}
+	if err := pending.ValidateAction(alice.update(3, `{}`)); err != nil {
+		t.Fatal(err)
+	}
+	state.Incorporate(validator.Mutations())
+	state.Incorporate(pending.Mutations())
+	if recovered := StateFromBytes(state.Serialize()); recovered == nil || recovered.WorkSeed() != state.WorkSeed() {
+		t.Fatal("work seed lost in serialization")
+	}
+	// a superseded seed expires with the action that produced it
+	validator = state.Validator()
+	if !validator.IsWorkSeed(seed) {
+		t.Fatal("recent seed rejected")
+	}
+	validator.SetEpoch(2 + ActionWindow)
+	if validator.IsWorkSeed(seed) {
+		t.Fatal("stale seed accepted")
+	}
+}
@@ -0,0 +1,106 @@
+package attorney
+
+import (
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// The fingerprint of a GrantPowerOfAttorney is an attestation by the
+// attorney that it accepts the delegation. It is either an acceptance of that
+// specific grant or an acceptance of any grant under the published terms of
+// the attorney app. In both cases it is signed by the attorney, so that no
+// one can be named attorney without its consent.
+//
+//	acceptance: [AcceptanceAttestation][signature of AcceptanceMessage]
+//	terms:      [TermsAttestation][hash of terms][signature of TermsMessage]
+const (
+	AcceptanceAttestation byte = iota + 1
+	TermsAttestation
+)
+
+// AcceptanceMessage is the message signed by attorney to accept a power of
+// attorney with the given scope from author.
+func AcceptanceMessage(author, attorney crypto.Token, scope Scope) []byte {
+	bytes := []byte("handles attorney acceptance")
+	util.PutToken(author, &bytes)
+	util.PutToken(attorney, &bytes)
+	scope.serialize(&bytes)
+	return bytes
+}
+
+// NewAcceptance returns a fingerprint accepting a power of attorney with the
+// given scope from author signed with the attorney key.
+func NewAcceptance(author crypto.Token, scope Scope, key crypto.PrivateKey) []byte {
+	signature := key.Sign(AcceptanceMessage(author, key.PublicKey(), scope))
+	return append([]byte{AcceptanceAttestation}, signature[:]...)
+}
+
+// TermsMessage is the message signed by an attorney to accept any power of
+// attorney granted under terms. The domain tag keeps the signature from being
+// taken for a signature of the bare hash by other protocols.
+func TermsMessage(terms crypto.Hash) []byte {
+	bytes := []byte("handles-terms-v1")
+	util.PutHash(terms, &bytes)
+	return bytes
+}
+
+// NewTermsAttestation returns a fingerprint accepting any power of attorney
+// granted under terms signed with the attorney key. The same fingerprint may
+// be published by the attorney app and used by every grant.
+func NewTermsAttestation(terms crypto.Hash, key crypto.PrivateKey) []byte {
+	signature := key.Sign(TermsMessage(terms))
+	bytes := []byte{TermsAttestation}
+	bytes = append(bytes, terms[:]...)
+	return append(bytes, signature[:]...)
+}
+
+// TermsOf returns the hash of the terms attested by fingerprint, and false if
+// fingerprint is not a terms attestation.
+func TermsOf(fingerprint []byte) (crypto.Hash, bool) {
+	if len(fingerprint) != 1+crypto.Size+crypto.SignatureSize || fingerprint[0] != TermsAttestation {
+		return crypto.Hash{}, false
+	}
+	terms, _ := util.ParseHash(fingerprint, 1)
+	return terms, true
+}
+
+// VerifyAttestation checks if fingerprint is a valid attestation by attorney
+// of a power of attorney with the given scope from author.
+func VerifyAttestation(fingerprint []byte, author, attorney crypto.Token, scope Scope) bool {
+	if len(fingerprint) == 0 {
+		return false
+	}
+	switch fingerprint[0] {
+	case AcceptanceAttestation:
+		if len(fingerprint) != 1+crypto.SignatureSize {
+			return false
+		}
+		signature, _ := util.ParseSignature(fingerprint, 1)
+		return attorney.Verify(AcceptanceMessage(author, attorney, scope), signature)
+	case TermsAttestation:
+		terms, ok := TermsOf(fingerprint)
+		if !ok {
+			return false
+		}
+		signature, _ := util.ParseSignature(fingerprint, 1+crypto.Size)
+		return attorney.Verify(TermsMessage(terms), signature)
+	}
+	return false
+}
+
+// grantRecord is the value stored on the grants vault for every power of
+// attorney: its scope followed by the attestation of the attorney.
+func grantRecord(scope Scope, fingerprint []byte) []byte {
+	bytes := scope.Serialize()
+	util.PutByteArray(fingerprint, &bytes)
+	return bytes
+}
+
+func parseGrantRecord(data []byte) (Scope, []byte, bool) {
+	scope, position := parseScope(data, 0)
+	if position+2 > len(data) {
+		return Scope{}, nil, false
+	}
+	fingerprint, position := util.ParseByteArray(data, position)
+	return scope, fingerprint, position == len(data)
+}
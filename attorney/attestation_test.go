@@ -0,0 +1,67 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// grantWith signs a grant to attorney carrying fingerprint.
+func (m testMember) grantWith(epoch uint64, attorney testMember, scope Scope, fingerprint []byte) []byte {
+	grant := GrantPowerOfAttorney{Epoch: epoch, Author: m.token, Attorney: attorney.token, Scope: scope, Fingerprint: fingerprint}
+	grant.Sign(m.key)
+	return grant.Serialize()
+}
+
+func TestAcceptanceAttestation(t *testing.T) {
+	alice, attorney := newTestMember(), newTestMember()
+	scope := Scope{Expiry: 10, Kinds: []byte{VoidType}}
+	fingerprint := NewAcceptance(alice.token, scope, attorney.key)
+	if !VerifyAttestation(fingerprint, alice.token, attorney.token, scope) {
+		t.Fatal("acceptance not verified")
+	}
+	if VerifyAttestation(fingerprint, alice.token, attorney.token, Scope{Expiry: 11, Kinds: []byte{VoidType}}) {
+		t.Fatal("acceptance verified for another scope")
+	}
+	if VerifyAttestation(fingerprint, attorney.token, alice.token, scope) {
+		t.Fatal("acceptance verified for another author")
+	}
+	if VerifyAttestation(nil, alice.token, attorney.token, scope) {
+		t.Fatal("empty fingerprint verified")
+	}
+}
+
+func TestTermsAttestation(t *testing.T) {
+	alice, attorney := newTestMember(), newTestMember()
+	terms := crypto.Hasher([]byte("terms of the attorney app"))
+	fingerprint := NewTermsAttestation(terms, attorney.key)
+	if parsed, ok := TermsOf(fingerprint); !ok || parsed != terms {
+		t.Fatal("terms not recovered from the attestation")
+	}
+	if !VerifyAttestation(fingerprint, alice.token, attorney.token, Scope{}) {
+		t.Fatal("terms attestation not verified")
+	}
+	if VerifyAttestation(fingerprint, alice.token, alice.token, Scope{}) {
+		t.Fatal("terms attestation verified for another attorney")
+	}
+	// a signature of the bare hash, made for any other purpose, is not an
+	// acceptance of the terms
+	bare := []byte{TermsAttestation}
+	util.PutHash(terms, &bare)
+	signature := attorney.key.Sign(terms[:])
+	bare = append(bare, signature[:]...)
+	if VerifyAttestation(bare, alice.token, attorney.token, Scope{}) {
+		t.Fatal("signature of the bare terms hash verified")
+	}
+	state := NewGenesisState("")
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	if err := state.Validator().ValidateAction(alice.grantWith(2, attorney, Scope{}, bare)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected grant with the bare signature rejected, got %v", err)
+	}
+	incorporate(t, state, 2, alice.grantWith(2, attorney, Scope{}, fingerprint))
+	if stored, ok := state.FingerprintOf(alice.token, attorney.token); !ok || string(stored) != string(fingerprint) {
+		t.Fatal("terms attestation not stored with the grant")
+	}
+}
@@ -0,0 +1,156 @@
+package attorney
+
+import (
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// MaxBatchSize is the maximum number of actions of a batch.
+const MaxBatchSize = 16
+
+// Batch bundles attorney actions that are accepted all together or not at
+// all, for instance a JoinNetwork followed by a GrantPowerOfAttorney to the
+// application onboarding the new member. Actions are carried serialized and
+// bare, without the breeze tail, each with its own signatures, and Author
+// must be a party to every one of them. The signature of Author on the batch
+// binds the actions together. Batches cannot be nested.
+type Batch struct {
+	Epoch     uint64
+	Author    crypto.Token
+	Actions   [][]byte
+	Signature crypto.Signature
+}
+
+func (b *Batch) Tokens() []crypto.Token {
+	tokens := []crypto.Token{b.Author}
+	for _, data := range b.Actions {
+		for _, token := range GetTokens(data) {
+			if !hasToken(tokens, token) {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// Validate validates the actions of the batch in order. If any of them is
+// rejected, none of their mutations is kept.
+func (b *Batch) Validate(v ActionValidator) error {
+	return v.Atomic(func(v ActionValidator) error {
+		for _, data := range b.Actions {
+			if IsStale(ActionEpoch(data), v.Epoch()) {
+				return ErrStaleEpoch
+			}
+			if IsFuture(ActionEpoch(data), v.Epoch()) {
+				return ErrFutureEpoch
+			}
+			if v.IsRecent(crypto.Hasher(data)) {
+				return ErrReplayed
+			}
+			action, err := parseBareAction(data)
+			if err != nil {
+				return err
+			}
+			if err := action.Validate(v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *Batch) Kind() byte {
+	return BatchType
+}
+
+// Hashes returns the hashes of the actions of the batch.
+func (b *Batch) Hashes() []crypto.Hash {
+	hashes := make([]crypto.Hash, len(b.Actions))
+	for n, data := range b.Actions {
+		hashes[n] = crypto.Hasher(data)
+	}
+	return hashes
+}
+
+// Append adds a serialized action to the batch.
+func (b *Batch) Append(action Action) {
+	b.Actions = append(b.Actions, action.Serialize())
+}
+
+func (b *Batch) serializeToSign() []byte {
+	bytes := axeHeader(b.Epoch, BatchType)
+	util.PutToken(b.Author, &bytes)
+	util.PutByte(byte(len(b.Actions)), &bytes)
+	for _, data := range b.Actions {
+		util.PutLongByteArray(data, &bytes)
+	}
+	return bytes
+}
+
+func (b *Batch) Serialize() []byte {
+	bytes := b.serializeToSign()
+	util.PutSignature(b.Signature, &bytes)
+	return bytes
+}
+
+func (b *Batch) Sign(pk crypto.PrivateKey) {
+	b.Signature = pk.Sign(b.serializeToSign())
+}
+
+func ParseBatch(data []byte) *Batch {
+	action, _ := ParseAction(data).(*Batch)
+	return action
+}
+
+func parseBatch(data []byte) (*Batch, error) {
+	epoch, position, ok := parseAxeHeader(data, BatchType)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	batch := Batch{Epoch: epoch}
+	batch.Author, position = util.ParseToken(data, position)
+	var count byte
+	count, position = util.ParseByte(data, position)
+	if count == 0 || count > MaxBatchSize {
+		return nil, ErrMalformed
+	}
+	hashes := make(map[crypto.Hash]struct{})
+	for n := 0; n < int(count); n++ {
+		var action []byte
+		action, position = util.ParseLongByteArray(data, position)
+		if Kind(action) == BatchType {
+			return nil, ErrMalformed
+		}
+		inner, err := parseBareAction(action)
+		if err != nil {
+			return nil, err
+		}
+		if !hasToken(inner.Tokens(), batch.Author) {
+			return nil, ErrMalformed
+		}
+		hash := crypto.Hasher(action)
+		if _, ok := hashes[hash]; ok {
+			return nil, ErrMalformed
+		}
+		hashes[hash] = struct{}{}
+		batch.Actions = append(batch.Actions, action)
+	}
+	hashPosition := position
+	batch.Signature, position = util.ParseSignature(data, position)
+	if position != len(data) {
+		return nil, ErrMalformed
+	}
+	if !batch.Author.Verify(data[0:hashPosition], batch.Signature) {
+		return nil, ErrBadSignature
+	}
+	return &batch, nil
+}
+
+func hasToken(tokens []crypto.Token, token crypto.Token) bool {
+	for _, existing := range tokens {
+		if existing.Equal(token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+)
+
+// batch signs a batch of actions of m.
+func (m testMember) batch(epoch uint64, actions ...[]byte) []byte {
+	batch := Batch{Epoch: epoch, Author: m.token, Actions: actions}
+	batch.Sign(m.key)
+	return batch.Serialize()
+}
+
+func TestBatchOnboarding(t *testing.T) {
+	alice, app := newTestMember(), newTestMember()
+	state := NewGenesisState("")
+	join, grant := alice.join(1, "alice"), alice.grant(1, app, Scope{})
+	batch := alice.batch(1, join, grant)
+	tokens := GetTokens(batch)
+	if len(tokens) != 2 || tokens[0] != alice.token || tokens[1] != app.token {
+		t.Fatal("batch not routed to the parties of its actions")
+	}
+	incorporate(t, state, 1, batch)
+	if !state.HasMember(alice.token) || !state.PowerOfAttorney(alice.token, app.token) {
+		t.Fatal("batch not incorporated")
+	}
+	validator := state.Validator()
+	for _, data := range [][]byte{join, grant, batch} {
+		if err := validator.ValidateAction(data); !errors.Is(err, ErrReplayed) {
+			t.Fatalf("expected action of the batch replayed, got %v", err)
+		}
+	}
+}
+
+func TestBatchAllOrNothing(t *testing.T) {
+	alice, bob, app := newTestMember(), newTestMember(), newTestMember()
+	state := NewGenesisState("")
+	incorporate(t, state, 1, bob.join(1, "bob"))
+	validator := state.Validator()
+	taken := alice.batch(2, alice.join(2, "alice"), alice.grant(2, app, Scope{}), alice.join(2, "bob"))
+	if err := validator.ValidateAction(taken); err == nil {
+		t.Fatal("batch with a rejected action accepted")
+	}
+	if validator.HasMember(alice.token) || validator.PowerOfAttorney(alice.token, app.token) {
+		t.Fatal("rejected batch kept mutations")
+	}
+	if err := validator.ValidateAction(alice.join(2, "alice")); err != nil {
+		t.Fatalf("join rejected after a rejected batch: %v", err)
+	}
+	state.Incorporate(validator.Mutations())
+	if !state.HasMember(alice.token) || state.PowerOfAttorney(alice.token, app.token) {
+		t.Fatal("unexpected state after a rejected batch")
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	alice, bob := newTestMember(), newTestMember()
+	join := alice.join(1, "alice")
+	if batch := ParseBatch(alice.batch(1, join)); batch == nil || len(batch.Actions) != 1 {
+		t.Fatal("could not parse batch")
+	}
+	malformed := [][]byte{
+		alice.batch(1),
+		alice.batch(1, join, join),
+		alice.batch(1, alice.batch(1, join)),
+		alice.batch(1, bob.join(1, "bob")),
+	}
+	for _, data := range malformed {
+		if _, err := parseBatch(data); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("expected malformed batch, got %v", err)
+		}
+	}
+	forged := Batch{Epoch: 1, Author: alice.token, Actions: [][]byte{join}}
+	forged.Sign(bob.key)
+	if _, err := parseBatch(forged.Serialize()); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("expected bad signature, got %v", err)
+	}
+}
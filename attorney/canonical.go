@@ -0,0 +1,154 @@
+package attorney
+
+import (
+	"log/slog"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// Length limits of a canonical handle, in characters.
+const (
+	MinHandleLength = 1
+	MaxHandleLength = 32
+)
+
+// handleSeparators may appear inside a handle but not at its start or end.
+const handleSeparators = "_-"
+
+// CanonicalHandle returns the canonical form of handle: stripped of invisible
+// characters, with fullwidth forms of ascii characters replaced by their ascii
+// counterparts, and lowercase. It returns false if the canonical form is not
+// a valid handle, that is, if it is too short or too long, or if it has
+// characters other than ascii lowercase letters, digits and inner
+// separators. Letters outside ascii are refused rather than matched against
+// the confusables of their scripts.
+func CanonicalHandle(handle string) (string, bool) {
+	handle = strings.TrimSpace(handle)
+	if len(handle) > 4*MaxHandleLength {
+		return "", false
+	}
+	handle = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) || unicode.Is(unicode.Other_Default_Ignorable_Code_Point, r) {
+			return -1
+		}
+		if r >= 0xff01 && r <= 0xff5e {
+			r -= 0xff01 - '!'
+		}
+		if r >= 'A' && r <= 'Z' {
+			r += 'a' - 'A'
+		}
+		return r
+	}, handle)
+	length := utf8.RuneCountInString(handle)
+	if length < MinHandleLength || length > MaxHandleLength {
+		return "", false
+	}
+	for n, r := range handle {
+		if strings.ContainsRune(handleSeparators, r) {
+			if n == 0 || n == len(handle)-1 {
+				return "", false
+			}
+			continue
+		}
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return "", false
+		}
+	}
+	return handle, true
+}
+
+// skeletonConfusables maps sequences that render alike to a single form.
+var skeletonConfusables = strings.NewReplacer(
+	"rn", "m",
+	"vv", "w",
+	"0", "o",
+	"1", "l",
+	"_", "-",
+)
+
+// HandleSkeleton returns the confusable skeleton of a canonical handle.
+// Handles with the same skeleton look alike and cannot coexist.
+func HandleSkeleton(canonical string) string {
+	return skeletonConfusables.Replace(canonical)
+}
+
+// captionHash is the key of a handle on the captions vault and owners
+// registry. Handles that are not canonical have no caption.
+func captionHash(handle string) (crypto.Hash, bool) {
+	canonical, ok := CanonicalHandle(handle)
+	if !ok {
+		return crypto.Hash{}, false
+	}
+	return crypto.Hasher([]byte(canonical)), true
+}
+
+// skeletonHash is the key of a handle on the skeletons vault and tombstones.
+func skeletonHash(handle string) (crypto.Hash, bool) {
+	canonical, ok := CanonicalHandle(handle)
+	if !ok {
+		return crypto.Hash{}, false
+	}
+	return crypto.Hasher([]byte(HandleSkeleton(canonical))), true
+}
+
+// captionFormatKey marks on the config of a state that its captions and
+// owners are keyed by canonical handle.
+var captionFormatKey = crypto.Hasher([]byte("caption format"))
+
+const canonicalCaptions = 1
+
+// migrateCaptions rekeys the captions and owners of a state written before
+// handles were canonical, when they were keyed by the hash of the handle as
+// claimed, and registers the skeletons of its handles. Handles already in
+// canonical form are migrated first, so that they keep their caption. A handle
+// whose canonical form is taken, by a handle that differs only in case for
+// instance, or that is no longer valid keeps its old key and is reported: it
+// stays with its owner but can no longer be found by handle.
+func (s *State) migrateCaptions() {
+	if s.parameter(captionFormatKey) == canonicalCaptions {
+		return
+	}
+	records := s.Handles.freeze()
+	tokens := make([]crypto.Hash, 0)
+	handles := make([]string, 0)
+	records.each(func(hash crypto.Hash, value []byte) {
+		if canonical, ok := CanonicalHandle(string(value)); ok && canonical == string(value) {
+			tokens, handles = append(tokens, hash), append(handles, string(value))
+		}
+	})
+	records.each(func(hash crypto.Hash, value []byte) {
+		if canonical, ok := CanonicalHandle(string(value)); !ok || canonical != string(value) {
+			tokens, handles = append(tokens, hash), append(handles, string(value))
+		}
+	})
+	records.release()
+	for n, handle := range handles {
+		canonical, ok := CanonicalHandle(handle)
+		if !ok {
+			slog.Warn("State.migrateCaptions: handle is no longer valid", "handle", handle)
+			continue
+		}
+		caption := crypto.Hasher([]byte(canonical))
+		if raw := crypto.Hasher([]byte(handle)); raw != caption && s.Captions.ExistsHash(raw) {
+			if s.Captions.ExistsHash(caption) {
+				slog.Warn("State.migrateCaptions: canonical handle is taken", "handle", handle, "canonical", canonical)
+				continue
+			}
+			s.Captions.RemoveHash(raw)
+			s.Captions.InsertHash(caption)
+			if owner, ok := s.Owners.Get(raw); ok {
+				s.Owners.Remove(raw)
+				s.Owners.Set(caption, owner)
+			}
+			s.Handles.Set(tokens[n], []byte(canonical))
+		}
+		s.Skeletons.InsertHash(crypto.Hasher([]byte(HandleSkeleton(canonical))))
+	}
+	s.setParameter(captionFormatKey, canonicalCaptions)
+	if s.dataPath != "" {
+		s.persistMeta(false, crypto.ZeroValueHash)
+	}
+}
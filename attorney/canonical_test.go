@@ -0,0 +1,113 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestCanonicalHandle(t *testing.T) {
+	canonical := map[string]string{
+		"alice":            "alice",
+		" Alice ":          "alice",
+		"ＡＬＩＣＥ":            "alice",
+		"al\u200bice":      "alice",
+		"alice_bob-2":      "alice_bob-2",
+		"ALICE\u00adBOB":   "alicebob",
+		"0123456789abcdef": "0123456789abcdef",
+	}
+	for handle, expected := range canonical {
+		if got, ok := CanonicalHandle(handle); !ok || got != expected {
+			t.Fatalf("canonical form of %q is %q, expected %q", handle, got, expected)
+		}
+	}
+	invalid := []string{
+		"", "ɑlice", "ᴀlice", "alıce", "élan", "\u0430lice", "alice.bob", "-alice", "alice_",
+		"al ice", "alice@bob", "٣alice", "abcdefghijklmnopqrstuvwxyz0123456",
+	}
+	for _, handle := range invalid {
+		if got, ok := CanonicalHandle(handle); ok {
+			t.Fatalf("invalid handle %q accepted as %q", handle, got)
+		}
+	}
+}
+
+func TestHandleSkeleton(t *testing.T) {
+	alike := [][2]string{{"modern", "rnodern"}, {"wall", "vvall"}, {"bob0", "bobo"}, {"l1ly", "llly"}, {"a_b", "a-b"}}
+	for _, pair := range alike {
+		if HandleSkeleton(pair[0]) != HandleSkeleton(pair[1]) {
+			t.Fatalf("%q and %q have different skeletons", pair[0], pair[1])
+		}
+	}
+	if HandleSkeleton("alice") == HandleSkeleton("alicia") {
+		t.Fatal("distinct handles share a skeleton")
+	}
+}
+
+func TestJoinConfusableHandle(t *testing.T) {
+	state := NewGenesisState("")
+	incorporate(t, state, 1, newTestMember().join(1, "alice"))
+	for _, handle := range []string{"ɑlice", "ᴀlice", "alıce"} {
+		if err := state.Validator().ValidateAction(newTestMember().join(2, handle)); !errors.Is(err, ErrInvalidHandle) {
+			t.Fatalf("expected %q rejected as invalid, got %v", handle, err)
+		}
+	}
+	if err := state.Validator().ValidateAction(newTestMember().join(2, "ALICE")); !errors.Is(err, ErrHandleTaken) {
+		t.Fatalf("expected upper case claim of a taken handle rejected, got %v", err)
+	}
+	if err := state.Validator().ValidateAction(newTestMember().join(2, "a1ice")); !errors.Is(err, ErrHandleTaken) {
+		t.Fatalf("expected confusable claim of a taken handle rejected, got %v", err)
+	}
+}
+
+// claimRaw registers handle for m the way states did before handles were
+// canonical, keyed by the hash of the handle as claimed.
+func claimRaw(state *State, m testMember, handle string) {
+	state.Members.InsertHash(crypto.HashToken(m.token))
+	state.Captions.InsertHash(crypto.Hasher([]byte(handle)))
+	state.Owners.Set(crypto.Hasher([]byte(handle)), m.token[:])
+	state.Handles.Set(crypto.HashToken(m.token), []byte(handle))
+}
+
+func TestMigrateCaptions(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState(dir)
+	alice, bob, carol, impostor := newTestMember(), newTestMember(), newTestMember(), newTestMember()
+	claimRaw(state, alice, "Alice")
+	claimRaw(state, bob, "bob")
+	claimRaw(state, impostor, "CAROL")
+	claimRaw(state, carol, "carol")
+	state.Config.Remove(captionFormatKey)
+	state.Shutdown()
+	migrated, err := RecoverState(dir)
+	if err != nil {
+		t.Fatalf("could not recover state: %v", err)
+	}
+	if token, ok := migrated.TokenOf("ALICE"); !ok || token != alice.token {
+		t.Fatal("caption not rekeyed by canonical handle")
+	}
+	if handle, _ := migrated.HandleOf(alice.token); handle != "alice" || !migrated.HasHandle("a1ice") {
+		t.Fatal("handle not migrated to canonical form")
+	}
+	if token, ok := migrated.TokenOf("bob"); !ok || token != bob.token || !migrated.HasHandle("b0b") {
+		t.Fatal("canonical handle not migrated")
+	}
+	// the handle already in canonical form keeps the caption
+	if token, ok := migrated.TokenOf("Carol"); !ok || token != carol.token {
+		t.Fatal("case duplicate took the caption")
+	}
+	if handle, _ := migrated.HandleOf(impostor.token); handle != "CAROL" {
+		t.Fatal("case duplicate lost its handle")
+	}
+	if migrated.Captions.ExistsHash(crypto.Hasher([]byte("Alice"))) || migrated.Owners.Exists(crypto.Hasher([]byte("Alice"))) {
+		t.Fatal("old caption kept")
+	}
+	checksum := migrated.Checksum()
+	migrated.Shutdown()
+	recovered, err := RecoverState(dir)
+	if err != nil || recovered.Checksum() != checksum {
+		t.Fatalf("captions migrated twice: %v", err)
+	}
+	recovered.Shutdown()
+}
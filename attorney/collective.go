@@ -0,0 +1,306 @@
+package attorney
+
+import (
+	"encoding/json"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// MaxCollectiveSize is the maximum number of tokens of a collective.
+const MaxCollectiveSize = 32
+
+// CollectiveFlag is set on the kind byte of UpdateInfo, GrantPowerOfAttorney,
+// RevokePowerOfAttorney and Void actions signed by a collective. Instead of a
+// single signature, these actions end with the cosignatures of the members
+// of the collective.
+const CollectiveFlag byte = 0x80
+
+// Collective is an M-of-N set of tokens controlling a member account. Actions
+// of the member must carry the signatures of at least Threshold tokens of the
+// set.
+type Collective struct {
+	Threshold byte
+	Tokens    []crypto.Token
+}
+
+// IsValid checks if the threshold is reachable and tokens are distinct.
+func (c Collective) IsValid() bool {
+	if c.Threshold == 0 || int(c.Threshold) > len(c.Tokens) || len(c.Tokens) > MaxCollectiveSize {
+		return false
+	}
+	for n, token := range c.Tokens {
+		for _, other := range c.Tokens[:n] {
+			if token.Equal(other) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Has checks if token belongs to the collective.
+func (c Collective) Has(token crypto.Token) bool {
+	for _, member := range c.Tokens {
+		if member.Equal(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Token returns the token of the member account controlled by the collective.
+// It is derived from the set, so no one holds its private key.
+func (c Collective) Token() crypto.Token {
+	return crypto.Token(crypto.Hasher(c.Serialize()))
+}
+
+// IsSignedBy checks if cosignatures carry signatures of at least Threshold
+// distinct tokens of the collective. Cosignatures are verified on parsing.
+func (c Collective) IsSignedBy(cosignatures Cosignatures) bool {
+	signed := make(map[crypto.Token]struct{})
+	for _, cosignature := range cosignatures {
+		if c.Has(cosignature.Token) {
+			signed[cosignature.Token] = struct{}{}
+		}
+	}
+	return len(signed) >= int(c.Threshold)
+}
+
+func (c Collective) serialize(bytes *[]byte) {
+	util.PutByte(c.Threshold, bytes)
+	util.PutByte(byte(len(c.Tokens)), bytes)
+	for _, token := range c.Tokens {
+		util.PutToken(token, bytes)
+	}
+}
+
+func (c Collective) Serialize() []byte {
+	bytes := []byte{}
+	c.serialize(&bytes)
+	return bytes
+}
+
+func parseCollective(data []byte, position int) (Collective, int) {
+	collective := Collective{}
+	var count byte
+	collective.Threshold, position = util.ParseByte(data, position)
+	count, position = util.ParseByte(data, position)
+	if position+int(count)*crypto.TokenSize > len(data) {
+		return collective, len(data) + 1
+	}
+	collective.Tokens = make([]crypto.Token, count)
+	for n := 0; n < int(count); n++ {
+		collective.Tokens[n], position = util.ParseToken(data, position)
+	}
+	return collective, position
+}
+
+func ParseCollective(data []byte) (Collective, bool) {
+	collective, position := parseCollective(data, 0)
+	return collective, position == len(data) && collective.IsValid()
+}
+
+// Cosignature is the signature of a token of a collective on an action.
+type Cosignature struct {
+	Token     crypto.Token
+	Signature crypto.Signature
+}
+
+// Cosignatures follow the signed bytes of an action, preceded by their count.
+// Void actions, whose data has no length, carry them followed by their count
+// instead, so that they can be found from the end of the action.
+type Cosignatures []Cosignature
+
+func (c Cosignatures) serialize(bytes *[]byte) {
+	util.PutByte(byte(len(c)), bytes)
+	for _, cosignature := range c {
+		util.PutToken(cosignature.Token, bytes)
+		util.PutSignature(cosignature.Signature, bytes)
+	}
+}
+
+func (c Cosignatures) serializeTrailing(bytes *[]byte) {
+	for _, cosignature := range c {
+		util.PutToken(cosignature.Token, bytes)
+		util.PutSignature(cosignature.Signature, bytes)
+	}
+	util.PutByte(byte(len(c)), bytes)
+}
+
+// parseCosignatures parses the cosignatures that end data from position and
+// checks them against the bytes that precede them.
+func parseCosignatures(data []byte, position int) (Cosignatures, error) {
+	end := position
+	var count byte
+	count, position = util.ParseByte(data, position)
+	if count == 0 || count > MaxCollectiveSize || position+int(count)*(crypto.TokenSize+crypto.SignatureSize) != len(data) {
+		return nil, ErrMalformed
+	}
+	cosignatures := make(Cosignatures, count)
+	for n := 0; n < int(count); n++ {
+		cosignatures[n].Token, position = util.ParseToken(data, position)
+		cosignatures[n].Signature, position = util.ParseSignature(data, position)
+		if !cosignatures[n].Token.Verify(data[0:end], cosignatures[n].Signature) {
+			return nil, ErrBadSignature
+		}
+	}
+	return cosignatures, nil
+}
+
+// parseTrailingCosignatures parses the cosignatures at the end of a void
+// action and checks them against the bytes that precede them. It returns the
+// position where the cosignatures start.
+func parseTrailingCosignatures(data []byte) (Cosignatures, int, error) {
+	if len(data) == 0 {
+		return nil, 0, ErrMalformed
+	}
+	count := int(data[len(data)-1])
+	end := len(data) - 1 - count*(crypto.TokenSize+crypto.SignatureSize)
+	if count == 0 || count > MaxCollectiveSize || end < 15 {
+		return nil, 0, ErrMalformed
+	}
+	cosignatures := make(Cosignatures, count)
+	position := end
+	for n := 0; n < count; n++ {
+		cosignatures[n].Token, position = util.ParseToken(data, position)
+		cosignatures[n].Signature, position = util.ParseSignature(data, position)
+		if !cosignatures[n].Token.Verify(data[0:end], cosignatures[n].Signature) {
+			return nil, 0, ErrBadSignature
+		}
+	}
+	return cosignatures, end, nil
+}
+
+// collectiveKind returns the kind byte of an action, flagged if collective.
+func collectiveKind(kind byte, collective bool) byte {
+	if collective {
+		return kind | CollectiveFlag
+	}
+	return kind
+}
+
+// cosign signs bytes with pk and appends the signature to cosignatures.
+func cosign(cosignatures Cosignatures, bytes []byte, pk crypto.PrivateKey) Cosignatures {
+	return append(cosignatures, Cosignature{Token: pk.PublicKey(), Signature: pk.Sign(bytes)})
+}
+
+// isSignedByCollective checks if author is a collective and cosignatures
+// meet its threshold.
+func isSignedByCollective(v ActionValidator, author crypto.Token, cosignatures Cosignatures) bool {
+	collective, ok := v.CollectiveOf(author)
+	return ok && collective.IsSignedBy(cosignatures)
+}
+
+// JoinCollective registers a member account controlled by Collective. Author
+// must be the token of the collective. The action is signed by at least
+// Threshold tokens of the collective.
+type JoinCollective struct {
+	Epoch        uint64
+	Author       crypto.Token
+	Handle       string
+	Details      string
+	Seed         crypto.Hash // work seed the proof of work is bound to
+	Collective   Collective
+	Cosignatures Cosignatures
+}
+
+func (j *JoinCollective) Tokens() []crypto.Token {
+	return append([]crypto.Token{j.Author}, j.Collective.Tokens...)
+}
+
+func (j *JoinCollective) Validate(v ActionValidator) error {
+	if !j.Collective.IsSignedBy(j.Cosignatures) {
+		return ErrNotEnoughSignatures
+	}
+	if !json.Valid([]byte(j.Details)) {
+		return ErrMalformedJSON
+	}
+	if v.HasMember(j.Author) || v.IsRetired(j.Author) {
+		return ErrConflict
+	}
+	if v.HasHandle(j.Handle) {
+		return ErrHandleTaken
+	}
+	work := j.Work()
+	if err := checkWork(v, j.Seed, j.Handle, work); err != nil {
+		return err
+	}
+	if !v.CanJoin() {
+		return ErrRateLimited
+	}
+	if !v.SetNewMember(j.Author, j.Handle, work) {
+		return ErrHandleTaken
+	}
+	v.SetNewCollective(j.Author, j.Collective)
+	v.SetNewProfile(j.Author, j.Details)
+	return nil
+}
+
+func (j *JoinCollective) Kind() byte {
+	return JoinCollectiveType
+}
+
+func (j *JoinCollective) serializeToSign() []byte {
+	bytes := axeHeader(j.Epoch, JoinCollectiveType)
+	util.PutToken(j.Author, &bytes)
+	util.PutString(j.Handle, &bytes)
+	util.PutString(j.Details, &bytes)
+	util.PutHash(j.Seed, &bytes)
+	j.Collective.serialize(&bytes)
+	return bytes
+}
+
+func (j *JoinCollective) Serialize() []byte {
+	bytes := j.serializeToSign()
+	j.Cosignatures.serialize(&bytes)
+	return bytes
+}
+
+// Sign adds the signature of a token of the collective.
+func (j *JoinCollective) Sign(pk crypto.PrivateKey) {
+	j.Cosignatures = cosign(j.Cosignatures, j.serializeToSign(), pk)
+}
+
+func ParseJoinCollective(data []byte) *JoinCollective {
+	action, _ := ParseAction(data).(*JoinCollective)
+	return action
+}
+
+func parseJoinCollective(data []byte) (*JoinCollective, error) {
+	epoch, position, ok := parseAxeHeader(data, JoinCollectiveType)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	join := JoinCollective{Epoch: epoch}
+	join.Author, position = util.ParseToken(data, position)
+	join.Handle, position = util.ParseString(data, position)
+	canonical, ok := CanonicalHandle(join.Handle)
+	if !ok {
+		return nil, ErrInvalidHandle
+	}
+	join.Details, position = util.ParseString(data, position)
+	join.Seed, position = util.ParseHash(data, position)
+	join.Collective, position = parseCollective(data, position)
+	if position > len(data) || !join.Collective.IsValid() {
+		return nil, ErrMalformed
+	}
+	if !join.Author.Equal(join.Collective.Token()) {
+		return nil, ErrMalformed
+	}
+	var err error
+	if join.Cosignatures, err = parseCosignatures(data, position); err != nil {
+		return nil, err
+	}
+	join.Handle = canonical
+	return &join, nil
+}
+
+// CollectiveOf returns the collective controlling the member token.
+func (s *State) CollectiveOf(token crypto.Token) (Collective, bool) {
+	data, ok := s.Collectives.Get(crypto.HashToken(token))
+	if !ok {
+		return Collective{}, false
+	}
+	return ParseCollective(data)
+}
@@ -0,0 +1,127 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func newTestCollective(threshold byte, members ...testMember) Collective {
+	collective := Collective{Threshold: threshold}
+	for _, member := range members {
+		collective.Tokens = append(collective.Tokens, member.token)
+	}
+	return collective
+}
+
+func joinCollective(epoch uint64, handle string, collective Collective, signers ...testMember) []byte {
+	join := JoinCollective{Epoch: epoch, Author: collective.Token(), Handle: handle, Details: `{}`, Collective: collective}
+	for _, signer := range signers {
+		join.Sign(signer.key)
+	}
+	return join.Serialize()
+}
+
+func collectiveUpdate(epoch uint64, collective Collective, details string, signers ...testMember) []byte {
+	update := UpdateInfo{Epoch: epoch, Author: collective.Token(), Details: details, Signer: collective.Token(), Collective: true}
+	for _, signer := range signers {
+		update.Sign(signer.key)
+	}
+	return update.Serialize()
+}
+
+func collectiveGrant(epoch uint64, collective Collective, attorney testMember, signers ...testMember) []byte {
+	grant := GrantPowerOfAttorney{Epoch: epoch, Author: collective.Token(), Attorney: attorney.token, Collective: true}
+	grant.Fingerprint = NewAcceptance(collective.Token(), Scope{}, attorney.key)
+	for _, signer := range signers {
+		grant.Sign(signer.key)
+	}
+	return grant.Serialize()
+}
+
+func collectiveRevoke(epoch uint64, collective Collective, attorney testMember, signers ...testMember) []byte {
+	revoke := RevokePowerOfAttorney{Epoch: epoch, Author: collective.Token(), Attorney: attorney.token, Collective: true}
+	for _, signer := range signers {
+		revoke.Sign(signer.key)
+	}
+	return revoke.Serialize()
+}
+
+func collectiveVoid(epoch uint64, collective Collective, signers ...testMember) []byte {
+	void := Void{Epoch: epoch, Protocol: 1, Author: collective.Token(), Data: []byte{1, 0, 0, 0}, Signer: collective.Token(), Collective: true}
+	for _, signer := range signers {
+		void.Sign(signer.key)
+	}
+	return void.Serialize()
+}
+
+func TestCollectiveIsValid(t *testing.T) {
+	a, b := newTestMember(), newTestMember()
+	invalid := []Collective{newTestCollective(0, a, b), newTestCollective(3, a, b), newTestCollective(1, a, a)}
+	for _, collective := range invalid {
+		if collective.IsValid() {
+			t.Fatalf("invalid collective %v accepted", collective)
+		}
+	}
+	collective := newTestCollective(2, a, b)
+	if parsed, ok := ParseCollective(collective.Serialize()); !ok || collective.Token() != parsed.Token() {
+		t.Fatal("collective does not round trip")
+	}
+	if collective.Token() == newTestCollective(1, a, b).Token() {
+		t.Fatal("collectives with different thresholds share a token")
+	}
+}
+
+func TestCollectiveActions(t *testing.T) {
+	dir := t.TempDir()
+	a, b, c, outsider, attorney := newTestMember(), newTestMember(), newTestMember(), newTestMember(), newTestMember()
+	collective := newTestCollective(2, a, b, c)
+	state := NewGenesisState(dir)
+	if err := state.Validator().ValidateAction(joinCollective(1, "synergy", collective, a, outsider)); !errors.Is(err, ErrNotEnoughSignatures) {
+		t.Fatalf("expected join short of signatures rejected, got %v", err)
+	}
+	incorporate(t, state, 1, joinCollective(1, "synergy", collective, a, c))
+	state = shutdownAndRecover(t, state, dir)
+	defer state.Shutdown()
+	if token, ok := state.TokenOf("synergy"); !ok || token != collective.Token() {
+		t.Fatal("handle not owned by the collective")
+	}
+	if registered, ok := state.CollectiveOf(collective.Token()); !ok || registered.Threshold != 2 || len(registered.Tokens) != 3 {
+		t.Fatal("collective not registered")
+	}
+	short := [][]byte{
+		collectiveUpdate(2, collective, `{"n":1}`, b),
+		collectiveUpdate(2, collective, `{"n":1}`, b, outsider),
+		collectiveUpdate(2, collective, `{"n":1}`, b, b),
+		collectiveGrant(2, collective, attorney, a),
+		collectiveVoid(2, collective, c),
+	}
+	for _, data := range short {
+		if err := state.Validator().ValidateAction(data); !errors.Is(err, ErrNotEnoughSignatures) {
+			t.Fatalf("expected action short of signatures rejected, got %v", err)
+		}
+	}
+	single := UpdateInfo{Epoch: 2, Author: collective.Token(), Details: `{}`, Signer: a.token}
+	single.Sign(a.key)
+	if err := state.Validator().ValidateAction(single.Serialize()); !errors.Is(err, ErrNoPowerOfAttorney) {
+		t.Fatalf("expected update signed by a single token rejected, got %v", err)
+	}
+	incorporate(t, state, 2, collectiveUpdate(2, collective, `{"n":1}`, a, b), collectiveGrant(2, collective, attorney, b, c), collectiveVoid(2, collective, a, c))
+	if details, _ := state.Profile(collective.Token()); details != `{"n":1}` {
+		t.Fatalf("profile of the collective is %q", details)
+	}
+	if !state.PowerOfAttorney(collective.Token(), attorney.token) {
+		t.Fatal("grant of the collective not incorporated")
+	}
+	if err := state.Validator().ValidateAction(collectiveRevoke(3, collective, attorney, c)); !errors.Is(err, ErrNotEnoughSignatures) {
+		t.Fatalf("expected revoke short of signatures rejected, got %v", err)
+	}
+	incorporate(t, state, 3, collectiveRevoke(3, collective, attorney, a, b))
+	if state.PowerOfAttorney(collective.Token(), attorney.token) {
+		t.Fatal("revoke of the collective not incorporated")
+	}
+	if _, ok := state.CollectiveOf(crypto.ZeroToken); ok {
+		t.Fatal("collective of an unknown token")
+	}
+}
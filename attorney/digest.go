@@ -0,0 +1,74 @@
+package attorney
+
+import (
+	"math/big"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// setDigest is an incremental hash of a set of items: the product, modulo a
+// 3072-bit prime, of each item expanded to 3072 bits. Items are added and
+// removed in any order at the cost of a modular multiplication, and finding
+// two sets with the same digest is as hard as a discrete logarithm. Removals
+// are kept in a separate denominator, so that a single modular inverse is
+// computed when the digest is read. The zero value is the digest of an empty
+// set.
+type setDigest struct {
+	numerator   *big.Int
+	denominator *big.Int
+}
+
+// digestModulus is 2^3072 - 1103717, the largest 3072-bit safe prime.
+var digestModulus = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 3072), big.NewInt(1103717))
+
+const digestBytes = 3072 / 8
+
+// digestElement expands data to an element of the group of digestModulus.
+func digestElement(data []byte) *big.Int {
+	expanded := make([]byte, 0, digestBytes)
+	for counter := byte(0); len(expanded) < digestBytes; counter++ {
+		hash := crypto.Hasher(append([]byte{counter}, data...))
+		expanded = append(expanded, hash[:]...)
+	}
+	element := new(big.Int).SetBytes(expanded)
+	return element.Mod(element, digestModulus)
+}
+
+func (d *setDigest) init() {
+	if d.numerator == nil {
+		d.numerator = big.NewInt(1)
+		d.denominator = big.NewInt(1)
+	}
+}
+
+func (d *setDigest) add(data []byte) {
+	d.init()
+	d.numerator.Mul(d.numerator, digestElement(data))
+	d.numerator.Mod(d.numerator, digestModulus)
+}
+
+func (d *setDigest) remove(data []byte) {
+	d.init()
+	d.denominator.Mul(d.denominator, digestElement(data))
+	d.denominator.Mod(d.denominator, digestModulus)
+}
+
+// Hash returns the hash of the digest.
+func (d *setDigest) Hash() crypto.Hash {
+	d.init()
+	if d.denominator.Cmp(big.NewInt(1)) != 0 {
+		inverse := new(big.Int).ModInverse(d.denominator, digestModulus)
+		d.numerator.Mul(d.numerator, inverse)
+		d.numerator.Mod(d.numerator, digestModulus)
+		d.denominator.SetInt64(1)
+	}
+	return crypto.Hasher(d.numerator.FillBytes(make([]byte, digestBytes)))
+}
+
+func (d *setDigest) clone() setDigest {
+	d.init()
+	return setDigest{
+		numerator:   new(big.Int).Set(d.numerator),
+		denominator: new(big.Int).Set(d.denominator),
+	}
+}
@@ -0,0 +1,53 @@
+package attorney
+
+// Rejection is the reason an action was rejected by the validator. It is an
+// error so that it can be wrapped and compared with errors.Is, and a small
+// integer so that nodes can count rejections by reason.
+type Rejection byte
+
+const (
+	ErrUnknownKind Rejection = iota + 1
+	ErrMalformed
+	ErrBadSignature
+	ErrMalformedJSON
+	ErrInvalidHandle
+	ErrStaleEpoch
+	ErrReplayed
+	ErrNotMember
+	ErrHandleTaken
+	ErrNoPowerOfAttorney
+	ErrConflict
+	ErrRecipientIsMember
+	ErrFutureEpoch
+	ErrNotEnoughSignatures
+	ErrInsufficientWork
+	ErrRateLimited
+	ErrUnknownSeed
+)
+
+var rejectionReasons = [...]string{
+	ErrUnknownKind:         "unknown action kind",
+	ErrMalformed:           "malformed action",
+	ErrBadSignature:        "bad signature",
+	ErrMalformedJSON:       "malformed json details",
+	ErrInvalidHandle:       "invalid handle",
+	ErrStaleEpoch:          "stale epoch",
+	ErrReplayed:            "action already incorporated",
+	ErrNotMember:           "not a member",
+	ErrHandleTaken:         "handle taken",
+	ErrNoPowerOfAttorney:   "no power of attorney",
+	ErrConflict:            "conflicts with state or pending actions",
+	ErrRecipientIsMember:   "recipient already owns a handle",
+	ErrFutureEpoch:         "epoch beyond the validity window",
+	ErrNotEnoughSignatures: "not enough signatures of the collective",
+	ErrInsufficientWork:    "not enough proof of work for the handle",
+	ErrRateLimited:         "too many new members for the epoch",
+	ErrUnknownSeed:         "work seed unknown or expired",
+}
+
+func (r Rejection) Error() string {
+	if int(r) < len(rejectionReasons) && rejectionReasons[r] != "" {
+		return rejectionReasons[r]
+	}
+	return "unknown rejection"
+}
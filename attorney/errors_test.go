@@ -0,0 +1,54 @@
+package attorney
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRejectionError(t *testing.T) {
+	if ErrHandleTaken.Error() != "handle taken" {
+		t.Fatalf("unexpected reason %q", ErrHandleTaken.Error())
+	}
+	if Rejection(0).Error() != "unknown rejection" || Rejection(255).Error() != "unknown rejection" {
+		t.Fatal("unexpected reason of an unknown rejection")
+	}
+	wrapped := fmt.Errorf("block 3: %w", ErrNotMember)
+	if !errors.Is(wrapped, ErrNotMember) || errors.Is(wrapped, ErrHandleTaken) {
+		t.Fatal("wrapped rejection does not compare")
+	}
+}
+
+func TestValidateRejections(t *testing.T) {
+	alice, bob := newTestMember(), newTestMember()
+	state := NewGenesisState("")
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	tampered := bob.join(2, "bob")
+	tampered[len(tampered)-1] ^= 1
+	rejected := map[error][]byte{
+		ErrUnknownKind:       {0, 1, 2},
+		ErrBadSignature:      tampered,
+		ErrNotMember:         bob.update(2, `{}`),
+		ErrHandleTaken:       bob.join(2, "alice"),
+		ErrNoPowerOfAttorney: bob.updateFor(2, alice, `{}`),
+		ErrFutureEpoch:       alice.update(ActionWindow+3, `{}`),
+		ErrMalformedJSON:     alice.update(2, `{`),
+	}
+	for expected, data := range rejected {
+		validator := state.Validator()
+		if err := validator.ValidateAction(data); !errors.Is(err, expected) {
+			t.Fatalf("expected %v, got %v", expected, err)
+		}
+		if validator.Validate(data) {
+			t.Fatalf("action rejected with %v accepted by Validate", expected)
+		}
+	}
+	validator := state.Validator()
+	validator.SetEpoch(ActionWindow + 2)
+	if err := validator.ValidateAction(alice.update(1, `{}`)); !errors.Is(err, ErrStaleEpoch) {
+		t.Fatalf("expected stale epoch, got %v", err)
+	}
+	if !state.Validator().Validate(alice.update(2, `{}`)) {
+		t.Fatal("valid action rejected by Validate")
+	}
+}
@@ -0,0 +1,464 @@
+package attorney
+
+import (
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/protocol/actions"
+	"github.com/freehandle/breeze/util"
+)
+
+// MinRecoveryDelay is the minimum number of epochs between a recovery request
+// and its completion. It gives the original key time to cancel a recovery
+// regardless of how actions are delayed by the network.
+const MinRecoveryDelay = 2 * ActionWindow
+
+// MaxGuardians is the maximum number of guardians of a member.
+const MaxGuardians = 32
+
+// Guardians are the tokens that may jointly recover the handle of a member
+// who lost its key. Threshold of them must co-sign a recovery, which can be
+// completed only Delay epochs after it was requested.
+type Guardians struct {
+	Threshold byte
+	Delay     uint64
+	Tokens    []crypto.Token
+}
+
+// IsValid checks if the threshold can be met by distinct guardians and the
+// delay is long enough.
+func (g Guardians) IsValid() bool {
+	if g.Threshold == 0 || int(g.Threshold) > len(g.Tokens) || len(g.Tokens) > MaxGuardians {
+		return false
+	}
+	if g.Delay < MinRecoveryDelay {
+		return false
+	}
+	for n, token := range g.Tokens {
+		for _, other := range g.Tokens[n+1:] {
+			if token.Equal(other) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (g Guardians) Has(token crypto.Token) bool {
+	for _, guardian := range g.Tokens {
+		if guardian.Equal(token) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g Guardians) serialize(bytes *[]byte) {
+	util.PutByte(g.Threshold, bytes)
+	util.PutUint64(g.Delay, bytes)
+	util.PutTokenArray(g.Tokens, bytes)
+}
+
+func (g Guardians) Serialize() []byte {
+	bytes := []byte{}
+	g.serialize(&bytes)
+	return bytes
+}
+
+// parseGuardians returns a position beyond len(data) if data is not valid.
+func parseGuardians(data []byte, position int) (Guardians, int) {
+	guardians := Guardians{}
+	guardians.Threshold, position = util.ParseByte(data, position)
+	guardians.Delay, position = util.ParseUint64(data, position)
+	var count uint32
+	count, position = util.ParseUint32(data, position)
+	if count > MaxGuardians || position+int(count)*crypto.TokenSize > len(data) {
+		return guardians, len(data) + 1
+	}
+	for n := 0; n < int(count); n++ {
+		var token crypto.Token
+		token, position = util.ParseToken(data, position)
+		guardians.Tokens = append(guardians.Tokens, token)
+	}
+	return guardians, position
+}
+
+func ParseGuardians(data []byte) (Guardians, bool) {
+	guardians, position := parseGuardians(data, 0)
+	return guardians, position == len(data)
+}
+
+// Recovery is a pending rebinding of a member to Token that can be completed
+// from epoch Due on.
+type Recovery struct {
+	Token crypto.Token
+	Due   uint64
+}
+
+func (r Recovery) Serialize() []byte {
+	bytes := []byte{}
+	util.PutToken(r.Token, &bytes)
+	util.PutUint64(r.Due, &bytes)
+	return bytes
+}
+
+func ParseRecovery(data []byte) (Recovery, bool) {
+	recovery := Recovery{}
+	position := 0
+	recovery.Token, position = util.ParseToken(data, position)
+	recovery.Due, position = util.ParseUint64(data, position)
+	return recovery, position == len(data)
+}
+
+// axeHeader appends the header common to all attorney actions.
+func axeHeader(epoch uint64, kind byte) []byte {
+	bytes := []byte{0, actions.IVoid}
+	util.PutUint64(epoch, &bytes)
+	bytes = append(bytes, AxeProtocolCode[:]...)
+	util.PutByte(kind, &bytes)
+	return bytes
+}
+
+// parseAxeHeader returns the epoch of an attorney action of the given kind
+// and the position after the header, or false if data has another header.
+func parseAxeHeader(data []byte, kind byte) (uint64, int, bool) {
+	if len(data) < 15 || data[0] != 0 || data[1] != actions.IVoid {
+		return 0, 0, false
+	}
+	epoch, position := util.ParseUint64(data, 2)
+	if data[position] != 1 || data[position+1] != 0 || data[position+2] != 0 || data[position+3] != 0 {
+		return 0, 0, false
+	}
+	if data[position+4] != kind {
+		return 0, 0, false
+	}
+	return epoch, position + 5, true
+}
+
+// RegisterGuardians sets (or replaces) the guardians of Author.
+type RegisterGuardians struct {
+	Epoch     uint64
+	Author    crypto.Token
+	Guardians Guardians
+	Signature crypto.Signature
+}
+
+func (r *RegisterGuardians) Tokens() []crypto.Token {
+	return []crypto.Token{r.Author}
+}
+
+func (r *RegisterGuardians) Validate(v ActionValidator) error {
+	if !v.HasMember(r.Author) {
+		return ErrNotMember
+	}
+	if !v.SetNewGuardians(r.Author, r.Guardians) {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *RegisterGuardians) Kind() byte {
+	return RegisterGuardiansType
+}
+
+func (r *RegisterGuardians) serializeToSign() []byte {
+	bytes := axeHeader(r.Epoch, RegisterGuardiansType)
+	util.PutToken(r.Author, &bytes)
+	r.Guardians.serialize(&bytes)
+	return bytes
+}
+
+func (r *RegisterGuardians) Serialize() []byte {
+	bytes := r.serializeToSign()
+	util.PutSignature(r.Signature, &bytes)
+	return bytes
+}
+
+func (r *RegisterGuardians) Sign(pk crypto.PrivateKey) {
+	r.Signature = pk.Sign(r.serializeToSign())
+}
+
+func ParseRegisterGuardians(data []byte) *RegisterGuardians {
+	action, _ := ParseAction(data).(*RegisterGuardians)
+	return action
+}
+
+func parseRegisterGuardians(data []byte) (*RegisterGuardians, error) {
+	epoch, position, ok := parseAxeHeader(data, RegisterGuardiansType)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	register := RegisterGuardians{Epoch: epoch}
+	register.Author, position = util.ParseToken(data, position)
+	register.Guardians, position = parseGuardians(data, position)
+	hashPosition := position
+	register.Signature, position = util.ParseSignature(data, position)
+	if position != len(data) || !register.Guardians.IsValid() || register.Guardians.Has(register.Author) {
+		return nil, ErrMalformed
+	}
+	if !register.Author.Verify(data[0:hashPosition], register.Signature) {
+		return nil, ErrBadSignature
+	}
+	return &register, nil
+}
+
+// GuardianSignature is the signature of a guardian on a recovery request.
+type GuardianSignature struct {
+	Guardian  crypto.Token
+	Signature crypto.Signature
+}
+
+// RequestRecovery starts the recovery of Author to Token. It must be
+// co-signed by at least the threshold of guardians of Author.
+type RequestRecovery struct {
+	Epoch      uint64
+	Author     crypto.Token
+	Token      crypto.Token
+	Signatures []GuardianSignature
+}
+
+func (r *RequestRecovery) Tokens() []crypto.Token {
+	tokens := []crypto.Token{r.Author, r.Token}
+	for _, signature := range r.Signatures {
+		tokens = append(tokens, signature.Guardian)
+	}
+	return tokens
+}
+
+func (r *RequestRecovery) Validate(v ActionValidator) error {
+	if !v.HasMember(r.Author) {
+		return ErrNotMember
+	}
+	if !v.SetNewRecovery(r) {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *RequestRecovery) Kind() byte {
+	return RequestRecoveryType
+}
+
+func (r *RequestRecovery) serializeToSign() []byte {
+	bytes := axeHeader(r.Epoch, RequestRecoveryType)
+	util.PutToken(r.Author, &bytes)
+	util.PutToken(r.Token, &bytes)
+	return bytes
+}
+
+func (r *RequestRecovery) Serialize() []byte {
+	bytes := r.serializeToSign()
+	util.PutByte(byte(len(r.Signatures)), &bytes)
+	for _, signature := range r.Signatures {
+		util.PutToken(signature.Guardian, &bytes)
+		util.PutSignature(signature.Signature, &bytes)
+	}
+	return bytes
+}
+
+// Sign appends the signature of a guardian to the request.
+func (r *RequestRecovery) Sign(pk crypto.PrivateKey) {
+	signature := GuardianSignature{
+		Guardian:  pk.PublicKey(),
+		Signature: pk.Sign(r.serializeToSign()),
+	}
+	r.Signatures = append(r.Signatures, signature)
+}
+
+// Guardians returns the distinct tokens that signed the request.
+func (r *RequestRecovery) Guardians() []crypto.Token {
+	guardians := make([]crypto.Token, 0, len(r.Signatures))
+	for _, signature := range r.Signatures {
+		if !(Guardians{Tokens: guardians}).Has(signature.Guardian) {
+			guardians = append(guardians, signature.Guardian)
+		}
+	}
+	return guardians
+}
+
+// ParseRequestRecovery parses a recovery request and checks every guardian
+// signature on it. Whether the signers are guardians of Author is left to the
+// validator.
+func ParseRequestRecovery(data []byte) *RequestRecovery {
+	action, _ := ParseAction(data).(*RequestRecovery)
+	return action
+}
+
+func parseRequestRecovery(data []byte) (*RequestRecovery, error) {
+	epoch, position, ok := parseAxeHeader(data, RequestRecoveryType)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	request := RequestRecovery{Epoch: epoch}
+	request.Author, position = util.ParseToken(data, position)
+	request.Token, position = util.ParseToken(data, position)
+	hashPosition := position
+	var count byte
+	count, position = util.ParseByte(data, position)
+	if count == 0 || position+int(count)*(crypto.TokenSize+crypto.SignatureSize) != len(data) {
+		return nil, ErrMalformed
+	}
+	for n := 0; n < int(count); n++ {
+		signature := GuardianSignature{}
+		signature.Guardian, position = util.ParseToken(data, position)
+		signature.Signature, position = util.ParseSignature(data, position)
+		if !signature.Guardian.Verify(data[0:hashPosition], signature.Signature) {
+			return nil, ErrBadSignature
+		}
+		request.Signatures = append(request.Signatures, signature)
+	}
+	if request.Author.Equal(request.Token) || request.Token.Equal(crypto.ZeroToken) {
+		return nil, ErrMalformed
+	}
+	return &request, nil
+}
+
+// CancelRecovery is signed by the original key of Author to cancel a pending
+// recovery.
+type CancelRecovery struct {
+	Epoch     uint64
+	Author    crypto.Token
+	Signature crypto.Signature
+}
+
+func (c *CancelRecovery) Tokens() []crypto.Token {
+	return []crypto.Token{c.Author}
+}
+
+func (c *CancelRecovery) Validate(v ActionValidator) error {
+	if !v.HasMember(c.Author) {
+		return ErrNotMember
+	}
+	if !v.SetCancelRecovery(c.Author) {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (c *CancelRecovery) Kind() byte {
+	return CancelRecoveryType
+}
+
+func (c *CancelRecovery) serializeToSign() []byte {
+	bytes := axeHeader(c.Epoch, CancelRecoveryType)
+	util.PutToken(c.Author, &bytes)
+	return bytes
+}
+
+func (c *CancelRecovery) Serialize() []byte {
+	bytes := c.serializeToSign()
+	util.PutSignature(c.Signature, &bytes)
+	return bytes
+}
+
+func (c *CancelRecovery) Sign(pk crypto.PrivateKey) {
+	c.Signature = pk.Sign(c.serializeToSign())
+}
+
+func ParseCancelRecovery(data []byte) *CancelRecovery {
+	action, _ := ParseAction(data).(*CancelRecovery)
+	return action
+}
+
+func parseCancelRecovery(data []byte) (*CancelRecovery, error) {
+	epoch, position, ok := parseAxeHeader(data, CancelRecoveryType)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	cancel := CancelRecovery{Epoch: epoch}
+	cancel.Author, position = util.ParseToken(data, position)
+	hashPosition := position
+	cancel.Signature, position = util.ParseSignature(data, position)
+	if position != len(data) {
+		return nil, ErrMalformed
+	}
+	if !cancel.Author.Verify(data[0:hashPosition], cancel.Signature) {
+		return nil, ErrBadSignature
+	}
+	return &cancel, nil
+}
+
+// CompleteRecovery is signed by the recovered token once the delay of a
+// pending recovery of Author has elapsed. It rotates Author to Token. The
+// delay is checked at the epoch of the block, not the epoch signed.
+type CompleteRecovery struct {
+	Epoch     uint64
+	Author    crypto.Token
+	Token     crypto.Token
+	Signature crypto.Signature
+}
+
+func (c *CompleteRecovery) Tokens() []crypto.Token {
+	return []crypto.Token{c.Author, c.Token}
+}
+
+func (c *CompleteRecovery) Validate(v ActionValidator) error {
+	if !v.HasMember(c.Author) {
+		return ErrNotMember
+	}
+	if !v.SetCompleteRecovery(c.Author, c.Token) {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (c *CompleteRecovery) Kind() byte {
+	return CompleteRecoveryType
+}
+
+func (c *CompleteRecovery) serializeToSign() []byte {
+	bytes := axeHeader(c.Epoch, CompleteRecoveryType)
+	util.PutToken(c.Author, &bytes)
+	util.PutToken(c.Token, &bytes)
+	return bytes
+}
+
+func (c *CompleteRecovery) Serialize() []byte {
+	bytes := c.serializeToSign()
+	util.PutSignature(c.Signature, &bytes)
+	return bytes
+}
+
+func (c *CompleteRecovery) Sign(pk crypto.PrivateKey) {
+	c.Signature = pk.Sign(c.serializeToSign())
+}
+
+func ParseCompleteRecovery(data []byte) *CompleteRecovery {
+	action, _ := ParseAction(data).(*CompleteRecovery)
+	return action
+}
+
+func parseCompleteRecovery(data []byte) (*CompleteRecovery, error) {
+	epoch, position, ok := parseAxeHeader(data, CompleteRecoveryType)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	complete := CompleteRecovery{Epoch: epoch}
+	complete.Author, position = util.ParseToken(data, position)
+	complete.Token, position = util.ParseToken(data, position)
+	hashPosition := position
+	complete.Signature, position = util.ParseSignature(data, position)
+	if position != len(data) {
+		return nil, ErrMalformed
+	}
+	if !complete.Token.Verify(data[0:hashPosition], complete.Signature) {
+		return nil, ErrBadSignature
+	}
+	return &complete, nil
+}
+
+// GuardiansOf returns the guardians registered by token.
+func (s *State) GuardiansOf(token crypto.Token) (Guardians, bool) {
+	data, ok := s.Guardians.Get(crypto.HashToken(token))
+	if !ok {
+		return Guardians{}, false
+	}
+	return ParseGuardians(data)
+}
+
+// PendingRecovery returns the pending recovery of token.
+func (s *State) PendingRecovery(token crypto.Token) (Recovery, bool) {
+	data, ok := s.Recoveries.Get(crypto.HashToken(token))
+	if !ok {
+		return Recovery{}, false
+	}
+	return ParseRecovery(data)
+}
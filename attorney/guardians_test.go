@@ -0,0 +1,97 @@
+package attorney
+
+import (
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func (m testMember) guard(epoch uint64, threshold byte, guardians ...testMember) []byte {
+	register := RegisterGuardians{Epoch: epoch, Author: m.token, Guardians: Guardians{Threshold: threshold, Delay: MinRecoveryDelay}}
+	for _, guardian := range guardians {
+		register.Guardians.Tokens = append(register.Guardians.Tokens, guardian.token)
+	}
+	register.Sign(m.key)
+	return register.Serialize()
+}
+
+func (m testMember) requestRecovery(epoch uint64, token testMember, guardians ...testMember) []byte {
+	request := RequestRecovery{Epoch: epoch, Author: m.token, Token: token.token}
+	for _, guardian := range guardians {
+		request.Sign(guardian.key)
+	}
+	return request.Serialize()
+}
+
+func (m testMember) completeRecovery(epoch uint64, token testMember) []byte {
+	complete := CompleteRecovery{Epoch: epoch, Author: m.token, Token: token.token}
+	complete.Sign(token.key)
+	return complete.Serialize()
+}
+
+func TestGuardiansIsValid(t *testing.T) {
+	tokens := []crypto.Token{newTestMember().token, newTestMember().token}
+	if !(Guardians{Threshold: 2, Delay: MinRecoveryDelay, Tokens: tokens}).IsValid() {
+		t.Fatal("valid guardians rejected")
+	}
+	if (Guardians{Threshold: 2, Delay: MinRecoveryDelay - 1, Tokens: tokens}).IsValid() {
+		t.Fatal("guardians with a short delay accepted")
+	}
+	if (Guardians{Threshold: 3, Delay: MinRecoveryDelay, Tokens: tokens}).IsValid() {
+		t.Fatal("guardians with an unreachable threshold accepted")
+	}
+	if (Guardians{Threshold: 1, Delay: MinRecoveryDelay, Tokens: []crypto.Token{tokens[0], tokens[0]}}).IsValid() {
+		t.Fatal("repeated guardians accepted")
+	}
+}
+
+func TestRecoveryDueAtBlockEpoch(t *testing.T) {
+	state := NewGenesisState("")
+	alice, fresh := newTestMember(), newTestMember()
+	first, second, third := newTestMember(), newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"), alice.guard(1, 2, first, second, third))
+	validator := state.Validator()
+	validator.SetEpoch(10)
+	if validator.ValidateAction(alice.requestRecovery(10, fresh, first)) == nil {
+		t.Fatal("recovery accepted below the threshold")
+	}
+	// the request is signed ahead, but the delay runs from its block
+	if err := validator.ValidateAction(alice.requestRecovery(10+ActionWindow, fresh, first, second)); err != nil {
+		t.Fatalf("recovery rejected: %v", err)
+	}
+	state.Incorporate(validator.Mutations())
+	if recovery, ok := state.PendingRecovery(alice.token); !ok || recovery.Due != 10+MinRecoveryDelay {
+		t.Fatalf("unexpected recovery %+v", recovery)
+	}
+	validator = state.Validator()
+	validator.SetEpoch(9 + MinRecoveryDelay)
+	if validator.ValidateAction(alice.completeRecovery(9+MinRecoveryDelay+ActionWindow, fresh)) == nil {
+		t.Fatal("recovery completed before it was due")
+	}
+	incorporate(t, state, 10+MinRecoveryDelay, alice.completeRecovery(10+MinRecoveryDelay, fresh))
+	if token, _ := state.TokenOf("alice"); token != fresh.token || !state.IsRetired(alice.token) {
+		t.Fatal("recovery did not rotate the handle")
+	}
+	if _, ok := state.GuardiansOf(fresh.token); !ok {
+		t.Fatal("guardians did not move to the recovered token")
+	}
+}
+
+func TestCancelRecovery(t *testing.T) {
+	state := NewGenesisState("")
+	alice, fresh := newTestMember(), newTestMember()
+	first, second := newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"), alice.guard(1, 1, first, second))
+	incorporate(t, state, 2, alice.requestRecovery(2, fresh, second))
+	cancel := CancelRecovery{Epoch: 3, Author: alice.token}
+	cancel.Sign(alice.key)
+	incorporate(t, state, 3, cancel.Serialize())
+	if _, ok := state.PendingRecovery(alice.token); ok {
+		t.Fatal("recovery left after cancel")
+	}
+	validator := state.Validator()
+	validator.SetEpoch(2 + MinRecoveryDelay)
+	if validator.ValidateAction(alice.completeRecovery(2+MinRecoveryDelay, fresh)) == nil {
+		t.Fatal("cancelled recovery completed")
+	}
+}
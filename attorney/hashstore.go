@@ -1,10 +1,14 @@
 package attorney
 
 import (
+	"encoding/binary"
 	"log/slog"
+	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
 	"github.com/freehandle/papirus"
 )
 
@@ -24,7 +28,7 @@ func deleteOrInsert(found bool, hash crypto.Hash, b *papirus.Bucket, item int64,
 	if found {
 		if param[0] == remove { //Delete
 			return papirus.OperationResult{
-				Deleted: &papirus.Item{Bucket: b, Item: item},
+				Deleted: removeItem(b, item),
 				Result:  papirus.QueryResult{Ok: true},
 			}
 		} else if param[0] == exists { // exists?
@@ -39,8 +43,14 @@ func deleteOrInsert(found bool, hash crypto.Hash, b *papirus.Bucket, item int64,
 	} else {
 		if param[0] == insert {
 			b.WriteItem(item, hash[:])
+			added := &papirus.Item{Bucket: b, Item: item}
+			if item == vaultItemsPerBucket-1 && b.ReadOverflow() != 0 {
+				// the bucket kept its overflow after a remove, papirus must not
+				// append another one
+				added.Item = 0
+			}
 			return papirus.OperationResult{
-				Added:  &papirus.Item{Bucket: b, Item: item},
+				Added:  added,
 				Result: papirus.QueryResult{Ok: true},
 			}
 		} else {
@@ -51,30 +61,182 @@ func deleteOrInsert(found bool, hash crypto.Hash, b *papirus.Bucket, item int64,
 	}
 }
 
+// removeItem deletes item of bucket b and moves the last item of the bucket
+// chain into the hole, so that the items of a chain stay contiguous. Papirus
+// clears a slot of its own when an item is deleted, assuming the deleted item
+// is the last one of the chain, so removeItem returns a slot that is already
+// clear at the end of the chain for papirus to clear. A chain that loses its
+// last item keeps its buckets, an emptied overflow bucket is reused by the
+// next insert.
+func removeItem(b *papirus.Bucket, item int64) *papirus.Item {
+	last, lastItem := b, item
+	bucket, next := b, item+1
+	for {
+		if next == vaultItemsPerBucket {
+			if bucket = bucket.NextBucket(); bucket == nil {
+				break
+			}
+			next = 0
+		}
+		if crypto.BytesToHash(bucket.ReadItem(next)) == crypto.ZeroValueHash {
+			break
+		}
+		last, lastItem = bucket, next
+		next++
+	}
+	if last != b || lastItem != item {
+		b.WriteItem(item, last.ReadItem(lastItem))
+	}
+	last.WriteItem(lastItem, make([]byte, vaultItemBytes))
+	end := last
+	for next := end.NextBucket(); next != nil; next = end.NextBucket() {
+		end = next
+	}
+	return &papirus.Item{Bucket: end, Item: lastItem}
+}
+
+const (
+	vaultItemBytes      = 32
+	vaultItemsPerBucket = 6
+	vaultBucketBytes    = vaultItemsPerBucket*vaultItemBytes + 8
+	vaultReadBuckets    = 1 << 10 // buckets read at once when walking a store
+)
+
+// Papirus reserves papirus.HeaderSize bytes at the start of a store but only
+// the last 16 describe its buckets. Hash vaults write vaultMagic and the bits
+// for bucket of their index at the start of the header, so that a reopened
+// vault knows the layout of its file.
+const vaultMagic = "HVLT"
+
+// Hash vaults start with an index of 1 << bits buckets. Whenever the buckets
+// of the store, overflows included, reach twice the buckets of the index,
+// the vault doubles the index and rehashes its items into a new store that
+// replaces the old one, so that overflow chains stay short as the vault
+// grows. This is the point at which papirus would double the index on its
+// own, which therefore never happens. A vault expected to be large is better
+// created at its size to spare the successive doublings.
+const (
+	DefaultBitsForBucket = 8
+	MaxBitsForBucket     = 28
+)
+
+// hashVault is a set of hashes kept on a papirus hash store.
 type hashVault struct {
-	hs *papirus.HashStore[crypto.Hash]
+	mu     sync.Mutex // serializes access to the store
+	name   string
+	path   string // file of the store, empty for memory vaults
+	hs     *papirus.HashStore[crypto.Hash]
+	store  papirus.ByteStore
+	bits   int64       // bits for bucket of the index
+	digest setDigest   // digest of the stored hashes
+	views  []*hashView // frozen views of the vault
+	closed bool
+	undo   *undoJournal
 }
 
+func newByteStore(path string, bits int64) papirus.ByteStore {
+	size := papirus.HeaderSize + vaultBucketBytes*(int64(1)<<bits)
+	if path == "" {
+		return papirus.NewMemoryStore(size)
+	}
+	return papirus.NewFileStore(path, size)
+}
+
+func newHashStore(name string, store papirus.ByteStore, bits int64) *papirus.HashStore[crypto.Hash] {
+	buckets := papirus.NewBucketStore(vaultItemBytes, vaultItemsPerBucket, store)
+	hs := papirus.NewHashStore(name, buckets, int(bits), deleteOrInsert)
+	hs.Start()
+	return hs
+}
+
+func stopHashStore(hs *papirus.HashStore[crypto.Hash]) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Error("hashVault: could not stop hash store", "msg", err)
+			ok = false
+		}
+	}()
+	stopped := make(chan bool)
+	hs.Stop <- stopped
+	return <-stopped
+}
+
+func (w *hashVault) writeHeader() {
+	w.store.WriteAt(0, append([]byte(vaultMagic), byte(w.bits)))
+}
+
+// headerBits returns the bits for bucket recorded in the header of store.
+func headerBits(store papirus.ByteStore) (int64, bool) {
+	if store.Size() < papirus.HeaderSize+vaultBucketBytes {
+		return 0, false
+	}
+	header := store.ReadAt(0, int64(len(vaultMagic))+1)
+	bits := int64(header[len(vaultMagic)])
+	if string(header[:len(vaultMagic)]) != vaultMagic || bits < DefaultBitsForBucket || bits > 32 {
+		return 0, false
+	}
+	return bits, true
+}
+
+// Clone returns an in-memory copy of the vault.
 func (h *hashVault) Clone() *hashVault {
-	return &hashVault{
-		hs: h.hs.Clone(),
+	h.mu.Lock()
+	data := h.store.ReadAt(0, h.store.Size())
+	clone := &hashVault{name: h.name, bits: h.bits, digest: h.digest.clone()}
+	h.mu.Unlock()
+	clone.store = papirus.NewMemoryStore(0)
+	clone.store.Append(data)
+	index := indexStore(clone.store, clone.bits, nil)
+	if index == nil {
+		slog.Error("hashVault.Clone: inconsistent bucket layout", "name", h.name)
+		return nil
 	}
+	clone.hs = papirus.NewHashStoreFromClonedBytes(clone.name, clone.store, deleteOrInsert, index)
+	clone.hs.Start()
+	return clone
 }
 
-func (w *hashVault) ExistsHash(hash crypto.Hash) bool {
+// Digest returns the digest of the hashes in the vault. It is kept up to date
+// on every insert and remove, checks persisted files against the state
+// metadata and is part of the state checksum.
+func (h *hashVault) Digest() crypto.Hash {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.digest.Hash()
+}
+
+// query runs op for hash on the hash store. The caller holds the lock.
+func (w *hashVault) query(hash crypto.Hash, op byte) bool {
 	response := make(chan papirus.QueryResult)
-	ok, _ := w.hs.Query(papirus.Query[crypto.Hash]{Hash: hash, Param: []byte{exists}, Response: response})
+	ok, _ := w.hs.Query(papirus.Query[crypto.Hash]{Hash: hash, Param: []byte{op}, Response: response})
 	return ok
 }
 
+func (w *hashVault) ExistsHash(hash crypto.Hash) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.query(hash, exists)
+}
+
 func (w *hashVault) ExistsToken(token crypto.Token) bool {
 	hash := crypto.HashToken(token)
 	return w.ExistsHash(hash)
 }
 
 func (w *hashVault) InsertHash(hash crypto.Hash) bool {
-	response := make(chan papirus.QueryResult)
-	ok, _ := w.hs.Query(papirus.Query[crypto.Hash]{Hash: hash, Param: []byte{insert}, Response: response})
+	w.mu.Lock()
+	ok := w.query(hash, insert)
+	if ok {
+		w.digest.add(hash[:])
+		w.changed(hash, false)
+		if w.buckets() >= 2<<w.bits {
+			w.grow()
+		}
+	}
+	w.mu.Unlock()
+	if ok {
+		w.undo.record(func() { w.RemoveHash(hash) })
+	}
 	return ok
 }
 
@@ -84,8 +246,16 @@ func (w *hashVault) InsertToken(token crypto.Token) bool {
 }
 
 func (w *hashVault) RemoveHash(hash crypto.Hash) bool {
-	response := make(chan papirus.QueryResult)
-	ok, _ := w.hs.Query(papirus.Query[crypto.Hash]{Hash: hash, Param: []byte{remove}, Response: response})
+	w.mu.Lock()
+	ok := w.query(hash, remove)
+	if ok {
+		w.digest.remove(hash[:])
+		w.changed(hash, true)
+	}
+	w.mu.Unlock()
+	if ok {
+		w.undo.record(func() { w.InsertHash(hash) })
+	}
 	return ok
 }
 
@@ -94,85 +264,365 @@ func (w *hashVault) RemoveToken(token crypto.Token) bool {
 	return w.RemoveHash(hash)
 }
 
+// buckets returns the number of buckets of the store, overflows included.
+func (w *hashVault) buckets() int64 {
+	return (w.store.Size() - papirus.HeaderSize) / vaultBucketBytes
+}
+
+// grow doubles the index of the vault. The items are rehashed into a new
+// store, written next to the file of a file-backed vault and renamed over it
+// once complete. The old store is closed unless a frozen view still reads
+// it. The caller holds the lock.
+func (w *hashVault) grow() {
+	bits := w.bits + 1
+	path := ""
+	if w.path != "" {
+		path = w.path + ".grow"
+	}
+	store := newByteStore(path, bits)
+	hs := newHashStore(w.name, store, bits)
+	response := make(chan papirus.QueryResult)
+	for first := int64(0); first < int64(1)<<w.bits; first += vaultReadBuckets {
+		for _, hash := range chainItems(w.store, first, readCount(first, int64(1)<<w.bits)) {
+			hs.Query(papirus.Query[crypto.Hash]{Hash: hash, Param: []byte{insert}, Response: response})
+		}
+	}
+	stopHashStore(w.hs)
+	if path != "" {
+		if err := os.Rename(path, w.path); err != nil {
+			slog.Error("hashVault.grow: could not replace store", "name", w.name, "err", err)
+		}
+	}
+	old := w.store
+	w.hs, w.store, w.bits = hs, store, bits
+	w.writeHeader()
+	if !w.reading(old) {
+		old.Close()
+	}
+}
+
 func (w *hashVault) Close() bool {
-	defer func() {
-		if err := recover(); err != nil {
-			slog.Error("hashVault.Close", "msg", err)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ok := stopHashStore(w.hs)
+	w.closed = true
+	if !w.reading(w.store) {
+		w.store.Close()
+	}
+	return ok
+}
+
+// readCount returns the number of buckets read at once from bucket first of
+// an index of base buckets.
+func readCount(first, base int64) int64 {
+	if base-first < vaultReadBuckets {
+		return base - first
+	}
+	return vaultReadBuckets
+}
+
+// chainItems returns the hashes stored on the chains of count buckets of the
+// index of store, from bucket first on. Items of a chain are contiguous, the
+// first empty slot ends the chain.
+func chainItems(store papirus.ByteStore, first, count int64) []crypto.Hash {
+	data := store.ReadAt(papirus.HeaderSize+first*vaultBucketBytes, count*vaultBucketBytes)
+	items := make([]crypto.Hash, 0)
+	for n := int64(0); n < count; n++ {
+		bucket := data[n*vaultBucketBytes : (n+1)*vaultBucketBytes]
+		for bucket != nil {
+			for item := 0; item < vaultItemsPerBucket; item++ {
+				hash := crypto.Hash(bucket[item*vaultItemBytes : (item+1)*vaultItemBytes])
+				if hash == crypto.ZeroValueHash {
+					bucket = nil
+					break
+				}
+				items = append(items, hash)
+			}
+			if bucket == nil {
+				break
+			}
+			overflow := int64(binary.LittleEndian.Uint64(bucket[vaultItemsPerBucket*vaultItemBytes:]))
+			if overflow == 0 {
+				break
+			}
+			bucket = store.ReadAt(papirus.HeaderSize+overflow*vaultBucketBytes, vaultBucketBytes)
 		}
-	}()
-	ok := make(chan bool)
-	w.hs.Stop <- ok
-	return <-ok
+	}
+	return items
 }
 
+// OpenHashVaultFromFile reopens a file-backed vault. Papirus keeps the item
+// count of each bucket only in memory, so the index is rebuilt by walking the
+// buckets of the file, laid out for the bits recorded in its header, or for
+// bitsForBucket if the file has no header. Returns nil if the layout of the
+// file is not consistent.
 func OpenHashVaultFromFile(name string, epoch uint64, bitsForBucket int64, dataPath string) *hashVault {
-	if store := papirus.OpenFileStore(filepath.Join(dataPath, name)); store == nil {
-		slog.Error("OpenHashVaultFromFile: OpenFileStore returned nil")
+	path := filepath.Join(dataPath, name)
+	store := papirus.OpenFileStore(path)
+	if store == nil {
+		slog.Error("OpenHashVaultFromFile: OpenFileStore returned nil", "name", name)
+		return nil
+	}
+	bits, ok := headerBits(store)
+	if !ok {
+		// files written before the header was introduced have the bits for
+		// bucket they were created with, checked by indexStore below
+		bits = bitsForBucket
+	}
+	vault := &hashVault{name: name, path: path, store: store, bits: bits}
+	index := indexStore(store, bits, func(hash crypto.Hash) { vault.digest.add(hash[:]) })
+	if index == nil {
+		slog.Error("OpenHashVaultFromFile: inconsistent bucket layout", "name", name)
+		store.Close()
 		return nil
-	} else {
-		bucketstore := papirus.NewBucketStore(32, 6, store)
-		if bucketstore == nil {
-			slog.Error("OpenHashVaultFromFile: NewBucketStore returned nil")
-			return nil
-		}
-		hs := papirus.NewHashStore(name, bucketstore, int(bitsForBucket), deleteOrInsert)
-		vault := &hashVault{
-			hs: hs,
-		}
-		vault.hs.Start()
-		return vault
 	}
+	if !ok {
+		vault.writeHeader()
+	}
+	vault.hs = papirus.NewHashStoreFromClonedBytes(name, store, deleteOrInsert, index)
+	vault.hs.Start()
+	return vault
 }
 
-func NewHashVault(name string, epoch uint64, bitsForBucket int64, dataPath string) *hashVault {
-	nbytes := 56 + (32*6+8)*int64(1<<bitsForBucket)
-	var bytestore papirus.ByteStore
-	if dataPath == "" {
-		if store := papirus.NewMemoryStore(nbytes); store == nil {
-			slog.Error("NewHashVault: NewMemoryStore returned nil")
-			return nil
-		} else {
-			bytestore = store
+// VaultItems is the expected number of items of each hash vault of a state,
+// by vault name, used to size new vaults to hold them without overflow
+// buckets. It is a setting of the node, not of the network: the size of
+// reopened vaults is recovered from them.
+type VaultItems map[string]int64
+
+// HashVaultNames returns the names of the hash vaults of a state.
+func HashVaultNames() []string {
+	return append([]string{}, hashVaultNames...)
+}
+
+// bitsForBucket returns the initial bits for bucket of the hash vault name.
+func (v VaultItems) bitsForBucket(name string) int64 {
+	bits := int64(DefaultBitsForBucket)
+	for bits < MaxBitsForBucket && vaultItemsPerBucket<<bits < v[name] {
+		bits++
+	}
+	return bits
+}
+
+// indexStore returns the papirus hashstore index (bits for bucket, item count
+// per bucket and free overflow buckets) of the buckets of store laid out for
+// bits, calling item, if not nil, for every stored hash. Returns nil if the
+// layout is not consistent: a chain that loops or shares a bucket, an item
+// on the chain of another bucket, a gap within a chain or a bucket that is
+// neither on a chain nor empty.
+func indexStore(store papirus.ByteStore, bits int64, item func(crypto.Hash)) []byte {
+	size := store.Size()
+	if size <= papirus.HeaderSize || (size-papirus.HeaderSize)%vaultBucketBytes != 0 {
+		return nil
+	}
+	bucketCount := (size - papirus.HeaderSize) / vaultBucketBytes
+	base := int64(1) << bits
+	if base > bucketCount {
+		return nil
+	}
+	mask := base - 1
+	visited := make([]bool, bucketCount)
+	counts := make([]uint64, base)
+	for first := int64(0); first < base; first += vaultReadBuckets {
+		count := readCount(first, base)
+		data := store.ReadAt(papirus.HeaderSize+first*vaultBucketBytes, count*vaultBucketBytes)
+		for n := first; n < first+count; n++ {
+			bucket, at := data[(n-first)*vaultBucketBytes:(n-first+1)*vaultBucketBytes], n
+			ended := false
+			for {
+				if visited[at] {
+					return nil
+				}
+				visited[at] = true
+				for slot := 0; slot < vaultItemsPerBucket; slot++ {
+					hash := crypto.Hash(bucket[slot*vaultItemBytes : (slot+1)*vaultItemBytes])
+					if hash == crypto.ZeroValueHash {
+						ended = true
+						continue
+					}
+					if ended || hash.ToInt64()&mask != n {
+						return nil
+					}
+					counts[n] += 1
+					if item != nil {
+						item(hash)
+					}
+				}
+				overflow := int64(binary.LittleEndian.Uint64(bucket[vaultItemsPerBucket*vaultItemBytes:]))
+				if overflow == 0 {
+					break
+				}
+				if overflow < base || overflow >= bucketCount {
+					return nil
+				}
+				at = overflow
+				bucket = store.ReadAt(papirus.HeaderSize+at*vaultBucketBytes, vaultBucketBytes)
+			}
 		}
-	} else {
-		if store := papirus.NewFileStore(filepath.Join(dataPath, name), nbytes); store == nil {
-			slog.Error("NewHashVault: NewFileStore returned nil")
-			return nil
-		} else {
-			bytestore = store
+	}
+	free := make([]int64, 0)
+	for bucket := base; bucket < bucketCount; bucket++ {
+		if visited[bucket] {
+			continue
+		}
+		for _, b := range store.ReadAt(papirus.HeaderSize+bucket*vaultBucketBytes, vaultBucketBytes) {
+			if b != 0 {
+				return nil
+			}
 		}
+		free = append(free, bucket)
 	}
-	bucketstore := papirus.NewBucketStore(32, 6, bytestore)
-	if bucketstore == nil {
-		slog.Error("NewHashVault: NewBucketStore returned nil")
-		return nil
+	index := []byte{byte(bits)}
+	util.PutUint64(vaultItemBytes, &index)
+	util.PutUint64(vaultItemsPerBucket, &index)
+	util.PutUint64(uint64(base), &index)
+	for _, count := range counts {
+		util.PutUint64(count, &index)
 	}
-	vault := &hashVault{
-		hs: papirus.NewHashStore(name, bucketstore, int(bitsForBucket), deleteOrInsert),
+	util.PutUint64(uint64(len(free)), &index)
+	for _, bucket := range free {
+		util.PutUint64(uint64(bucket), &index)
 	}
-	vault.hs.Start()
+	return index
+}
+
+func NewHashVault(name string, epoch uint64, bitsForBucket int64, dataPath string) *hashVault {
+	vault := &hashVault{name: name, bits: bitsForBucket}
+	if dataPath != "" {
+		vault.path = filepath.Join(dataPath, name)
+	}
+	vault.store = newByteStore(vault.path, bitsForBucket)
+	vault.writeHeader()
+	vault.hs = newHashStore(name, vault.store, bitsForBucket)
 	return vault
 }
 
-func NewFileHashVaultFromBytes(filePath, name string, data []byte) *hashVault {
-	bytestore := papirus.NewFileStore(filePath, 0)
-	return newHashVaultFromBytes(name, bytestore, data)
+func NewFileHashVaultFromBytes(dataPath, name string, data []byte) *hashVault {
+	path := filepath.Join(dataPath, name)
+	return newHashVaultFromBytes(name, path, papirus.NewFileStore(path, 0), data)
 }
 
 func NewMemoryHashVaultFromBytes(name string, data []byte) *hashVault {
-	bytestore := papirus.NewMemoryStore(0)
-	return newHashVaultFromBytes(name, bytestore, data)
+	return newHashVaultFromBytes(name, "", papirus.NewMemoryStore(0), data)
 }
 
-func newHashVaultFromBytes(name string, store papirus.ByteStore, data []byte) *hashVault {
+func newHashVaultFromBytes(name, path string, store papirus.ByteStore, data []byte) (vault *hashVault) {
+	// papirus trusts the sizes in data, invalid data must not bring the node
+	// down
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Error("newHashVaultFromBytes: invalid data", "name", name, "msg", err)
+			vault = nil
+		}
+	}()
 	hs := papirus.NewHashStoreFromClonedBytes(name, store, deleteOrInsert, data)
-	vault := &hashVault{
-		hs: hs,
+	if hs == nil {
+		slog.Error("newHashVaultFromBytes: invalid data", "name", name)
+		return nil
 	}
+	vault = &hashVault{name: name, path: path, hs: hs, store: store, bits: int64(data[0])}
+	vault.writeHeader()
 	vault.hs.Start()
+	for first := int64(0); first < int64(1)<<vault.bits; first += vaultReadBuckets {
+		for _, hash := range chainItems(store, first, readCount(first, int64(1)<<vault.bits)) {
+			vault.digest.add(hash[:])
+		}
+	}
 	return vault
 }
 
 func (h *hashVault) Bytes() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	return h.hs.Bytes()
 }
+
+// hashView is the content of a hash vault frozen at some point, read while
+// the vault keeps changing. Changes to the vault record, for every hash they
+// touch first, whether it was in the view, so that a reader of the store
+// skips those hashes and adds back the ones that were removed.
+type hashView struct {
+	vault   *hashVault
+	store   papirus.ByteStore
+	bits    int64
+	digest  crypto.Hash
+	touched map[crypto.Hash]bool // hash changed since the view -> in the view
+}
+
+// freeze returns a view of the current content of the vault. The view must
+// be released once read.
+func (w *hashVault) freeze() *hashView {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	view := &hashView{vault: w, store: w.store, bits: w.bits, digest: w.digest.Hash(), touched: make(map[crypto.Hash]bool)}
+	w.views = append(w.views, view)
+	return view
+}
+
+// changed records in the views of the vault that hash, first touched,
+// was in them or not. The caller holds the lock.
+func (w *hashVault) changed(hash crypto.Hash, inView bool) {
+	for _, view := range w.views {
+		if _, ok := view.touched[hash]; !ok {
+			view.touched[hash] = inView
+		}
+	}
+}
+
+// reading checks if a view reads store. The caller holds the lock.
+func (w *hashVault) reading(store papirus.ByteStore) bool {
+	for _, view := range w.views {
+		if view.store == store {
+			return true
+		}
+	}
+	return false
+}
+
+// each calls do for every hash of the view, holding the lock of the vault
+// only while reading a batch of buckets.
+func (v *hashView) each(do func(crypto.Hash)) {
+	base := int64(1) << v.bits
+	for first := int64(0); first < base; first += vaultReadBuckets {
+		v.vault.mu.Lock()
+		items := chainItems(v.store, first, readCount(first, base))
+		kept := items[:0]
+		for _, hash := range items {
+			if _, ok := v.touched[hash]; !ok {
+				kept = append(kept, hash)
+			}
+		}
+		v.vault.mu.Unlock()
+		for _, hash := range kept {
+			do(hash)
+		}
+	}
+	v.vault.mu.Lock()
+	removed := make([]crypto.Hash, 0)
+	for hash, inView := range v.touched {
+		if inView {
+			removed = append(removed, hash)
+		}
+	}
+	v.vault.mu.Unlock()
+	for _, hash := range removed {
+		do(hash)
+	}
+}
+
+// release closes the view, and the store it read if the vault grew or was
+// closed since.
+func (v *hashView) release() {
+	w := v.vault
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for n, view := range w.views {
+		if view == v {
+			w.views = append(w.views[:n], w.views[n+1:]...)
+			break
+		}
+	}
+	if (v.store != w.store || w.closed) && !w.reading(v.store) {
+		v.store.Close()
+	}
+}
@@ -0,0 +1,314 @@
+package attorney
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// vaultItems returns the hashes stored in vault.
+func vaultItems(vault *hashVault) []crypto.Hash {
+	view := vault.freeze()
+	defer view.release()
+	items := make([]crypto.Hash, 0)
+	view.each(func(hash crypto.Hash) {
+		items = append(items, hash)
+	})
+	return items
+}
+
+func digestOf(items []crypto.Hash) crypto.Hash {
+	var digest setDigest
+	for _, hash := range items {
+		digest.add(hash[:])
+	}
+	return digest.Hash()
+}
+
+func sameHashes(a, b []crypto.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[crypto.Hash]int)
+	for _, hash := range a {
+		count[hash]++
+	}
+	for _, hash := range b {
+		if count[hash] == 0 {
+			return false
+		}
+		count[hash]--
+	}
+	return true
+}
+
+// bucketHashes returns count hashes that fall on the same bucket of a vault.
+func bucketHashes(bucket byte, count int) []crypto.Hash {
+	hashes := make([]crypto.Hash, count)
+	for n := range hashes {
+		hash := crypto.Hasher([]byte{bucket, byte(n), byte(n >> 8)})
+		hash[0], hash[1], hash[2], hash[3] = bucket, 0, 0, 0
+		hashes[n] = hash
+	}
+	return hashes
+}
+
+// crowdBucket inserts count hashes on the bucket of hash of vault, so that
+// hash is no longer the last item of its bucket chain.
+func crowdBucket(vault *hashVault, hash crypto.Hash, count int) []crypto.Hash {
+	neighbours := bucketHashes(hash[0], count)
+	for n := range neighbours {
+		neighbours[n][1], neighbours[n][2], neighbours[n][3] = hash[1], hash[2], hash[3]
+		vault.InsertHash(neighbours[n])
+	}
+	return neighbours
+}
+
+// checkCrowd checks that removed left vault while every neighbour stayed.
+func checkCrowd(t *testing.T, vault *hashVault, removed crypto.Hash, neighbours []crypto.Hash) {
+	t.Helper()
+	if vault.ExistsHash(removed) {
+		t.Fatalf("removed hash %v found", removed)
+	}
+	for _, hash := range neighbours {
+		if !vault.ExistsHash(hash) {
+			t.Fatalf("neighbour %v lost", hash)
+		}
+	}
+	if digestOf(vaultItems(vault)) != vault.Digest() {
+		t.Fatal("digest does not match vault items")
+	}
+}
+
+func checkVault(t *testing.T, vault *hashVault, stored, removed []crypto.Hash) {
+	t.Helper()
+	for _, hash := range stored {
+		if !vault.ExistsHash(hash) {
+			t.Fatalf("stored hash %v not found", hash)
+		}
+	}
+	for _, hash := range removed {
+		if vault.ExistsHash(hash) {
+			t.Fatalf("removed hash %v found", hash)
+		}
+	}
+	items := vaultItems(vault)
+	if len(items) != len(stored) {
+		t.Fatalf("vault has %v items, expected %v", len(items), len(stored))
+	}
+	if digestOf(items) != vault.Digest() {
+		t.Fatal("digest does not match vault items")
+	}
+}
+
+func TestHashVaultRemove(t *testing.T) {
+	// 20 items span four buckets of the chain, every position is removed
+	for position := 0; position < 20; position++ {
+		vault := NewHashVault("test", 0, DefaultBitsForBucket, "")
+		hashes := bucketHashes(7, 20)
+		for _, hash := range hashes {
+			vault.InsertHash(hash)
+		}
+		if !vault.RemoveHash(hashes[position]) {
+			t.Fatalf("could not remove item %v", position)
+		}
+		removed := []crypto.Hash{hashes[position]}
+		stored := append(append([]crypto.Hash{}, hashes[:position]...), hashes[position+1:]...)
+		checkVault(t, vault, stored, removed)
+		if vault.RemoveHash(hashes[position]) {
+			t.Fatalf("item %v removed twice", position)
+		}
+		vault.Close()
+	}
+}
+
+func TestHashVaultRemoveAndInsert(t *testing.T) {
+	vault := NewHashVault("test", 0, DefaultBitsForBucket, "")
+	hashes := bucketHashes(3, 30)
+	for _, hash := range hashes[:18] {
+		vault.InsertHash(hash)
+	}
+	// emptying the chain from its middle and filling it again reuses the
+	// overflow buckets left behind
+	stored := append([]crypto.Hash{}, hashes[:18]...)
+	removed := make([]crypto.Hash, 0)
+	for len(stored) > 0 {
+		middle := len(stored) / 2
+		if !vault.RemoveHash(stored[middle]) {
+			t.Fatalf("could not remove %v", stored[middle])
+		}
+		removed = append(removed, stored[middle])
+		stored = append(stored[:middle], stored[middle+1:]...)
+		checkVault(t, vault, stored, removed)
+	}
+	for _, hash := range hashes {
+		vault.InsertHash(hash)
+	}
+	checkVault(t, vault, hashes, nil)
+	vault.Close()
+}
+
+func TestFileHashVaultRemoveReopen(t *testing.T) {
+	dir := t.TempDir()
+	vault := NewHashVault("test", 0, DefaultBitsForBucket, dir)
+	hashes := append(bucketHashes(1, 13), bucketHashes(2, 6)...)
+	for _, hash := range hashes {
+		vault.InsertHash(hash)
+	}
+	removed := []crypto.Hash{hashes[0], hashes[7], hashes[14]}
+	for _, hash := range removed {
+		vault.RemoveHash(hash)
+	}
+	stored := make([]crypto.Hash, 0)
+	for n, hash := range hashes {
+		if n != 0 && n != 7 && n != 14 {
+			stored = append(stored, hash)
+		}
+	}
+	digest := vault.Digest()
+	vault.Close()
+	reopened := OpenHashVaultFromFile("test", 0, DefaultBitsForBucket, dir)
+	if reopened == nil {
+		t.Fatal("could not reopen vault")
+	}
+	if reopened.Digest() != digest {
+		t.Fatal("reopened vault does not match its digest")
+	}
+	checkVault(t, reopened, stored, removed)
+	reopened.Close()
+}
+
+func TestHashVaultGrow(t *testing.T) {
+	dir := t.TempDir()
+	vault := NewHashVault("test", 0, DefaultBitsForBucket, dir)
+	hashes := make([]crypto.Hash, 0)
+	for n := 0; n < 6000; n++ {
+		hash := crypto.Hasher([]byte{byte(n), byte(n >> 8)})
+		hashes = append(hashes, hash)
+		vault.InsertHash(hash)
+	}
+	if vault.bits <= DefaultBitsForBucket {
+		t.Fatal("vault did not grow")
+	}
+	if vault.buckets() >= 2<<vault.bits {
+		t.Fatalf("%v buckets for %v bits", vault.buckets(), vault.bits)
+	}
+	checkVault(t, vault, hashes, nil)
+	bits, digest := vault.bits, vault.Digest()
+	vault.Close()
+	reopened := OpenHashVaultFromFile("test", 0, DefaultBitsForBucket, dir)
+	if reopened == nil || reopened.bits != bits || reopened.Digest() != digest {
+		t.Fatal("could not reopen grown vault")
+	}
+	checkVault(t, reopened, hashes, nil)
+	reopened.Close()
+}
+
+func TestHashVaultView(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		vault := NewHashVault("test", 0, DefaultBitsForBucket, dir)
+		frozen := make([]crypto.Hash, 0)
+		for n := 0; n < 1000; n++ {
+			hash := crypto.Hasher([]byte{byte(n), byte(n >> 8), 1})
+			frozen = append(frozen, hash)
+			vault.InsertHash(hash)
+		}
+		view := vault.freeze()
+		// removing, inserting back and growing the vault leave the view as
+		// it was frozen
+		for _, hash := range frozen[:100] {
+			vault.RemoveHash(hash)
+		}
+		vault.InsertHash(frozen[0])
+		for n := 0; n < 5000; n++ {
+			vault.InsertHash(crypto.Hasher([]byte{byte(n), byte(n >> 8), 2}))
+		}
+		if vault.bits <= DefaultBitsForBucket {
+			t.Fatal("vault did not grow")
+		}
+		items := make([]crypto.Hash, 0)
+		view.each(func(hash crypto.Hash) {
+			items = append(items, hash)
+		})
+		view.release()
+		if !sameHashes(items, frozen) || view.digest != digestOf(frozen) {
+			t.Fatal("view does not match the frozen vault")
+		}
+		if len(vaultItems(vault)) != 5000+901 {
+			t.Fatal("vault does not match")
+		}
+		vault.Close()
+	}
+}
+
+func TestOpenHashVaultHeader(t *testing.T) {
+	dir := t.TempDir()
+	vault := NewHashVault("test", 0, DefaultBitsForBucket+2, dir)
+	hashes := make([]crypto.Hash, 0)
+	for n := 0; n < 50; n++ {
+		hashes = append(hashes, crypto.Hasher([]byte{byte(n), 3}))
+		vault.InsertHash(hashes[n])
+	}
+	vault.Close()
+	reopened := OpenHashVaultFromFile("test", 0, DefaultBitsForBucket, dir)
+	if reopened == nil || reopened.bits != DefaultBitsForBucket+2 {
+		t.Fatal("bits for bucket not read from the header")
+	}
+	checkVault(t, reopened, hashes, nil)
+	reopened.Close()
+	// a file written without header is opened with the given bits for
+	// bucket, checked against its layout
+	path := filepath.Join(dir, "test")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(data, make([]byte, len(vaultMagic)+1))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if OpenHashVaultFromFile("test", 0, DefaultBitsForBucket, dir) != nil {
+		t.Fatal("opened a file with the wrong bits for bucket")
+	}
+	legacy := OpenHashVaultFromFile("test", 0, DefaultBitsForBucket+2, dir)
+	if legacy == nil {
+		t.Fatal("could not open a file without header")
+	}
+	checkVault(t, legacy, hashes, nil)
+	legacy.Close()
+	if reopened := OpenHashVaultFromFile("test", 0, DefaultBitsForBucket, dir); reopened == nil || reopened.bits != DefaultBitsForBucket+2 {
+		t.Fatal("header not written on a file without header")
+	} else {
+		reopened.Close()
+	}
+}
+
+func TestVaultItemsBits(t *testing.T) {
+	items := VaultItems{"members": vaultItemsPerBucket << 12, "captions": vaultItemsPerBucket<<12 + 1, "attorneys": 1 << 62}
+	if bits := items.bitsForBucket("members"); bits != 12 {
+		t.Fatalf("members sized with %v bits", bits)
+	}
+	if bits := items.bitsForBucket("captions"); bits != 13 {
+		t.Fatalf("captions sized with %v bits", bits)
+	}
+	if bits := items.bitsForBucket("attorneys"); bits != MaxBitsForBucket {
+		t.Fatalf("attorneys sized with %v bits", bits)
+	}
+	if bits := VaultItems(nil).bitsForBucket("members"); bits != DefaultBitsForBucket {
+		t.Fatalf("unsized vault with %v bits", bits)
+	}
+}
+
+func TestSizedGenesisState(t *testing.T) {
+	sized := NewSizedGenesisState("", DefaultParameters, VaultItems{"members": 1 << 16})
+	state := NewGenesisState("")
+	alice := newTestMember()
+	join := alice.join(1, "alice")
+	incorporate(t, sized, 1, join)
+	incorporate(t, state, 1, join)
+	if sized.Checksum() != state.Checksum() {
+		t.Fatal("vault size changed the state checksum")
+	}
+}
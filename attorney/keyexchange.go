@@ -0,0 +1,97 @@
+package attorney
+
+import (
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// MaxSecretSize is the maximum size in bytes of the secret carried by a key
+// exchange.
+const MaxSecretSize = 512
+
+// KeyExchange shares a secret between two members. Secret is encrypted for To
+// with the ephemeral key Ephemeral, so that only To can read it. The action is
+// signed by Attorney, which is either Author or an attorney of Author. It
+// does not change the state: it is recorded on chain and routed by indexers
+// to both parties.
+type KeyExchange struct {
+	Epoch     uint64
+	Author    crypto.Token
+	To        crypto.Token
+	Ephemeral crypto.Token
+	Secret    []byte
+	Attorney  crypto.Token
+	Signature crypto.Signature
+}
+
+func (k *KeyExchange) Tokens() []crypto.Token {
+	if k.Attorney.Equal(k.Author) {
+		return []crypto.Token{k.Author, k.To}
+	}
+	return []crypto.Token{k.Author, k.To, k.Attorney}
+}
+
+func (k *KeyExchange) Validate(v ActionValidator) error {
+	if !v.HasMember(k.Author) || !v.HasMember(k.To) {
+		return ErrNotMember
+	}
+	if !v.PowerOfAttorneyFor(k.Author, k.Attorney, KeyExchangeType, 0) {
+		return ErrNoPowerOfAttorney
+	}
+	return nil
+}
+
+func (k *KeyExchange) Kind() byte {
+	return KeyExchangeType
+}
+
+func (k *KeyExchange) serializeToSign() []byte {
+	bytes := axeHeader(k.Epoch, KeyExchangeType)
+	util.PutToken(k.Author, &bytes)
+	util.PutToken(k.To, &bytes)
+	util.PutToken(k.Ephemeral, &bytes)
+	util.PutByteArray(k.Secret, &bytes)
+	util.PutToken(k.Attorney, &bytes)
+	return bytes
+}
+
+func (k *KeyExchange) Serialize() []byte {
+	bytes := k.serializeToSign()
+	util.PutSignature(k.Signature, &bytes)
+	return bytes
+}
+
+// Sign signs the key exchange with the key of Attorney.
+func (k *KeyExchange) Sign(pk crypto.PrivateKey) {
+	k.Signature = pk.Sign(k.serializeToSign())
+}
+
+func ParseKeyExchange(data []byte) *KeyExchange {
+	action, _ := ParseAction(data).(*KeyExchange)
+	return action
+}
+
+func parseKeyExchange(data []byte) (*KeyExchange, error) {
+	epoch, position, ok := parseAxeHeader(data, KeyExchangeType)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	exchange := KeyExchange{Epoch: epoch}
+	exchange.Author, position = util.ParseToken(data, position)
+	exchange.To, position = util.ParseToken(data, position)
+	exchange.Ephemeral, position = util.ParseToken(data, position)
+	exchange.Secret, position = util.ParseByteArray(data, position)
+	exchange.Attorney, position = util.ParseToken(data, position)
+	hashPosition := position
+	exchange.Signature, position = util.ParseSignature(data, position)
+	if position != len(data) {
+		return nil, ErrMalformed
+	}
+	if len(exchange.Secret) == 0 || len(exchange.Secret) > MaxSecretSize || exchange.Author.Equal(exchange.To) {
+		return nil, ErrMalformed
+	}
+	if !exchange.Attorney.Verify(data[0:hashPosition], exchange.Signature) {
+		return nil, ErrBadSignature
+	}
+	return &exchange, nil
+}
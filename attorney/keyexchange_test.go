@@ -0,0 +1,65 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+)
+
+// exchange signs a key exchange of m, or of author if m is its attorney, with
+// a secret sealed for to.
+func (m testMember) exchange(epoch uint64, author, to testMember, secret string) []byte {
+	exchange := KeyExchange{Epoch: epoch, Author: author.token, To: to.token, Attorney: m.token}
+	if err := exchange.Seal([]byte(secret)); err != nil {
+		panic(err)
+	}
+	exchange.Sign(m.key)
+	return exchange.Serialize()
+}
+
+func TestKeyExchange(t *testing.T) {
+	alice, bob, attorney := newTestMember(), newTestMember(), newTestMember()
+	data := alice.exchange(1, alice, bob, "shared key")
+	exchange := ParseKeyExchange(data)
+	if exchange == nil || Kind(data) != KeyExchangeType {
+		t.Fatal("could not parse key exchange")
+	}
+	if secret, err := exchange.Open(bob.key); err != nil || string(secret) != "shared key" {
+		t.Fatalf("recipient could not open the secret: %v", err)
+	}
+	if _, err := exchange.Open(alice.key); !errors.Is(err, ErrCannotOpen) {
+		t.Fatalf("expected author not to open the secret, got %v", err)
+	}
+	tokens := GetTokens(attorney.exchange(1, alice, bob, "shared key"))
+	if len(tokens) != 3 || tokens[0] != alice.token || tokens[1] != bob.token || tokens[2] != attorney.token {
+		t.Fatal("key exchange not routed to both parties and the attorney")
+	}
+	if ParseKeyExchange(alice.exchange(1, alice, alice, "shared key")) != nil {
+		t.Fatal("key exchange with oneself parsed")
+	}
+	oversized := KeyExchange{Epoch: 1, Author: alice.token, To: bob.token, Secret: make([]byte, MaxSecretSize+1), Attorney: alice.token}
+	oversized.Sign(alice.key)
+	if ParseKeyExchange(oversized.Serialize()) != nil {
+		t.Fatal("oversized secret parsed")
+	}
+}
+
+func TestKeyExchangeValidate(t *testing.T) {
+	alice, bob, carol, attorney := newTestMember(), newTestMember(), newTestMember(), newTestMember()
+	state := NewGenesisState("")
+	incorporate(t, state, 1, alice.join(1, "alice"), bob.join(1, "bob"))
+	rejected := map[error][]byte{
+		ErrNotMember:         alice.exchange(2, alice, carol, "key"),
+		ErrNoPowerOfAttorney: attorney.exchange(2, alice, bob, "key"),
+	}
+	for expected, data := range rejected {
+		if err := state.Validator().ValidateAction(data); !errors.Is(err, expected) {
+			t.Fatalf("expected %v, got %v", expected, err)
+		}
+	}
+	incorporate(t, state, 2, alice.grant(2, attorney, Scope{Kinds: []byte{KeyExchangeType}}))
+	members := state.Members.Clone()
+	incorporate(t, state, 3, alice.exchange(3, alice, bob, "key"), attorney.exchange(3, alice, bob, "key"))
+	if members.Digest() != state.Members.Digest() || state.HasMember(attorney.token) {
+		t.Fatal("key exchange changed the members")
+	}
+}
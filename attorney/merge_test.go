@@ -0,0 +1,93 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// compareMerge validates each of blocks on its own on top of state, as
+// validators of competing blocks at epoch would, and incorporates their merge
+// into a clone of state. The same actions are validated one after the other
+// by a single validator on top of another clone. Both paths must reject the
+// same actions and end with the same checksum. It returns the rejections.
+func compareMerge(t *testing.T, state *State, epoch uint64, blocks ...[][]byte) []error {
+	t.Helper()
+	all := make([]*Mutations, 0)
+	for _, actions := range blocks {
+		all = append(all, validateBlock(t, state, epoch, actions...).Mutations())
+	}
+	merged := (<-state.Clone()).(*State)
+	resolved := merged.resolve(all[0].Merge(all[1:]...))
+	merged.Incorporate(resolved)
+
+	sequential := (<-state.Clone()).(*State)
+	validator := sequential.Validator()
+	validator.SetEpoch(epoch)
+	rejected := make(map[crypto.Hash]error)
+	errs := make([]error, 0)
+	for _, actions := range blocks {
+		for _, action := range actions {
+			if err := validator.ValidateAction(action); err != nil {
+				rejected[crypto.Hasher(action)] = err
+				errs = append(errs, err)
+			}
+		}
+	}
+	sequential.Incorporate(validator.Mutations())
+
+	if len(resolved.Conflicts) != len(rejected) {
+		t.Fatalf("merge left out %v actions, sequential validation %v", len(resolved.Conflicts), len(rejected))
+	}
+	for _, conflict := range resolved.Conflicts {
+		if err, ok := rejected[conflict.Hash]; !ok || !errors.Is(conflict.Reason, err) {
+			t.Fatalf("merge left out %v for %v, sequential validation for %v", conflict.Hash, conflict.Reason, err)
+		}
+	}
+	if merged.Checksum() != sequential.Checksum() {
+		t.Fatal("merged and sequential states do not match")
+	}
+	return errs
+}
+
+func TestMergeRevokeAndAttorneyUpdate(t *testing.T) {
+	state := NewGenesisState("")
+	alice, attorney := newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	incorporate(t, state, 2, alice.grant(2, attorney, Scope{}))
+	errs := compareMerge(t, state, 3, [][]byte{alice.revoke(3, attorney)}, [][]byte{attorney.updateFor(3, alice, `{"n":1}`)})
+	if len(errs) != 1 || !errors.Is(errs[0], ErrNoPowerOfAttorney) {
+		t.Fatalf("expected the update after the revoke rejected, got %v", errs)
+	}
+	// in the other order both are accepted
+	if errs := compareMerge(t, state, 3, [][]byte{attorney.updateFor(3, alice, `{"n":1}`)}, [][]byte{alice.revoke(3, attorney)}); len(errs) != 0 {
+		t.Fatalf("unexpected rejections %v", errs)
+	}
+}
+
+func TestMergeJoinCap(t *testing.T) {
+	state := NewGenesisStateWithParameters("", Parameters{MaxJoinsPerEpoch: 1})
+	errs := compareMerge(t, state, 1, [][]byte{newTestMember().join(1, "carol")}, [][]byte{newTestMember().join(1, "dave")})
+	if len(errs) != 1 || !errors.Is(errs[0], ErrRateLimited) {
+		t.Fatalf("expected the second join rate limited, got %v", errs)
+	}
+}
+
+func TestMergeSameHandle(t *testing.T) {
+	state := NewGenesisState("")
+	errs := compareMerge(t, state, 1, [][]byte{newTestMember().join(1, "bob")}, [][]byte{newTestMember().join(1, "bob")})
+	if len(errs) != 1 {
+		t.Fatalf("expected the second claim rejected, got %v", errs)
+	}
+}
+
+func TestMergeTransferAndUpdate(t *testing.T) {
+	state := NewGenesisState("")
+	alice, bob := newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	errs := compareMerge(t, state, 2, [][]byte{alice.transfer(2, "alice", bob)}, [][]byte{alice.update(2, `{"n":1}`)})
+	if len(errs) != 1 {
+		t.Fatalf("expected the update of the former owner rejected, got %v", errs)
+	}
+}
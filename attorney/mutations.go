@@ -7,18 +7,57 @@ import (
 )
 
 type Mutations struct {
-	GrantPower  map[crypto.Hash]struct{}
-	RevokePower map[crypto.Hash]struct{}
-	NewMembers  map[crypto.Hash]struct{}
-	NewCaption  map[crypto.Hash]struct{}
+	Epoch            uint64 // epoch of the last block of the mutations
+	Blocks           uint64 // number of consecutive blocks the mutations span
+	GrantPower       map[crypto.Hash]struct{}
+	GrantScope       map[crypto.Hash]Scope
+	GrantFingerprint map[crypto.Hash][]byte
+	RevokePower      map[crypto.Hash]struct{}
+	Delegations      map[crypto.Hash]Delegation // tokens of granted and revoked powers
+	NewMembers       map[crypto.Hash]struct{}
+	NewCaption       map[crypto.Hash]struct{}
+	NewHandles       map[crypto.Token]string
+	NewProfiles      map[crypto.Token]string
+	RotateKeys       map[crypto.Token]crypto.Token // retired token -> new token
+	NewGuardians     map[crypto.Token]Guardians
+	NewRecoveries    map[crypto.Token]Recovery
+	CancelRecoveries map[crypto.Token]struct{}
+	Transfers        map[crypto.Token]crypto.Token // previous owner -> recipient
+	Releases         map[crypto.Hash]Tombstone     // skeleton hash of released handle -> tombstone
+	Actions          map[crypto.Hash]uint64        // hash of accepted actions -> epoch
+	NewCollectives   map[crypto.Token]Collective
+	Joins            map[uint64]uint64 // block epoch -> number of new members
+	Log              []LogEntry        // accepted actions in order
+	Conflicts        []Conflict        // actions left out by Merge
+	merged           bool              // Log not yet validated into the other fields
+}
+
+// Delegation identifies the tokens of a power of attorney.
+type Delegation struct {
+	Author   crypto.Token
+	Attorney crypto.Token
 }
 
 func NewMutations() *Mutations {
 	return &Mutations{
-		GrantPower:  make(map[crypto.Hash]struct{}),
-		RevokePower: make(map[crypto.Hash]struct{}),
-		NewMembers:  make(map[crypto.Hash]struct{}),
-		NewCaption:  make(map[crypto.Hash]struct{}),
+		GrantPower:       make(map[crypto.Hash]struct{}),
+		GrantScope:       make(map[crypto.Hash]Scope),
+		GrantFingerprint: make(map[crypto.Hash][]byte),
+		RevokePower:      make(map[crypto.Hash]struct{}),
+		Delegations:      make(map[crypto.Hash]Delegation),
+		NewMembers:       make(map[crypto.Hash]struct{}),
+		NewCaption:       make(map[crypto.Hash]struct{}),
+		NewHandles:       make(map[crypto.Token]string),
+		NewProfiles:      make(map[crypto.Token]string),
+		RotateKeys:       make(map[crypto.Token]crypto.Token),
+		NewGuardians:     make(map[crypto.Token]Guardians),
+		NewRecoveries:    make(map[crypto.Token]Recovery),
+		CancelRecoveries: make(map[crypto.Token]struct{}),
+		Transfers:        make(map[crypto.Token]crypto.Token),
+		Releases:         make(map[crypto.Hash]Tombstone),
+		Actions:          make(map[crypto.Hash]uint64),
+		NewCollectives:   make(map[crypto.Token]Collective),
+		Joins:            make(map[uint64]uint64),
 	}
 }
 
@@ -58,29 +97,97 @@ func (m *Mutations) HasCaption(hash crypto.Hash) bool {
 	return ok
 }
 
-func (m *Mutations) Merge(others ...*Mutations) *Mutations {
-	grouped := &Mutations{
-		GrantPower:  make(map[crypto.Hash]struct{}),
-		RevokePower: make(map[crypto.Hash]struct{}),
-		NewMembers:  make(map[crypto.Hash]struct{}),
-		NewCaption:  make(map[crypto.Hash]struct{}),
+// HandleOf returns the handle claimed by token in these mutations.
+func (m *Mutations) HandleOf(token crypto.Token) (string, bool) {
+	if m.NewHandles == nil {
+		slog.Error("mutations.NewHandles is nil")
+		return "", false
 	}
-	for _, mutations := range others {
-		for hash := range mutations.GrantPower {
-			grouped.GrantPower[hash] = struct{}{}
-		}
-		for hash := range mutations.RevokePower {
-			grouped.RevokePower[hash] = struct{}{}
-			delete(grouped.GrantPower, hash)
-		}
+	handle, ok := m.NewHandles[token]
+	return handle, ok
+}
 
-		for hash := range mutations.NewMembers {
-			grouped.NewMembers[hash] = struct{}{}
-		}
+// Profile returns the latest details published by token in these mutations.
+func (m *Mutations) Profile(token crypto.Token) (string, bool) {
+	if m.NewProfiles == nil {
+		slog.Error("mutations.NewProfiles is nil")
+		return "", false
+	}
+	details, ok := m.NewProfiles[token]
+	return details, ok
+}
+
+// LogEntry records an accepted action. Mutations keep their entries in the
+// order actions were accepted, so that mutations can be merged by validating
+// their actions again one after the other.
+type LogEntry struct {
+	Epoch  uint64        // epoch of the action
+	Hashes []crypto.Hash // hash of the action followed by those of its inner actions
+	block  uint64        // epoch of the validator that accepted the action
+	action Action
+}
 
-		for hash := range mutations.NewCaption {
-			grouped.NewCaption[hash] = struct{}{}
+// Conflict is an action of merged mutations rejected on top of the actions of
+// earlier mutations. It is left out of the merge.
+type Conflict struct {
+	Hash   crypto.Hash // hash of the action
+	Reason error
+}
+
+// Clone returns a copy of the mutations that can be changed without
+// affecting m.
+func (m *Mutations) Clone() *Mutations {
+	return &Mutations{
+		Epoch:            m.Epoch,
+		Blocks:           m.Blocks,
+		GrantPower:       cloneMap(m.GrantPower),
+		GrantScope:       cloneMap(m.GrantScope),
+		GrantFingerprint: cloneMap(m.GrantFingerprint),
+		RevokePower:      cloneMap(m.RevokePower),
+		Delegations:      cloneMap(m.Delegations),
+		NewMembers:       cloneMap(m.NewMembers),
+		NewCaption:       cloneMap(m.NewCaption),
+		NewHandles:       cloneMap(m.NewHandles),
+		NewProfiles:      cloneMap(m.NewProfiles),
+		RotateKeys:       cloneMap(m.RotateKeys),
+		NewGuardians:     cloneMap(m.NewGuardians),
+		NewRecoveries:    cloneMap(m.NewRecoveries),
+		CancelRecoveries: cloneMap(m.CancelRecoveries),
+		Transfers:        cloneMap(m.Transfers),
+		Releases:         cloneMap(m.Releases),
+		Actions:          cloneMap(m.Actions),
+		NewCollectives:   cloneMap(m.NewCollectives),
+		Joins:            cloneMap(m.Joins),
+		Log:              append([]LogEntry{}, m.Log...),
+		Conflicts:        append([]Conflict{}, m.Conflicts...),
+		merged:           m.merged,
+	}
+}
+
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	clone := make(map[K]V, len(m))
+	for key, value := range m {
+		clone[key] = value
+	}
+	return clone
+}
+
+// Merge returns the mutations of m followed by others. The merged mutations
+// span the blocks of all of them but only carry their log: the state that
+// incorporates them, or validates on top of them, validates their actions
+// again one after the other, as if they had been accepted by a single
+// validator. Actions rejected on top of earlier ones, for instance claiming a
+// handle already claimed, are left out and reported in Conflicts.
+func (m *Mutations) Merge(others ...*Mutations) *Mutations {
+	merged := NewMutations()
+	merged.merged = true
+	merged.Conflicts = append(merged.Conflicts, m.Conflicts...)
+	for _, mutations := range append([]*Mutations{m}, others...) {
+		merged.Blocks += mutations.Blocks
+		if mutations.Epoch > merged.Epoch {
+			merged.Epoch = mutations.Epoch
 		}
+		merged.Log = append(merged.Log, mutations.Log...)
 	}
-	return grouped
+	return merged
 }
@@ -0,0 +1,109 @@
+package attorney
+
+import (
+	"log/slog"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// Parameters are the protocol parameters of a network. They are fixed at
+// genesis and carried by the state, so that every node applies the same
+// rules.
+type Parameters struct {
+	ReleaseCooldown  uint64           // epochs a released handle is kept for its previous owner
+	Reserved         []ReservedHandle // handles (and their confusables) no one may claim freely
+	MaxJoinsPerEpoch uint64           // new members per block, zero for no limit
+	JoinWork         uint64           // bits of work to claim a handle of WorkHandleLength or more
+	JoinWorkStep     uint64           // extra bits of work per character below WorkHandleLength
+}
+
+// ReservedHandle is a handle that can only be claimed by Authority. Handles
+// reserved with a zero Authority cannot be claimed at all.
+type ReservedHandle struct {
+	Handle    string
+	Authority crypto.Token
+}
+
+// DefaultParameters are used by NewGenesisState.
+var DefaultParameters = Parameters{
+	ReleaseCooldown: 30 * 24 * 60 * 60, // thirty days of one second epochs
+}
+
+var releaseCooldownKey = crypto.Hasher([]byte("release cooldown"))
+
+func (s *State) setParameters(params Parameters) {
+	s.setParameter(releaseCooldownKey, params.ReleaseCooldown)
+	s.setParameter(maxJoinsKey, params.MaxJoinsPerEpoch)
+	s.setParameter(joinWorkKey, params.JoinWork)
+	s.setParameter(joinWorkStepKey, params.JoinWorkStep)
+	for _, reserved := range params.Reserved {
+		canonical, ok := CanonicalHandle(reserved.Handle)
+		if !ok {
+			slog.Error("State.setParameters: invalid reserved handle", "handle", reserved.Handle)
+			continue
+		}
+		skeleton := crypto.Hasher([]byte(HandleSkeleton(canonical)))
+		record := append(reserved.Authority[:], []byte(canonical)...)
+		s.Reserved.Set(skeleton, record)
+	}
+}
+
+// Parameters returns the protocol parameters of the state. Reserved handles
+// are returned in canonical form.
+func (s *State) Parameters() Parameters {
+	params := Parameters{
+		ReleaseCooldown:  s.parameter(releaseCooldownKey),
+		MaxJoinsPerEpoch: s.parameter(maxJoinsKey),
+		JoinWork:         s.parameter(joinWorkKey),
+		JoinWorkStep:     s.parameter(joinWorkStepKey),
+	}
+	s.Reserved.mu.RLock()
+	defer s.Reserved.mu.RUnlock()
+	for _, hash := range s.Reserved.sortedKeys() {
+		if reserved, ok := parseReservedHandle(s.Reserved.records[hash]); ok {
+			params.Reserved = append(params.Reserved, reserved)
+		}
+	}
+	return params
+}
+
+func parseReservedHandle(data []byte) (ReservedHandle, bool) {
+	if len(data) <= crypto.TokenSize {
+		return ReservedHandle{}, false
+	}
+	reserved := ReservedHandle{Handle: string(data[crypto.TokenSize:])}
+	copy(reserved.Authority[:], data[:crypto.TokenSize])
+	return reserved, true
+}
+
+// ReservedFor returns the authority that may claim handle if handle, or a
+// handle confusable with it, is reserved. The authority is the zero token if
+// no one may claim the handle.
+func (s *State) ReservedFor(handle string) (crypto.Token, bool) {
+	skeleton, ok := skeletonHash(handle)
+	if !ok {
+		return crypto.ZeroToken, false
+	}
+	data, ok := s.Reserved.Get(skeleton)
+	if !ok {
+		return crypto.ZeroToken, false
+	}
+	reserved, _ := parseReservedHandle(data)
+	return reserved.Authority, true
+}
+
+func (s *State) setParameter(key crypto.Hash, value uint64) {
+	bytes := []byte{}
+	util.PutUint64(value, &bytes)
+	s.Config.Set(key, bytes)
+}
+
+func (s *State) parameter(key crypto.Hash) uint64 {
+	data, ok := s.Config.Get(key)
+	if !ok {
+		return 0
+	}
+	value, _ := util.ParseUint64(data, 0)
+	return value
+}
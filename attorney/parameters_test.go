@@ -0,0 +1,40 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestReservedHandles(t *testing.T) {
+	dir := t.TempDir()
+	authority, member := newTestMember(), newTestMember()
+	params := DefaultParameters
+	params.Reserved = []ReservedHandle{{Handle: "Admin"}, {Handle: "freehandle", Authority: authority.token}, {Handle: "not valid"}}
+	state := NewGenesisStateWithParameters(dir, params)
+	state = shutdownAndRecover(t, state, dir)
+	defer state.Shutdown()
+	reserved := state.Parameters().Reserved
+	if len(reserved) != 2 || (reserved[0].Handle != "admin" && reserved[1].Handle != "admin") {
+		t.Fatalf("expected two reserved handles in canonical form, got %v", reserved)
+	}
+	if token, ok := state.ReservedFor("adrnin"); !ok || token != crypto.ZeroToken {
+		t.Fatal("confusable of a reserved handle not reserved")
+	}
+	if token, ok := state.ReservedFor("FreeHandle"); !ok || token != authority.token {
+		t.Fatal("reserved handle not reserved for its authority")
+	}
+	if _, ok := state.ReservedFor("alice"); ok {
+		t.Fatal("handle reserved without being listed")
+	}
+	for _, join := range [][]byte{member.join(1, "admin"), member.join(1, "adrnin"), authority.join(1, "admin"), member.join(1, "freehandle")} {
+		if err := state.Validator().ValidateAction(join); !errors.Is(err, ErrHandleTaken) {
+			t.Fatalf("expected claim of a reserved handle rejected, got %v", err)
+		}
+	}
+	incorporate(t, state, 1, authority.join(1, "freehandle"), member.join(1, "alice"))
+	if token, ok := state.TokenOf("freehandle"); !ok || token != authority.token {
+		t.Fatal("authority could not claim its reserved handle")
+	}
+}
@@ -0,0 +1,424 @@
+package attorney
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// recordVault is a key-value store indexed by a hash with variable length
+// values. It complements hashVault for state that must carry data alongside
+// the key (handle ownership, profiles, etc). Records are kept in memory. If
+// the vault is file-backed every mutation is appended to a journal file that
+// is replayed when the vault is reopened.
+type recordVault struct {
+	mu      sync.RWMutex
+	name    string
+	records map[crypto.Hash][]byte
+	journal *os.File
+	digest  setDigest     // digest of every key and value pair
+	views   []*recordView // frozen views of the vault
+	undo    *undoJournal  // journal of the state owning the vault
+}
+
+func NewRecordVault(name string, dataPath string) *recordVault {
+	vault := &recordVault{
+		name:    name,
+		records: make(map[crypto.Hash][]byte),
+	}
+	if dataPath == "" {
+		return vault
+	}
+	file, err := os.Create(filepath.Join(dataPath, name))
+	if err != nil {
+		slog.Error("NewRecordVault: could not create journal", "name", name, "err", err)
+		return nil
+	}
+	vault.journal = file
+	return vault
+}
+
+// OpenRecordVaultFromFile reopens a file-backed record vault replaying its
+// journal.
+func OpenRecordVaultFromFile(name string, dataPath string) *recordVault {
+	file, err := os.OpenFile(filepath.Join(dataPath, name), os.O_RDWR, 0644)
+	if err != nil {
+		slog.Error("OpenRecordVaultFromFile: could not open journal", "name", name, "err", err)
+		return nil
+	}
+	vault := &recordVault{
+		name:    name,
+		records: make(map[crypto.Hash][]byte),
+		journal: file,
+	}
+	reader := bufio.NewReader(file)
+	for {
+		op, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		var hash crypto.Hash
+		if err == nil {
+			_, err = io.ReadFull(reader, hash[:])
+		}
+		if err != nil {
+			slog.Error("OpenRecordVaultFromFile: truncated journal", "name", name)
+			file.Close()
+			return nil
+		}
+		if op == remove {
+			delete(vault.records, hash)
+			continue
+		}
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(reader, size); err != nil {
+			slog.Error("OpenRecordVaultFromFile: truncated journal", "name", name)
+			file.Close()
+			return nil
+		}
+		length, _ := util.ParseUint32(size, 0)
+		value := make([]byte, int(length))
+		if _, err := io.ReadFull(reader, value); err != nil {
+			slog.Error("OpenRecordVaultFromFile: truncated journal", "name", name)
+			file.Close()
+			return nil
+		}
+		vault.records[hash] = value
+	}
+	for hash, value := range vault.records {
+		vault.digest.add(recordEntry(hash, value))
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		slog.Error("OpenRecordVaultFromFile: could not seek journal", "name", name, "err", err)
+		file.Close()
+		return nil
+	}
+	return vault
+}
+
+func journalEntry(op byte, hash crypto.Hash, value []byte) []byte {
+	entry := []byte{op}
+	util.PutHash(hash, &entry)
+	if op == insert {
+		util.PutUint32(uint32(len(value)), &entry)
+		entry = append(entry, value...)
+	}
+	return entry
+}
+
+func (r *recordVault) appendJournal(op byte, hash crypto.Hash, value []byte) {
+	if r.journal == nil {
+		return
+	}
+	if _, err := r.journal.Write(journalEntry(op, hash, value)); err != nil {
+		slog.Error("recordVault: could not write journal", "name", r.name, "err", err)
+	}
+}
+
+// compact rewrites the journal of a file-backed vault with a single entry per
+// record, so that the journal and the time to replay it do not grow with the
+// history of the vault.
+func (r *recordVault) compact() error {
+	view := r.freeze()
+	defer view.release()
+	return r.compactView(view)
+}
+
+// compactView writes the records of view to a temporary file, without
+// holding the lock of the vault, then appends the journal entries written
+// since the view was frozen and renames the file over the journal.
+func (r *recordVault) compactView(view *recordView) error {
+	if view.journal == "" {
+		return nil
+	}
+	file, err := os.Create(view.journal + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	view.each(func(hash crypto.Hash, value []byte) {
+		writer.Write(journalEntry(insert, hash, value))
+	})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.journal == nil {
+		file.Close()
+		return os.Remove(view.journal + ".tmp")
+	}
+	tail, err := os.Open(view.journal)
+	if err == nil {
+		if _, err = tail.Seek(view.offset, io.SeekStart); err == nil {
+			_, err = io.Copy(writer, tail)
+		}
+		tail.Close()
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(view.journal+".tmp", view.journal); err != nil {
+		return err
+	}
+	journal, err := os.OpenFile(view.journal, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	r.journal.Close()
+	r.journal = journal
+	return nil
+}
+
+// recordEntry is the item of a record in the digest of the vault.
+func recordEntry(hash crypto.Hash, value []byte) []byte {
+	return append(append(make([]byte, 0, crypto.Size+len(value)), hash[:]...), value...)
+}
+
+// Digest returns the digest of all records. It is kept up to date on every
+// mutation, checks persisted files against the state metadata and is part of
+// the state checksum.
+func (r *recordVault) Digest() crypto.Hash {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.digest.Hash()
+}
+
+func (r *recordVault) Get(hash crypto.Hash) ([]byte, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	value, ok := r.records[hash]
+	return value, ok
+}
+
+func (r *recordVault) Exists(hash crypto.Hash) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.records[hash]
+	return ok
+}
+
+// Set inserts or replaces the value associated to hash.
+func (r *recordVault) Set(hash crypto.Hash, value []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := make([]byte, len(value))
+	copy(stored, value)
+	old, ok := r.records[hash]
+	r.changed(hash, old, ok)
+	if ok {
+		r.digest.remove(recordEntry(hash, old))
+		r.undo.record(func() { r.Set(hash, old) })
+	} else {
+		r.undo.record(func() { r.Remove(hash) })
+	}
+	r.digest.add(recordEntry(hash, stored))
+	r.records[hash] = stored
+	r.appendJournal(insert, hash, stored)
+}
+
+// Remove deletes the record associated to hash. Returns false if there was no
+// such record.
+func (r *recordVault) Remove(hash crypto.Hash) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.records[hash]
+	if !ok {
+		return false
+	}
+	r.changed(hash, old, true)
+	r.digest.remove(recordEntry(hash, old))
+	delete(r.records, hash)
+	r.undo.record(func() { r.Set(hash, old) })
+	r.appendJournal(remove, hash, nil)
+	return true
+}
+
+func (r *recordVault) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.records)
+}
+
+// Clone returns an in-memory copy of the vault.
+func (r *recordVault) Clone() *recordVault {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clone := &recordVault{
+		name:    r.name,
+		records: make(map[crypto.Hash][]byte, len(r.records)),
+		digest:  r.digest.clone(),
+	}
+	for hash, value := range r.records {
+		clone.records[hash] = value
+	}
+	return clone
+}
+
+func (r *recordVault) Close() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.journal == nil {
+		return true
+	}
+	if err := r.journal.Close(); err != nil {
+		slog.Error("recordVault.Close", "name", r.name, "err", err)
+		return false
+	}
+	r.journal = nil
+	return true
+}
+
+// sortedKeys returns the record keys in ascending byte order so that any
+// traversal of the vault is independent of insertion order.
+func (r *recordVault) sortedKeys() []crypto.Hash {
+	keys := make([]crypto.Hash, 0, len(r.records))
+	for hash := range r.records {
+		keys = append(keys, hash)
+	}
+	sortHashes(keys)
+	return keys
+}
+
+func sortHashes(hashes []crypto.Hash) {
+	sort.Slice(hashes, func(i, j int) bool {
+		return string(hashes[i][:]) < string(hashes[j][:])
+	})
+}
+
+// Bytes serializes the records ordered by key.
+func (r *recordVault) Bytes() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	bytes := []byte{}
+	util.PutUint64(uint64(len(r.records)), &bytes)
+	for _, hash := range r.sortedKeys() {
+		util.PutHash(hash, &bytes)
+		util.PutLargeByteArray(r.records[hash], &bytes)
+	}
+	return bytes
+}
+
+// frozenRecord is the value of a record when a view was frozen.
+type frozenRecord struct {
+	value  []byte
+	exists bool
+}
+
+// recordView is the content of a record vault frozen at some point, read
+// while the vault keeps changing. Changes to the vault keep, for every record
+// they touch first, its value when the view was frozen.
+type recordView struct {
+	vault   *recordVault
+	keys    []crypto.Hash // keys when the view was frozen
+	digest  crypto.Hash
+	journal string // journal of a file-backed vault
+	offset  int64  // size of the journal when the view was frozen
+	touched map[crypto.Hash]frozenRecord
+}
+
+// freeze returns a view of the current content of the vault. The view must
+// be released once read.
+func (r *recordVault) freeze() *recordView {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	view := &recordView{vault: r, keys: make([]crypto.Hash, 0, len(r.records)), digest: r.digest.Hash(), touched: make(map[crypto.Hash]frozenRecord)}
+	for hash := range r.records {
+		view.keys = append(view.keys, hash)
+	}
+	if r.journal != nil {
+		offset, err := r.journal.Seek(0, io.SeekCurrent)
+		if err != nil {
+			slog.Error("recordVault.freeze: could not seek journal", "name", r.name, "err", err)
+		} else {
+			view.journal, view.offset = r.journal.Name(), offset
+		}
+	}
+	r.views = append(r.views, view)
+	return view
+}
+
+// changed keeps in the views of the vault the value of the record hash before
+// its first change. The caller holds the lock.
+func (r *recordVault) changed(hash crypto.Hash, value []byte, exists bool) {
+	for _, view := range r.views {
+		if _, ok := view.touched[hash]; !ok {
+			view.touched[hash] = frozenRecord{value: value, exists: exists}
+		}
+	}
+}
+
+// each calls do for every record of the view in key order.
+func (v *recordView) each(do func(crypto.Hash, []byte)) {
+	sortHashes(v.keys)
+	for _, hash := range v.keys {
+		v.vault.mu.RLock()
+		frozen, ok := v.touched[hash]
+		if !ok {
+			frozen.value, frozen.exists = v.vault.records[hash]
+		}
+		v.vault.mu.RUnlock()
+		if frozen.exists {
+			do(hash, frozen.value)
+		}
+	}
+}
+
+func (v *recordView) release() {
+	r := v.vault
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for n, view := range r.views {
+		if view == v {
+			r.views = append(r.views[:n], r.views[n+1:]...)
+			break
+		}
+	}
+}
+
+func NewMemoryRecordVaultFromBytes(name string, data []byte) *recordVault {
+	return newRecordVaultFromBytes(NewRecordVault(name, ""), data)
+}
+
+func NewFileRecordVaultFromBytes(dataPath, name string, data []byte) *recordVault {
+	return newRecordVaultFromBytes(NewRecordVault(name, dataPath), data)
+}
+
+func newRecordVaultFromBytes(vault *recordVault, data []byte) *recordVault {
+	if vault == nil {
+		return nil
+	}
+	count, position := util.ParseUint64(data, 0)
+	for n := uint64(0); n < count; n++ {
+		var hash crypto.Hash
+		var value []byte
+		hash, position = util.ParseHash(data, position)
+		if position+4 > len(data) {
+			slog.Error("newRecordVaultFromBytes: invalid data", "name", vault.name)
+			vault.Close()
+			return nil
+		}
+		value, position = util.ParseLargeByteArray(data, position)
+		if position > len(data) {
+			slog.Error("newRecordVaultFromBytes: invalid data", "name", vault.name)
+			vault.Close()
+			return nil
+		}
+		vault.Set(hash, value)
+	}
+	return vault
+}
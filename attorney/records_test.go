@@ -0,0 +1,130 @@
+package attorney
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestRecordVaultReopen(t *testing.T) {
+	dir := t.TempDir()
+	vault := NewRecordVault("test", dir)
+	kept, removed := crypto.Hasher([]byte("kept")), crypto.Hasher([]byte("removed"))
+	vault.Set(kept, []byte("first"))
+	vault.Set(removed, []byte("value"))
+	vault.Set(kept, []byte("second"))
+	vault.Remove(removed)
+	digest := vault.Digest()
+	vault.Close()
+	reopened := OpenRecordVaultFromFile("test", dir)
+	if reopened == nil {
+		t.Fatal("could not reopen vault")
+	}
+	if value, ok := reopened.Get(kept); !ok || string(value) != "second" {
+		t.Fatalf("unexpected value %q", value)
+	}
+	if reopened.Exists(removed) || reopened.Len() != 1 || reopened.Digest() != digest {
+		t.Fatal("reopened vault does not match")
+	}
+	reopened.Close()
+}
+
+func TestRecordVaultCompact(t *testing.T) {
+	dir := t.TempDir()
+	vault := NewRecordVault("test", dir)
+	hash := crypto.Hasher([]byte("key"))
+	for n := 0; n < 1000; n++ {
+		vault.Set(hash, []byte{byte(n), byte(n >> 8)})
+		vault.Set(crypto.Hasher([]byte{byte(n)}), []byte("temporary"))
+		vault.Remove(crypto.Hasher([]byte{byte(n)}))
+	}
+	if err := vault.compact(); err != nil {
+		t.Fatalf("could not compact: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := len(journalEntry(insert, hash, []byte{0, 0})); info.Size() != int64(size) {
+		t.Fatalf("compacted journal has %v bytes, expected %v", info.Size(), size)
+	}
+	// the compacted journal keeps taking new entries
+	other := crypto.Hasher([]byte("other"))
+	vault.Set(other, []byte("after"))
+	digest := vault.Digest()
+	vault.Close()
+	reopened := OpenRecordVaultFromFile("test", dir)
+	if reopened == nil {
+		t.Fatal("could not reopen vault")
+	}
+	if value, _ := reopened.Get(hash); len(value) != 2 || value[0] != byte(999%256) {
+		t.Fatalf("unexpected value %v", value)
+	}
+	if !reopened.Exists(other) || reopened.Digest() != digest {
+		t.Fatal("reopened vault does not match")
+	}
+	reopened.Close()
+}
+
+func TestShutdownCompactsJournals(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState(dir)
+	alice := newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	for epoch := uint64(2); epoch < 20; epoch++ {
+		incorporate(t, state, epoch, alice.update(epoch, `{"epoch":1}`))
+	}
+	state.Shutdown()
+	info, err := os.Stat(filepath.Join(dir, "profiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := len(journalEntry(insert, crypto.Hash{}, []byte(`{"epoch":1}`))); info.Size() != int64(size) {
+		t.Fatalf("profiles journal has %v bytes after shutdown, expected %v", info.Size(), size)
+	}
+	recovered, err := RecoverState(dir)
+	if err != nil {
+		t.Fatalf("could not recover compacted state: %v", err)
+	}
+	if details, _ := recovered.Profile(alice.token); details != `{"epoch":1}` {
+		t.Fatalf("unexpected profile %v", details)
+	}
+	recovered.Shutdown()
+}
+
+func TestRecordVaultView(t *testing.T) {
+	dir := t.TempDir()
+	vault := NewRecordVault("test", dir)
+	for n := 0; n < 10; n++ {
+		vault.Set(crypto.Hasher([]byte{byte(n)}), []byte{byte(n)})
+	}
+	frozen := vault.Bytes()
+	view := vault.freeze()
+	// changes after the view is frozen are left out of the view, and kept
+	// by compaction from the journal tail
+	vault.Set(crypto.Hasher([]byte{0}), []byte{100})
+	vault.Remove(crypto.Hasher([]byte{1}))
+	vault.Set(crypto.Hasher([]byte{20}), []byte{20})
+	copied := NewRecordVault("copy", "")
+	view.each(func(hash crypto.Hash, value []byte) {
+		copied.Set(hash, value)
+	})
+	if !bytes.Equal(copied.Bytes(), frozen) || copied.Digest() != view.digest {
+		t.Fatal("view does not match the frozen vault")
+	}
+	if err := vault.compactView(view); err != nil {
+		t.Fatal(err)
+	}
+	view.release()
+	vault.Set(crypto.Hasher([]byte{21}), []byte{21})
+	current, digest := vault.Bytes(), vault.Digest()
+	vault.Close()
+	reopened := OpenRecordVaultFromFile("test", dir)
+	if reopened == nil || !bytes.Equal(reopened.Bytes(), current) || reopened.Digest() != digest {
+		t.Fatal("compacted journal does not match the vault")
+	}
+	reopened.Close()
+}
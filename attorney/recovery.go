@@ -0,0 +1,179 @@
+package attorney
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+const (
+	stateMetaFile    = "state.meta"
+	stateMetaVersion = 1
+)
+
+// ErrTornWrite is returned by Recover when the state files on disk do not
+// correspond to the persisted state metadata, typically because the node
+// stopped in the middle of an Incorporate.
+var ErrTornWrite = errors.New("torn write on state files")
+
+// stateMeta is persisted next to the vault files of a file-backed state. It
+// is rewritten (atomically) before and after every Incorporate. The digests
+// of each vault are cheap to keep up to date and are always present. The full
+// state checksum is only computed on a clean shutdown (or after a sync) and
+// is zero otherwise.
+type stateMeta struct {
+	Dirty    bool
+	Epoch    uint64
+	Digests  []crypto.Hash
+	Checksum crypto.Hash
+}
+
+func (m *stateMeta) Serialize() []byte {
+	bytes := []byte{stateMetaVersion}
+	util.PutBool(m.Dirty, &bytes)
+	util.PutUint64(m.Epoch, &bytes)
+	util.PutHashArray(m.Digests, &bytes)
+	util.PutHash(m.Checksum, &bytes)
+	return bytes
+}
+
+func parseStateMeta(data []byte) *stateMeta {
+	if len(data) < 10 || data[0] != stateMetaVersion {
+		return nil
+	}
+	meta := stateMeta{Dirty: data[1] != 0}
+	position := 2
+	meta.Epoch, position = util.ParseUint64(data, position)
+	meta.Digests, position = util.ParseHashArray(data, position)
+	meta.Checksum, position = util.ParseHash(data, position)
+	if position != len(data) {
+		return nil
+	}
+	return &meta
+}
+
+func (s *State) digests() []crypto.Hash {
+	digests := make([]crypto.Hash, 0)
+	for _, vault := range s.hashVaults() {
+		digests = append(digests, vault.Digest())
+	}
+	for _, vault := range s.recordVaults() {
+		digests = append(digests, vault.Digest())
+	}
+	return digests
+}
+
+// persistMeta writes the state metadata to a temporary file and renames it
+// over the previous one, so that a crash leaves either the old or the new
+// metadata on disk.
+func (s *State) persistMeta(dirty bool, checksum crypto.Hash) {
+	meta := stateMeta{
+		Dirty:    dirty,
+		Epoch:    s.Epoch,
+		Digests:  s.digests(),
+		Checksum: checksum,
+	}
+	path := filepath.Join(s.dataPath, stateMetaFile)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		slog.Error("State.persistMeta: could not create file", "err", err)
+		return
+	}
+	if _, err := file.Write(meta.Serialize()); err != nil {
+		slog.Error("State.persistMeta: could not write file", "err", err)
+		file.Close()
+		return
+	}
+	if err := file.Sync(); err != nil {
+		slog.Error("State.persistMeta: could not sync file", "err", err)
+	}
+	file.Close()
+	if err := os.Rename(path+".tmp", path); err != nil {
+		slog.Error("State.persistMeta: could not rename file", "err", err)
+	}
+}
+
+// compactJournals rewrites the journals of the record vaults of a file-backed
+// state. It is called on shutdown and whenever a snapshot is taken.
+func (s *State) compactJournals() {
+	for _, vault := range s.recordVaults() {
+		if err := vault.compact(); err != nil {
+			slog.Error("State.compactJournals: could not compact journal", "name", vault.name, "err", err)
+		}
+	}
+}
+
+// Discard closes the state vaults without persisting them. A file-backed
+// state removes its metadata, so that a state rejected after it was written
+// is never recovered.
+func (s *State) Discard() {
+	if s.dataPath != "" {
+		if err := os.Remove(filepath.Join(s.dataPath, stateMetaFile)); err != nil && !os.IsNotExist(err) {
+			slog.Error("State.Discard: could not remove metadata", "err", err)
+		}
+	}
+	s.close()
+}
+
+// HasPersistedState returns true if dataPath contains the metadata of a
+// file-backed state.
+func HasPersistedState(dataPath string) bool {
+	_, err := os.Stat(filepath.Join(dataPath, stateMetaFile))
+	return err == nil
+}
+
+// Recover checks the vaults of a reopened file-backed state against the
+// persisted metadata and resumes the state epoch from it. It returns an error
+// wrapping ErrTornWrite if an Incorporate was interrupted or if any vault
+// content differs from what was persisted.
+func (s *State) Recover() error {
+	if s.dataPath == "" {
+		return errors.New("cannot recover a memory state")
+	}
+	data, err := os.ReadFile(filepath.Join(s.dataPath, stateMetaFile))
+	if err != nil {
+		return fmt.Errorf("could not read state metadata: %w", err)
+	}
+	meta := parseStateMeta(data)
+	if meta == nil {
+		return fmt.Errorf("%w: invalid state metadata", ErrTornWrite)
+	}
+	if meta.Dirty {
+		return fmt.Errorf("%w: incorporate interrupted after epoch %v", ErrTornWrite, meta.Epoch)
+	}
+	digests := s.digests()
+	if len(meta.Digests) != len(digests) {
+		return fmt.Errorf("%w: metadata has %v vaults, state has %v", ErrTornWrite, len(meta.Digests), len(digests))
+	}
+	for n, digest := range digests {
+		if !digest.Equal(meta.Digests[n]) {
+			return fmt.Errorf("%w: vault %v does not match metadata at epoch %v", ErrTornWrite, n, meta.Epoch)
+		}
+	}
+	if !meta.Checksum.Equal(crypto.ZeroValueHash) && !s.Checksum().Equal(meta.Checksum) {
+		return fmt.Errorf("%w: checksum does not match metadata at epoch %v", ErrTornWrite, meta.Epoch)
+	}
+	s.Epoch = meta.Epoch
+	return nil
+}
+
+// RecoverState reopens the file-backed state persisted at dataPath and
+// recovers it. The files are left untouched if recovery fails. The captions
+// of a state written before handles were canonical are migrated.
+func RecoverState(dataPath string) (*State, error) {
+	state := OpenState(dataPath, 0)
+	if state == nil {
+		return nil, fmt.Errorf("%w: could not reopen state vaults at %v", ErrTornWrite, dataPath)
+	}
+	if err := state.Recover(); err != nil {
+		state.close()
+		return nil, err
+	}
+	state.migrateCaptions()
+	return state, nil
+}
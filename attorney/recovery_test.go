@@ -0,0 +1,112 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// shutdownAndRecover shuts state down and recovers it from dir. The recovered
+// state must match the checksum of state.
+func shutdownAndRecover(t *testing.T, state *State, dir string) *State {
+	t.Helper()
+	checksum := state.Checksum()
+	state.Shutdown()
+	recovered, err := RecoverState(dir)
+	if err != nil {
+		t.Fatalf("could not recover state: %v", err)
+	}
+	if recovered.Checksum() != checksum {
+		t.Fatal("recovered state does not match")
+	}
+	return recovered
+}
+
+func TestRecoverAfterShutdown(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState(dir)
+	alice, bob := newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"), bob.join(1, "bob"))
+	checksum := state.Checksum()
+	state.Shutdown()
+	recovered, err := RecoverState(dir)
+	if err != nil {
+		t.Fatalf("could not recover state: %v", err)
+	}
+	if recovered.Epoch != 1 || recovered.Checksum() != checksum {
+		t.Fatalf("recovered state at epoch %v does not match", recovered.Epoch)
+	}
+	if token, ok := recovered.TokenOf("bob"); !ok || token != bob.token {
+		t.Fatal("recovered state lost a member")
+	}
+	recovered.Shutdown()
+}
+
+func TestRecoverAfterRevoke(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState(dir)
+	alice, attorney := newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	incorporate(t, state, 2, alice.grant(2, attorney, Scope{}))
+	// the grant is the first of several items of its bucket, so that the
+	// revoke removes an item that is not last in its bucket
+	grant := attorneyHash(alice.token, attorney.token)
+	neighbours := crowdBucket(state.Attorneys, grant, 8)
+	incorporate(t, state, 3, alice.revoke(3, attorney))
+	checksum := state.Checksum()
+	state.Shutdown()
+	recovered, err := RecoverState(dir)
+	if err != nil {
+		t.Fatalf("could not recover state after revoke: %v", err)
+	}
+	if recovered.Checksum() != checksum {
+		t.Fatal("recovered state does not match")
+	}
+	if recovered.PowerOfAttorney(alice.token, attorney.token) {
+		t.Fatal("revoked power of attorney recovered")
+	}
+	for _, hash := range neighbours {
+		if !recovered.Attorneys.ExistsHash(hash) {
+			t.Fatal("revoke removed another grant")
+		}
+	}
+	recovered.Shutdown()
+}
+
+func TestRecoverWithoutShutdown(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState(dir)
+	alice := newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	// a node that stops between two Incorporate calls is recovered from the
+	// vault digests alone
+	state.close()
+	recovered, err := RecoverState(dir)
+	if err != nil {
+		t.Fatalf("could not recover state: %v", err)
+	}
+	if !recovered.HasMember(alice.token) {
+		t.Fatal("recovered state lost a member")
+	}
+	// a change to the vaults that is not in the metadata is a torn write
+	recovered.Members.InsertHash(crypto.Hasher([]byte("torn")))
+	recovered.close()
+	if _, err := RecoverState(dir); !errors.Is(err, ErrTornWrite) {
+		t.Fatalf("expected torn write, got %v", err)
+	}
+}
+
+func TestDiscardState(t *testing.T) {
+	dir := t.TempDir()
+	source := NewGenesisState("")
+	incorporate(t, source, 1, newTestMember().join(1, "alice"))
+	state, ok := stateFromBytes(dir, source.Serialize())
+	if !ok || !HasPersistedState(dir) {
+		t.Fatal("state from bytes not persisted")
+	}
+	state.Discard()
+	if HasPersistedState(dir) {
+		t.Fatal("discarded state can still be recovered")
+	}
+}
@@ -0,0 +1,110 @@
+package attorney
+
+import (
+	"sort"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// ActionWindow is the number of epochs an action remains valid before and
+// after the epoch it was signed for. It matches the maximum action delay
+// accepted by breeze. Within the window the hash of every accepted action is
+// kept so that the same action cannot be incorporated twice.
+const ActionWindow = 100
+
+// ActionEpoch returns the epoch of an attorney action without parsing it.
+func ActionEpoch(data []byte) uint64 {
+	epoch, _ := util.ParseUint64(data, 2)
+	return epoch
+}
+
+// IsStale checks if an action signed for epoch falls out of the validity
+// window at the current epoch.
+func IsStale(epoch, current uint64) bool {
+	return epoch+ActionWindow < current
+}
+
+// IsFuture checks if an action signed for epoch is not yet within the
+// validity window at the current epoch. Without the bound an action signed
+// far ahead would outlive the window and its hash.
+func IsFuture(epoch, current uint64) bool {
+	return epoch > current+ActionWindow
+}
+
+// IsRecent checks if an action with the given hash was incorporated within
+// the validity window.
+func (s *State) IsRecent(hash crypto.Hash) bool {
+	return s.Recent.Exists(hash)
+}
+
+// recentKey is the key of the bucket of hashes of recent actions signed for
+// epoch. Buckets are kept in Recent alongside the hashes so that they expire
+// together.
+func recentKey(epoch uint64) crypto.Hash {
+	bytes := []byte{}
+	util.PutUint64(epoch, &bytes)
+	return crypto.Hasher(bytes)
+}
+
+// addRecent keeps the hashes of incorporated actions, each with its epoch and
+// in the bucket of its epoch. Buckets are sorted so that they do not depend
+// on the order actions were incorporated. It returns the highest epoch added.
+func (s *State) addRecent(actions map[crypto.Hash]uint64) uint64 {
+	highest := uint64(0)
+	buckets := make(map[uint64][]crypto.Hash)
+	for hash, epoch := range actions {
+		bytes := []byte{}
+		util.PutUint64(epoch, &bytes)
+		s.Recent.Set(hash, bytes)
+		buckets[epoch] = append(buckets[epoch], hash)
+		if epoch > highest {
+			highest = epoch
+		}
+	}
+	for epoch, hashes := range buckets {
+		key := recentKey(epoch)
+		bucket, _ := s.Recent.Get(key)
+		for position := 0; position+crypto.Size <= len(bucket); position += crypto.Size {
+			hashes = append(hashes, crypto.Hash(bucket[position:position+crypto.Size]))
+		}
+		sort.Slice(hashes, func(i, j int) bool {
+			return string(hashes[i][:]) < string(hashes[j][:])
+		})
+		sorted := make([]byte, 0, len(hashes)*crypto.Size)
+		for _, hash := range hashes {
+			sorted = append(sorted, hash[:]...)
+		}
+		s.Recent.Set(key, sorted)
+	}
+	return highest
+}
+
+// purgeRecent forgets the hashes of actions that became stale since the state
+// was at epoch from. Stale actions are rejected by epoch alone. Only the
+// buckets of epochs that expired are touched: those after the ones already
+// expired at from and up to highest, the last epoch an action was kept for.
+func (s *State) purgeRecent(from, highest uint64) {
+	if s.Epoch <= ActionWindow {
+		return
+	}
+	last := s.Epoch - ActionWindow - 1
+	if last > highest {
+		last = highest
+	}
+	first := uint64(0)
+	if from > ActionWindow {
+		first = from - ActionWindow
+	}
+	for epoch := first; epoch <= last; epoch++ {
+		key := recentKey(epoch)
+		bucket, ok := s.Recent.Get(key)
+		if !ok {
+			continue
+		}
+		for position := 0; position+crypto.Size <= len(bucket); position += crypto.Size {
+			s.Recent.Remove(crypto.Hash(bucket[position : position+crypto.Size]))
+		}
+		s.Recent.Remove(key)
+	}
+}
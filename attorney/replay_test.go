@@ -0,0 +1,110 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestActionWindow(t *testing.T) {
+	state := NewGenesisState("")
+	alice := newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	validator := state.Validator()
+	validator.SetEpoch(200)
+	if err := validator.ValidateAction(alice.update(99, `{}`)); !errors.Is(err, ErrStaleEpoch) {
+		t.Fatalf("expected stale epoch, got %v", err)
+	}
+	if err := validator.ValidateAction(alice.update(301, `{}`)); !errors.Is(err, ErrFutureEpoch) {
+		t.Fatalf("expected future epoch, got %v", err)
+	}
+	for _, epoch := range []uint64{100, 200, 300} {
+		if err := validator.ValidateAction(alice.update(epoch, `{}`)); err != nil {
+			t.Fatalf("action signed for %v rejected: %v", epoch, err)
+		}
+	}
+}
+
+func TestActionEpochDoesNotMoveState(t *testing.T) {
+	state := NewGenesisState("")
+	alice, bob := newTestMember(), newTestMember()
+	// an action signed ahead within the window is accepted, but the state
+	// still advances one epoch per block
+	incorporate(t, state, 1, alice.join(1+ActionWindow, "alice"))
+	if state.Epoch != 1 {
+		t.Fatalf("state moved to epoch %v", state.Epoch)
+	}
+	validator := state.Validator()
+	if validator.Epoch() != 2 {
+		t.Fatalf("validator at epoch %v", validator.Epoch())
+	}
+	if err := validator.ValidateAction(bob.join(2+ActionWindow, "bob")); err != nil {
+		t.Fatalf("action within the window rejected: %v", err)
+	}
+	if err := validator.ValidateAction(alice.update(3+ActionWindow, `{}`)); !errors.Is(err, ErrFutureEpoch) {
+		t.Fatalf("expected future epoch, got %v", err)
+	}
+}
+
+func TestValidatorEpochFromBlocks(t *testing.T) {
+	state := NewGenesisState("")
+	alice, bob := newTestMember(), newTestMember()
+	first := state.Validator()
+	if first.Epoch() != 1 {
+		t.Fatalf("first block validated at epoch %v", first.Epoch())
+	}
+	first.ValidateAction(alice.join(1, "alice"))
+	second := state.Validator(first.Mutations())
+	if second.Epoch() != 2 {
+		t.Fatalf("second block validated at epoch %v", second.Epoch())
+	}
+	second.ValidateAction(bob.join(2, "bob"))
+	third := state.Validator(first.Mutations(), second.Mutations())
+	if third.Epoch() != 3 {
+		t.Fatalf("third block validated at epoch %v", third.Epoch())
+	}
+	state.Incorporate(first.Mutations().Merge(second.Mutations()))
+	if state.Epoch != 2 || !state.HasMember(alice.token) || !state.HasMember(bob.token) {
+		t.Fatalf("state at epoch %v after two blocks", state.Epoch)
+	}
+}
+
+func TestBatchActionWindow(t *testing.T) {
+	state := NewGenesisState("")
+	alice := newTestMember()
+	batch := Batch{Epoch: 1, Author: alice.token, Actions: [][]byte{alice.join(2+ActionWindow, "alice")}}
+	batch.Sign(alice.key)
+	if err := state.Validator().ValidateAction(batch.Serialize()); !errors.Is(err, ErrFutureEpoch) {
+		t.Fatalf("expected future epoch, got %v", err)
+	}
+}
+
+func TestRecentExpiry(t *testing.T) {
+	state := NewGenesisState("")
+	alice, bob := newTestMember(), newTestMember()
+	join := alice.join(1, "alice")
+	incorporate(t, state, 1, join)
+	incorporate(t, state, 2, bob.join(2, "bob"))
+	if !state.IsRecent(crypto.Hasher(join)) {
+		t.Fatal("incorporated action not recent")
+	}
+	for epoch := uint64(3); epoch <= 2+ActionWindow; epoch++ {
+		incorporate(t, state, epoch)
+	}
+	if state.IsRecent(crypto.Hasher(join)) || state.Recent.Exists(recentKey(1)) {
+		t.Fatal("stale action kept")
+	}
+	if state.Recent.Len() != 2 {
+		t.Fatalf("expected the hash and bucket of epoch 2 only, got %v records", state.Recent.Len())
+	}
+	// merged blocks that jump past the window purge what they added
+	validator := state.Validator()
+	validator.ValidateAction(alice.update(3+ActionWindow, `{}`))
+	mutations := validator.Mutations()
+	mutations.Epoch = 10 * ActionWindow
+	state.Incorporate(mutations)
+	if state.Recent.Len() != 0 {
+		t.Fatalf("expected no recent actions, got %v records", state.Recent.Len())
+	}
+}
@@ -0,0 +1,99 @@
+package attorney
+
+import (
+	"github.com/freehandle/breeze/crypto"
+)
+
+// IsRetired checks if token was replaced by a key rotation. Retired tokens
+// cannot join the network again nor act as attorneys.
+func (s *State) IsRetired(token crypto.Token) bool {
+	return s.Retired.ExistsHash(crypto.HashToken(token))
+}
+
+// Delegates returns the attorneys to which token has granted a power of
+// attorney.
+func (s *State) Delegates(token crypto.Token) []crypto.Token {
+	data, ok := s.Delegations.Get(crypto.HashToken(token))
+	if !ok {
+		return nil
+	}
+	delegates := make([]crypto.Token, 0, len(data)/crypto.TokenSize)
+	for n := 0; n+crypto.TokenSize <= len(data); n += crypto.TokenSize {
+		var attorney crypto.Token
+		copy(attorney[:], data[n:n+crypto.TokenSize])
+		delegates = append(delegates, attorney)
+	}
+	return delegates
+}
+
+func (s *State) setDelegates(token crypto.Token, delegates []crypto.Token) {
+	hash := crypto.HashToken(token)
+	if len(delegates) == 0 {
+		s.Delegations.Remove(hash)
+		return
+	}
+	data := make([]byte, 0, len(delegates)*crypto.TokenSize)
+	for _, attorney := range delegates {
+		data = append(data, attorney[:]...)
+	}
+	s.Delegations.Set(hash, data)
+}
+
+func (s *State) addDelegate(delegation Delegation) {
+	delegates := s.Delegates(delegation.Author)
+	for _, attorney := range delegates {
+		if attorney.Equal(delegation.Attorney) {
+			return
+		}
+	}
+	s.setDelegates(delegation.Author, append(delegates, delegation.Attorney))
+}
+
+func (s *State) removeDelegate(delegation Delegation) {
+	delegates := s.Delegates(delegation.Author)
+	for n, attorney := range delegates {
+		if attorney.Equal(delegation.Attorney) {
+			s.setDelegates(delegation.Author, append(delegates[:n], delegates[n+1:]...))
+			return
+		}
+	}
+}
+
+// rotate moves membership, handle, profile, guardians and outstanding grants
+// of old to token and retires old. Grants keep the attestation originally
+// given to old. Any pending recovery of old is dropped.
+func (s *State) rotate(old, token crypto.Token) {
+	oldHash := crypto.HashToken(old)
+	newHash := crypto.HashToken(token)
+	s.Members.RemoveHash(oldHash)
+	s.Members.InsertHash(newHash)
+	s.Retired.InsertHash(oldHash)
+	if handle, ok := s.Handles.Get(oldHash); ok {
+		s.Handles.Remove(oldHash)
+		s.Handles.Set(newHash, handle)
+		s.Owners.Set(crypto.Hasher(handle), token[:])
+	}
+	if details, ok := s.Profiles.Get(oldHash); ok {
+		s.Profiles.Remove(oldHash)
+		s.Profiles.Set(newHash, details)
+	}
+	if guardians, ok := s.Guardians.Get(oldHash); ok {
+		s.Guardians.Remove(oldHash)
+		s.Guardians.Set(newHash, guardians)
+	}
+	s.Recoveries.Remove(oldHash)
+	delegates := s.Delegates(old)
+	for _, attorney := range delegates {
+		from := attorneyHash(old, attorney)
+		to := attorneyHash(token, attorney)
+		if s.Attorneys.RemoveHash(from) {
+			s.Attorneys.InsertHash(to)
+		}
+		if record, ok := s.Grants.Get(from); ok {
+			s.Grants.Remove(from)
+			s.Grants.Set(to, record)
+		}
+	}
+	s.setDelegates(old, nil)
+	s.setDelegates(token, delegates)
+}
@@ -0,0 +1,59 @@
+package attorney
+
+import (
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func (m testMember) rotate(epoch uint64, token testMember) []byte {
+	rotate := RotateKey{Epoch: epoch, Author: m.token, Token: token.token}
+	rotate.Sign(m.key)
+	return rotate.Serialize()
+}
+
+func TestRotateKey(t *testing.T) {
+	state := NewGenesisState("")
+	alice, attorney, fresh := newTestMember(), newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	incorporate(t, state, 2, alice.grant(2, attorney, Scope{}))
+	validator := validateBlock(t, state, 3, alice.rotate(3, fresh))
+	if validator.ValidateAction(fresh.join(3, "bob")) == nil {
+		t.Fatal("rotation target joined on the same block")
+	}
+	state.Incorporate(validator.Mutations())
+	if state.HasMember(alice.token) || !state.HasMember(fresh.token) || !state.IsRetired(alice.token) {
+		t.Fatal("membership not rotated")
+	}
+	if token, _ := state.TokenOf("alice"); token != fresh.token {
+		t.Fatal("handle not rotated")
+	}
+	if !state.PowerOfAttorney(fresh.token, attorney.token) || state.PowerOfAttorney(alice.token, attorney.token) {
+		t.Fatal("grant not rotated")
+	}
+	if err := state.Validator().ValidateAction(alice.join(4, "carol")); err == nil {
+		t.Fatal("retired token joined again")
+	}
+}
+
+func TestRotateKeyCrowdedBucket(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState(dir)
+	alice, attorney, fresh := newTestMember(), newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	incorporate(t, state, 2, alice.grant(2, attorney, Scope{}))
+	member := crypto.HashToken(alice.token)
+	grant := attorneyHash(alice.token, attorney.token)
+	members := crowdBucket(state.Members, member, 8)
+	grants := crowdBucket(state.Attorneys, grant, 8)
+	incorporate(t, state, 3, alice.rotate(3, fresh))
+	checkCrowd(t, state.Members, member, members)
+	checkCrowd(t, state.Attorneys, grant, grants)
+	recovered := shutdownAndRecover(t, state, dir)
+	checkCrowd(t, recovered.Members, member, members)
+	checkCrowd(t, recovered.Attorneys, grant, grants)
+	if !recovered.PowerOfAttorney(fresh.token, attorney.token) {
+		t.Fatal("rotated grant lost")
+	}
+	recovered.Shutdown()
+}
@@ -0,0 +1,102 @@
+package attorney
+
+import (
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// Scope restricts what an attorney may sign on behalf of a member. The zero
+// value is an unrestricted and permanent power of attorney.
+type Scope struct {
+	Expiry    uint64   // last epoch the power is valid (zero for no expiry)
+	Kinds     []byte   // action kinds the attorney may sign (empty for all)
+	Protocols []uint32 // downstream protocols allowed for void actions (empty for all)
+}
+
+func (s Scope) IsUnrestricted() bool {
+	return s.Expiry == 0 && len(s.Kinds) == 0 && len(s.Protocols) == 0
+}
+
+// Allows checks if an action of kind (and protocol, for void actions) at
+// epoch falls within the scope.
+func (s Scope) Allows(kind byte, protocol uint32, epoch uint64) bool {
+	if s.Expiry != 0 && epoch > s.Expiry {
+		return false
+	}
+	if len(s.Kinds) > 0 {
+		found := false
+		for _, allowed := range s.Kinds {
+			if allowed == kind {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if kind == VoidType && len(s.Protocols) > 0 {
+		for _, allowed := range s.Protocols {
+			if allowed == protocol {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (s Scope) Serialize() []byte {
+	bytes := []byte{}
+	s.serialize(&bytes)
+	return bytes
+}
+
+func (s Scope) serialize(bytes *[]byte) {
+	util.PutUint64(s.Expiry, bytes)
+	util.PutByteArray(s.Kinds, bytes)
+	util.PutUint16(uint16(len(s.Protocols)), bytes)
+	for _, protocol := range s.Protocols {
+		util.PutUint32(protocol, bytes)
+	}
+}
+
+func ParseScope(data []byte) (Scope, bool) {
+	scope, position := parseScope(data, 0)
+	return scope, position == len(data)
+}
+
+// parseScope returns a position beyond len(data) if data is not a valid scope.
+func parseScope(data []byte, position int) (Scope, int) {
+	scope := Scope{}
+	scope.Expiry, position = util.ParseUint64(data, position)
+	if position+1 >= len(data) {
+		return scope, len(data) + 1
+	}
+	kinds, position := util.ParseByteArray(data, position)
+	for _, kind := range kinds {
+		if kind >= Invalid {
+			return scope, len(data) + 1
+		}
+	}
+	if len(kinds) > 0 {
+		scope.Kinds = append([]byte{}, kinds...)
+	}
+	var count uint16
+	count, position = util.ParseUint16(data, position)
+	if position+4*int(count) > len(data) {
+		return scope, len(data) + 1
+	}
+	for n := 0; n < int(count); n++ {
+		var protocol uint32
+		protocol, position = util.ParseUint32(data, position)
+		scope.Protocols = append(scope.Protocols, protocol)
+	}
+	return scope, position
+}
+
+// attorneyHash is the key of the power of attorney granted by token to
+// attorney on the attorneys and grants vaults.
+func attorneyHash(token, attorney crypto.Token) crypto.Hash {
+	return crypto.Hasher(append(token[:], attorney[:]...))
+}
@@ -0,0 +1,61 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+)
+
+// updateFor signs an update of the profile of author as its attorney.
+func (m testMember) updateFor(epoch uint64, author testMember, details string) []byte {
+	update := UpdateInfo{Epoch: epoch, Author: author.token, Details: details, Signer: m.token}
+	update.Sign(m.key)
+	return update.Serialize()
+}
+
+func TestScopeAllows(t *testing.T) {
+	scope := Scope{Expiry: 10, Kinds: []byte{VoidType}, Protocols: []uint32{7}}
+	if !scope.Allows(VoidType, 7, 10) {
+		t.Fatal("scope rejected an allowed action")
+	}
+	if scope.Allows(VoidType, 7, 11) {
+		t.Fatal("scope allowed an action after expiry")
+	}
+	if scope.Allows(UpdateInfoType, 0, 5) || scope.Allows(VoidType, 8, 5) {
+		t.Fatal("scope allowed an action outside its kinds or protocols")
+	}
+	if !(Scope{}).Allows(UpdateInfoType, 0, 1<<40) {
+		t.Fatal("zero scope is not unrestricted")
+	}
+	parsed, ok := ParseScope(scope.Serialize())
+	if !ok || parsed.Expiry != 10 || len(parsed.Kinds) != 1 || len(parsed.Protocols) != 1 || parsed.Protocols[0] != 7 {
+		t.Fatal("scope does not round trip")
+	}
+}
+
+func TestScopeExpiryAtBlockEpoch(t *testing.T) {
+	state := NewGenesisState("")
+	alice, attorney := newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	incorporate(t, state, 2, alice.grant(2, attorney, Scope{Expiry: 10}))
+	incorporate(t, state, 10, attorney.updateFor(10, alice, `{"n":1}`))
+	// an action signed before the expiry but included after it is rejected
+	validator := state.Validator()
+	validator.SetEpoch(11)
+	if err := validator.ValidateAction(attorney.updateFor(9, alice, `{"n":2}`)); !errors.Is(err, ErrNoPowerOfAttorney) {
+		t.Fatalf("expected expired power of attorney, got %v", err)
+	}
+	if err := validator.ValidateAction(alice.update(11, `{"n":2}`)); err != nil {
+		t.Fatalf("author could not sign after the expiry: %v", err)
+	}
+}
+
+func TestScopeKinds(t *testing.T) {
+	state := NewGenesisState("")
+	alice, attorney := newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"))
+	incorporate(t, state, 2, alice.grant(2, attorney, Scope{Kinds: []byte{VoidType}}))
+	err := state.Validator().ValidateAction(attorney.updateFor(3, alice, `{}`))
+	if !errors.Is(err, ErrNoPowerOfAttorney) {
+		t.Fatalf("expected update outside the scope rejected, got %v", err)
+	}
+}
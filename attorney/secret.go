@@ -0,0 +1,147 @@
+package attorney
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/crypto/dh/curve25519"
+	"github.com/freehandle/breeze/crypto/edwards25519"
+)
+
+// Secrets of a key exchange are sealed with an ephemeral X25519 key agreed
+// with the X25519 form of the ed25519 token of the recipient. The AES-256-GCM
+// key is the hash of the shared secret, the ephemeral key and the recipient
+// key. The sealed secret is the random nonce followed by the ciphertext.
+
+// SealedSecretOverhead is the number of bytes a sealed secret adds to the
+// secret.
+const SealedSecretOverhead = crypto.NonceSize + 16
+
+var (
+	ErrInvalidToken  = errors.New("token is not a valid ed25519 public key")
+	ErrCannotOpen    = errors.New("could not open sealed secret")
+	ErrSecretTooLong = errors.New("secret too long for a key exchange")
+)
+
+// SealSecret encrypts secret for the holder of the private key of recipient.
+// It returns the ephemeral token and the sealed secret to be carried by a key
+// exchange.
+func SealSecret(recipient crypto.Token, secret []byte) (crypto.Token, []byte, error) {
+	if len(secret)+SealedSecretOverhead > MaxSecretSize {
+		return crypto.ZeroToken, nil, ErrSecretTooLong
+	}
+	remote, ok := montgomeryToken(recipient)
+	if !ok {
+		return crypto.ZeroToken, nil, ErrInvalidToken
+	}
+	scalar := make([]byte, 32)
+	if _, err := rand.Read(scalar); err != nil {
+		return crypto.ZeroToken, nil, err
+	}
+	public, err := curve25519.X25519(scalar, curve25519.Basepoint)
+	if err != nil {
+		return crypto.ZeroToken, nil, err
+	}
+	var ephemeral crypto.Token
+	copy(ephemeral[:], public)
+	aead, err := secretCipher(scalar, remote, ephemeral, remote)
+	if err != nil {
+		return crypto.ZeroToken, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return crypto.ZeroToken, nil, err
+	}
+	return ephemeral, aead.Seal(nonce, nonce, secret, nil), nil
+}
+
+// OpenSecret decrypts a secret sealed for the token of key with SealSecret.
+func OpenSecret(key crypto.PrivateKey, ephemeral crypto.Token, sealed []byte) ([]byte, error) {
+	if len(sealed) < SealedSecretOverhead {
+		return nil, ErrCannotOpen
+	}
+	local, ok := montgomeryToken(key.PublicKey())
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	aead, err := secretCipher(montgomeryScalar(key), ephemeral, ephemeral, local)
+	if err != nil {
+		return nil, ErrCannotOpen
+	}
+	nonce := sealed[:crypto.NonceSize]
+	secret, err := aead.Open(nil, nonce, sealed[crypto.NonceSize:], nil)
+	if err != nil {
+		return nil, ErrCannotOpen
+	}
+	return secret, nil
+}
+
+// Seal encrypts secret for To and sets Ephemeral and Secret accordingly.
+func (k *KeyExchange) Seal(secret []byte) error {
+	ephemeral, sealed, err := SealSecret(k.To, secret)
+	if err != nil {
+		return err
+	}
+	k.Ephemeral = ephemeral
+	k.Secret = sealed
+	return nil
+}
+
+// Open decrypts the secret of the key exchange with the private key of To.
+func (k *KeyExchange) Open(key crypto.PrivateKey) ([]byte, error) {
+	return OpenSecret(key, k.Ephemeral, k.Secret)
+}
+
+// secretCipher derives the cipher of a sealed secret from the X25519 shared
+// secret of the ephemeral key and the recipient key, where scalar is the
+// private part of one side and remote the public part of the other side.
+func secretCipher(scalar []byte, remote, ephemeral, recipient crypto.Token) (cipher.AEAD, error) {
+	shared, err := curve25519.X25519(scalar, remote[:])
+	if err != nil {
+		return nil, err
+	}
+	material := append(shared, ephemeral[:]...)
+	material = append(material, recipient[:]...)
+	key := crypto.Hasher(material)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// montgomeryScalar returns the X25519 private scalar of an ed25519 key, that
+// is, the clamped first half of the SHA-512 hash of its seed.
+func montgomeryScalar(key crypto.PrivateKey) []byte {
+	digest := sha512.Sum512(key[:32])
+	scalar := digest[:32]
+	scalar[0] &= 248
+	scalar[31] &= 127
+	scalar[31] |= 64
+	return scalar
+}
+
+// montgomeryToken returns the X25519 public key of an ed25519 token, the
+// u-coordinate (1 + y) / (1 - y) of the edwards point. It returns false if
+// token is not a point of the curve.
+func montgomeryToken(token crypto.Token) (crypto.Token, bool) {
+	var point edwards25519.ExtendedGroupElement
+	bytes := [32]byte(token)
+	if !point.FromBytes(&bytes) {
+		return crypto.ZeroToken, false
+	}
+	var y, one, numerator, denominator, u edwards25519.FieldElement
+	edwards25519.FeFromBytes(&y, &bytes)
+	edwards25519.FeOne(&one)
+	edwards25519.FeAdd(&numerator, &one, &y)
+	edwards25519.FeSub(&denominator, &one, &y)
+	edwards25519.FeInvert(&denominator, &denominator)
+	edwards25519.FeMul(&u, &numerator, &denominator)
+	var montgomery [32]byte
+	edwards25519.FeToBytes(&montgomery, &u)
+	return crypto.Token(montgomery), true
+}
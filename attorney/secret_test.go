@@ -0,0 +1,124 @@
+package attorney
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// Known answers computed independently of this package: the ed25519 key is
+// test 1 of RFC 8032, the ephemeral scalar is the private key of Alice in
+// section 6.1 of RFC 7748, and the cipher was produced with the standard
+// library AES-GCM from the hash of the shared secret, the ephemeral key and
+// the recipient key.
+const (
+	katSeed            = "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"
+	katToken           = "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"
+	katScalar          = "307c83864f2833cb427a2ef1c00a013cfdff2768d980c0a3a520f006904de94f"
+	katMontgomery      = "d85e07ec22b0ad881537c2f44d662d1a143cf830c57aca4305d85c7a90f6b62e"
+	katEphemeralScalar = "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a"
+	katEphemeral       = "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"
+	katSecret          = "handles key exchange"
+	katSealed          = "000102030405060708090a0ba2bf0700c1ca5a273aa2f5c3eb769509ce8e2f6990930718ab3608dc2a738b25d485051f"
+)
+
+func decodeHex(t *testing.T, text string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func katKey(t *testing.T) crypto.PrivateKey {
+	t.Helper()
+	key := crypto.PrivateKeyFromSeed([32]byte(decodeHex(t, katSeed)))
+	if token := key.PublicKey(); !bytes.Equal(token[:], decodeHex(t, katToken)) {
+		t.Fatalf("unexpected ed25519 token %x", token)
+	}
+	return key
+}
+
+func TestMontgomeryKnownAnswer(t *testing.T) {
+	key := katKey(t)
+	if scalar := montgomeryScalar(key); !bytes.Equal(scalar, decodeHex(t, katScalar)) {
+		t.Fatalf("unexpected scalar %x", scalar)
+	}
+	montgomery, ok := montgomeryToken(key.PublicKey())
+	if !ok || !bytes.Equal(montgomery[:], decodeHex(t, katMontgomery)) {
+		t.Fatalf("unexpected montgomery token %x", montgomery)
+	}
+	// y = 2 is not the coordinate of a point of the curve
+	if _, ok := montgomeryToken(crypto.Token{2}); ok {
+		t.Fatal("token off the curve converted")
+	}
+}
+
+func TestSecretCipherKnownAnswer(t *testing.T) {
+	key := katKey(t)
+	recipient := crypto.Token(decodeHex(t, katMontgomery))
+	ephemeral := crypto.Token(decodeHex(t, katEphemeral))
+	sealed := decodeHex(t, katSealed)
+	nonce := sealed[:crypto.NonceSize]
+	// the sealing side holds the ephemeral scalar, the opening side the
+	// scalar of the recipient, and both derive the same cipher
+	sealing, err := secretCipher(decodeHex(t, katEphemeralScalar), recipient, ephemeral, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sealing.Seal(nonce, nonce, []byte(katSecret), nil); !bytes.Equal(got, sealed) {
+		t.Fatalf("unexpected sealed secret %x", got)
+	}
+	opening, err := secretCipher(montgomeryScalar(key), ephemeral, ephemeral, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := opening.Open(nil, nonce, sealed[crypto.NonceSize:], nil); err != nil || string(got) != katSecret {
+		t.Fatalf("could not open with the recipient scalar: %v", err)
+	}
+}
+
+func TestOpenSecretKnownAnswer(t *testing.T) {
+	key := katKey(t)
+	ephemeral := crypto.Token(decodeHex(t, katEphemeral))
+	sealed := decodeHex(t, katSealed)
+	secret, err := OpenSecret(key, ephemeral, sealed)
+	if err != nil || string(secret) != katSecret {
+		t.Fatalf("could not open known sealed secret: %v", err)
+	}
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := OpenSecret(key, ephemeral, tampered); !errors.Is(err, ErrCannotOpen) {
+		t.Fatalf("expected tampered secret not to open, got %v", err)
+	}
+	if _, err := OpenSecret(newTestMember().key, ephemeral, sealed); !errors.Is(err, ErrCannotOpen) {
+		t.Fatalf("expected secret not to open with another key, got %v", err)
+	}
+	if _, err := OpenSecret(key, ephemeral, sealed[:SealedSecretOverhead-1]); !errors.Is(err, ErrCannotOpen) {
+		t.Fatalf("expected short secret not to open, got %v", err)
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	recipient := newTestMember()
+	exchange := KeyExchange{To: recipient.token}
+	if err := exchange.Seal([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if len(exchange.Secret) != len("secret")+SealedSecretOverhead {
+		t.Fatalf("sealed secret of %v bytes", len(exchange.Secret))
+	}
+	if secret, err := exchange.Open(recipient.key); err != nil || string(secret) != "secret" {
+		t.Fatalf("could not open sealed secret: %v", err)
+	}
+	if _, _, err := SealSecret(recipient.token, make([]byte, MaxSecretSize)); !errors.Is(err, ErrSecretTooLong) {
+		t.Fatalf("expected secret too long, got %v", err)
+	}
+	if _, _, err := SealSecret(crypto.Token{2}, []byte("secret")); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected invalid token, got %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package attorney
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// SnapshotVersion is the format version of snapshot files written by this
+// release. Snapshots of other versions are rejected.
+const SnapshotVersion = 1
+
+const (
+	snapshotMagic      = "HSNP"
+	snapshotPrefix     = "snapshot-"
+	snapshotExt        = ".hsnp"
+	snapshotHeaderSize = 4 + 4 + 8 + crypto.Size
+)
+
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// SnapshotHeader is the uncompressed header of a snapshot file. It is
+// followed by the gzip compressed serialization of the state.
+type SnapshotHeader struct {
+	Version  uint32
+	Epoch    uint64
+	Checksum crypto.Hash
+}
+
+func (h SnapshotHeader) Serialize() []byte {
+	bytes := []byte(snapshotMagic)
+	util.PutUint32(h.Version, &bytes)
+	util.PutUint64(h.Epoch, &bytes)
+	util.PutHash(h.Checksum, &bytes)
+	return bytes
+}
+
+func ParseSnapshotHeader(data []byte) (SnapshotHeader, bool) {
+	header := SnapshotHeader{}
+	if len(data) < snapshotHeaderSize || string(data[0:4]) != snapshotMagic {
+		return header, false
+	}
+	position := 4
+	header.Version, position = util.ParseUint32(data, position)
+	header.Epoch, position = util.ParseUint64(data, position)
+	header.Checksum, _ = util.ParseHash(data, position)
+	return header, true
+}
+
+// SnapshotFileName returns the name of the snapshot file of epoch. Names sort
+// in epoch order.
+func SnapshotFileName(epoch uint64) string {
+	return fmt.Sprintf("%v%020d%v", snapshotPrefix, epoch, snapshotExt)
+}
+
+// snapshotSchedule writes a snapshot of the state every epochs and keeps the
+// last keep of them in dir.
+type snapshotSchedule struct {
+	dir     string
+	epochs  uint64
+	keep    int
+	last    uint64         // epoch of the last snapshot taken
+	writing atomic.Bool    // a snapshot is being written
+	done    sync.WaitGroup // snapshot being written
+}
+
+// SnapshotEvery makes the state write a snapshot to dir whenever its epoch
+// advances by epochs or more since the last one. Only the last keep snapshots
+// are retained, or all of them if keep is zero.
+func (s *State) SnapshotEvery(dir string, epochs uint64, keep int) {
+	s.waitSnapshot()
+	if epochs == 0 {
+		s.snapshots = nil
+		return
+	}
+	s.snapshots = &snapshotSchedule{dir: dir, epochs: epochs, keep: keep, last: s.Epoch}
+}
+
+// waitSnapshot waits for the scheduled snapshot being written, if any.
+func (s *State) waitSnapshot() {
+	if s.snapshots != nil {
+		s.snapshots.done.Wait()
+	}
+}
+
+// snapshot takes a scheduled snapshot if it is due. The state is frozen, then
+// streamed to the snapshot file and, for a file-backed state, to compacted
+// record journals in the background while blocks keep being incorporated. A
+// snapshot that is due while the previous one is still being written is
+// taken at the next epoch.
+func (s *State) snapshot() {
+	schedule := s.snapshots
+	if schedule == nil || s.Epoch < schedule.last+schedule.epochs || !schedule.writing.CompareAndSwap(false, true) {
+		return
+	}
+	schedule.last = s.Epoch
+	view := s.freeze()
+	schedule.done.Add(1)
+	go func() {
+		defer schedule.done.Done()
+		defer schedule.writing.Store(false)
+		defer view.release()
+		if _, err := writeSnapshot(schedule.dir, view); err != nil {
+			slog.Error("State.snapshot: could not write snapshot", "epoch", view.epoch, "err", err)
+		} else if schedule.keep > 0 {
+			if err := PruneSnapshots(schedule.dir, schedule.keep); err != nil {
+				slog.Error("State.snapshot: could not prune snapshots", "err", err)
+			}
+		}
+		for n, records := range view.records {
+			if err := s.recordVaults()[n].compactView(records); err != nil {
+				slog.Error("State.snapshot: could not compact journal", "name", recordVaultNames[n], "err", err)
+			}
+		}
+	}()
+}
+
+// WriteSnapshot writes a snapshot of the state to dir and returns the path of
+// the file.
+func (s *State) WriteSnapshot(dir string) (string, error) {
+	view := s.freeze()
+	defer view.release()
+	return writeSnapshot(dir, view)
+}
+
+// writeSnapshot streams view to a temporary file renamed once complete, so
+// that a snapshot file is never partially written.
+func writeSnapshot(dir string, view *stateView) (string, error) {
+	header := SnapshotHeader{Version: SnapshotVersion, Epoch: view.epoch, Checksum: view.checksum}
+	path := filepath.Join(dir, SnapshotFileName(header.Epoch))
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.Write(header.Serialize()); err != nil {
+		return "", err
+	}
+	compressed := gzip.NewWriter(file)
+	if _, err := view.WriteTo(compressed); err != nil {
+		return "", err
+	}
+	if err := compressed.Close(); err != nil {
+		return "", err
+	}
+	if err := file.Sync(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// ReadSnapshotHeader reads the header of the snapshot file at path.
+func ReadSnapshotHeader(path string) (SnapshotHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return SnapshotHeader{}, err
+	}
+	defer file.Close()
+	data := make([]byte, snapshotHeaderSize)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return SnapshotHeader{}, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	header, ok := ParseSnapshotHeader(data)
+	if !ok {
+		return header, fmt.Errorf("%w: bad header", ErrInvalidSnapshot)
+	}
+	return header, nil
+}
+
+// OpenSnapshot recreates the state of the snapshot file at path, file-backed
+// at dataPath or in memory if dataPath is empty. The state must match the
+// epoch and checksum of the header. The header only catches corruption, as
+// whoever crafts a snapshot also writes its header: the state must still be
+// checked against a trusted checksum before it is served.
+func OpenSnapshot(path, dataPath string) (*State, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data := make([]byte, snapshotHeaderSize)
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	header, ok := ParseSnapshotHeader(data)
+	if !ok {
+		return nil, fmt.Errorf("%w: bad header", ErrInvalidSnapshot)
+	}
+	if header.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w: version %v, expected %v", ErrInvalidSnapshot, header.Version, SnapshotVersion)
+	}
+	compressed, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	state, err := ReadState(compressed, dataPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	// the stream ends before the gzip trailer, which must still be checked
+	if _, err := io.Copy(io.Discard, compressed); err != nil {
+		state.close()
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if state.Epoch != header.Epoch {
+		state.close()
+		return nil, fmt.Errorf("%w: epoch %v does not match header %v", ErrInvalidSnapshot, state.Epoch, header.Epoch)
+	}
+	if checksum := state.Checksum(); !checksum.Equal(header.Checksum) {
+		state.close()
+		return nil, fmt.Errorf("%w: checksum does not match header", ErrInvalidSnapshot)
+	}
+	return state, nil
+}
+
+// ListSnapshots returns the paths of the snapshot files in dir, oldest first.
+func ListSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, snapshotExt) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// PruneSnapshots removes all but the last keep snapshot files in dir.
+func PruneSnapshots(dir string, keep int) error {
+	paths, err := ListSnapshots(dir)
+	if err != nil {
+		return err
+	}
+	for len(paths) > keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
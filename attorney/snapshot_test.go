@@ -0,0 +1,93 @@
+package attorney
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState("")
+	for epoch := uint64(1); epoch <= 4; epoch++ {
+		incorporate(t, state, epoch, newTestMember().join(epoch, fmt.Sprintf("user%d", epoch)))
+		if _, err := state.WriteSnapshot(dir); err != nil {
+			t.Fatalf("could not write snapshot: %v", err)
+		}
+	}
+	if err := PruneSnapshots(dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := ListSnapshots(dir)
+	if err != nil || len(paths) != 2 {
+		t.Fatalf("expected two snapshots kept, got %v: %v", paths, err)
+	}
+	header, err := ReadSnapshotHeader(paths[1])
+	if err != nil || header.Epoch != 4 || header.Checksum != state.Checksum() || header.Version != SnapshotVersion {
+		t.Fatalf("unexpected header %+v: %v", header, err)
+	}
+	booted, err := OpenSnapshot(paths[1], t.TempDir())
+	if err != nil {
+		t.Fatalf("could not open snapshot: %v", err)
+	}
+	if booted.Epoch != 4 || booted.Checksum() != state.Checksum() {
+		t.Fatal("booted state does not match")
+	}
+	booted.Shutdown()
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState("")
+	incorporate(t, state, 1, newTestMember().join(1, "alice"))
+	path, err := state.WriteSnapshot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-10] ^= 1
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenSnapshot(path, ""); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Fatalf("expected invalid snapshot, got %v", err)
+	}
+}
+
+func TestScheduledSnapshot(t *testing.T) {
+	dir, snapshots := t.TempDir(), t.TempDir()
+	state := NewGenesisState(dir)
+	state.SnapshotEvery(snapshots, 2, 2)
+	checksums := make(map[uint64]crypto.Hash)
+	for epoch := uint64(1); epoch <= 9; epoch++ {
+		incorporate(t, state, epoch, newTestMember().join(epoch, fmt.Sprintf("user%d", epoch)))
+		checksums[epoch] = state.Checksum()
+	}
+	checksum := state.Checksum()
+	state.Shutdown()
+	paths, err := ListSnapshots(snapshots)
+	if err != nil || len(paths) == 0 || len(paths) > 2 {
+		t.Fatalf("expected at most two snapshots kept, got %v: %v", paths, err)
+	}
+	for _, path := range paths {
+		booted, err := OpenSnapshot(path, "")
+		if err != nil {
+			t.Fatalf("could not open snapshot: %v", err)
+		}
+		if booted.Checksum() != checksums[booted.Epoch] {
+			t.Fatalf("snapshot of epoch %v does not match the state at that epoch", booted.Epoch)
+		}
+		booted.Shutdown()
+	}
+	recovered, err := RecoverState(dir)
+	if err != nil || recovered.Epoch != 9 || recovered.Checksum() != checksum {
+		t.Fatalf("could not recover state with compacted journals: %v", err)
+	}
+	recovered.Shutdown()
+}
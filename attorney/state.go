@@ -1,84 +1,290 @@
 package attorney
 
 import (
+	"bytes"
+	"log/slog"
+
 	"github.com/freehandle/breeze/crypto"
 	"github.com/freehandle/breeze/middleware/social"
-	"github.com/freehandle/breeze/util"
 )
 
 type State struct {
-	Members   *hashVault
-	Captions  *hashVault
-	Attorneys *hashVault
+	Epoch       uint64 // epoch of the last incorporated block, the next one is Epoch+1
+	Members     *hashVault
+	Captions    *hashVault
+	Attorneys   *hashVault
+	Retired     *hashVault   // hash of tokens replaced by a key rotation
+	Skeletons   *hashVault   // hash of confusable skeleton of taken handles
+	Owners      *recordVault // hash of handle -> owner token
+	Handles     *recordVault // hash of token -> handle
+	Profiles    *recordVault // hash of token -> latest details
+	Grants      *recordVault // attorney hash -> scope and attestation
+	Delegations *recordVault // hash of token -> attorneys granted by token
+	Guardians   *recordVault // hash of token -> guardians of token
+	Recoveries  *recordVault // hash of token -> pending recovery of token
+	Tombstones  *recordVault // hash of handle -> tombstone of released handle
+	Config      *recordVault // protocol parameters fixed at genesis
+	Reserved    *recordVault // skeleton hash -> authority and reserved handle
+	Recent      *recordVault // hash of actions within the validity window -> epoch
+	Collectives *recordVault // hash of token -> collective controlling token
+	Joins       *recordVault // hash of epoch -> epoch and number of members joined at epoch
+	Seeds       *recordVault // work seed -> epoch of the action that produced it
+	dataPath    string       // empty for memory state
+	undo        undoJournal  // inverse of the changes of recent Incorporate calls
+	snapshots   *snapshotSchedule
+}
+
+// Names of the state vaults, in the order they are serialized, checksummed
+// and persisted.
+var (
+	hashVaultNames   = []string{"members", "captions", "attorneys", "retired", "skeletons"}
+	recordVaultNames = []string{"owners", "handles", "profiles", "grants", "delegations", "guardians", "recoveries", "tombstones", "config", "reserved", "recent", "collectives", "joins", "seeds"}
+)
+
+func stateFromVaults(hashes []*hashVault, records []*recordVault, dataPath string) *State {
+	state := &State{
+		Members:     hashes[0],
+		Captions:    hashes[1],
+		Attorneys:   hashes[2],
+		Retired:     hashes[3],
+		Skeletons:   hashes[4],
+		Owners:      records[0],
+		Handles:     records[1],
+		Profiles:    records[2],
+		Grants:      records[3],
+		Delegations: records[4],
+		Guardians:   records[5],
+		Recoveries:  records[6],
+		Tombstones:  records[7],
+		Config:      records[8],
+		Reserved:    records[9],
+		Recent:      records[10],
+		Collectives: records[11],
+		Joins:       records[12],
+		Seeds:       records[13],
+		dataPath:    dataPath,
+	}
+	state.attachUndo()
+	return state
 }
 
+func (s *State) hashVaults() []*hashVault {
+	return []*hashVault{s.Members, s.Captions, s.Attorneys, s.Retired, s.Skeletons}
+}
+
+func (s *State) recordVaults() []*recordVault {
+	return []*recordVault{s.Owners, s.Handles, s.Profiles, s.Grants, s.Delegations, s.Guardians, s.Recoveries, s.Tombstones, s.Config, s.Reserved, s.Recent, s.Collectives, s.Joins, s.Seeds}
+}
+
+// OpenState reopens the vaults of a file-backed state persisted at dataPath.
+// The state must be checked with Recover before use.
 func OpenState(dataPath string, epoch uint64) *State {
-	memebers := NewHashVault("members", epoch, 8, dataPath)
-	captions := NewHashVault("captions", epoch, 8, dataPath)
-	attorneys := NewHashVault("attorneys", epoch, 8, dataPath)
-	if memebers == nil || captions == nil || attorneys == nil {
-		return nil
+	ok := true
+	hashes := make([]*hashVault, len(hashVaultNames))
+	for n, name := range hashVaultNames {
+		hashes[n] = OpenHashVaultFromFile(name, epoch, DefaultBitsForBucket, dataPath)
+		ok = ok && hashes[n] != nil
 	}
-	return &State{
-		Members:   memebers,
-		Captions:  captions,
-		Attorneys: attorneys,
+	records := make([]*recordVault, len(recordVaultNames))
+	for n, name := range recordVaultNames {
+		records[n] = OpenRecordVaultFromFile(name, dataPath)
+		ok = ok && records[n] != nil
 	}
+	state := stateFromVaults(hashes, records, dataPath)
+	state.Epoch = epoch
+	if !ok {
+		state.close()
+		return nil
+	}
+	return state
 }
 
 func NewGenesisState(dataPath string) *State {
-	state := State{
-		Members:   NewHashVault("members", 0, 8, dataPath),
-		Captions:  NewHashVault("captions", 0, 8, dataPath),
-		Attorneys: NewHashVault("poa", 0, 8, dataPath),
+	return NewGenesisStateWithParameters(dataPath, DefaultParameters)
+}
+
+// NewGenesisStateWithParameters creates an empty state for a new network
+// ruled by params.
+func NewGenesisStateWithParameters(dataPath string, params Parameters) *State {
+	return NewSizedGenesisState(dataPath, params, nil)
+}
+
+// NewSizedGenesisState creates an empty state for a new network ruled by
+// params, with hash vaults sized for the expected items.
+func NewSizedGenesisState(dataPath string, params Parameters, items VaultItems) *State {
+	hashes := make([]*hashVault, len(hashVaultNames))
+	for n, name := range hashVaultNames {
+		hashes[n] = NewHashVault(name, 0, items.bitsForBucket(name), dataPath)
+	}
+	records := make([]*recordVault, len(recordVaultNames))
+	for n, name := range recordVaultNames {
+		records[n] = NewRecordVault(name, dataPath)
 	}
-	return &state
+	state := stateFromVaults(hashes, records, dataPath)
+	state.setParameters(params)
+	state.setParameter(captionFormatKey, canonicalCaptions)
+	if dataPath != "" {
+		state.persistMeta(false, crypto.ZeroValueHash)
+	}
+	return state
 }
 
+// StateFromBytes recreates a memory state from its serialization. Returns nil
+// if data is not a valid state serialization.
 func StateFromBytes(data []byte) *State {
-	return nil
+	state, ok := stateFromBytes("", data)
+	if !ok {
+		return nil
+	}
+	return state
 }
 
+// Validator returns a validator on top of the state and pending mutations,
+// one per block not yet incorporated. The validator takes the epoch of the
+// block right after those, the checkpoint breeze validates blocks against.
 func (s *State) Validator(mutations ...*Mutations) *MutatingState {
 	if len(mutations) == 0 {
 		return &MutatingState{
 			state:     s,
 			mutations: NewMutations(),
+			epoch:     s.Epoch + 1,
 		}
 	}
-	if len(mutations) > 1 {
-		mutations[0].Merge(mutations[1:]...)
-	}
+	merged := s.resolve(mutations[0].Merge(mutations[1:]...))
 	return &MutatingState{
 		state:     s,
-		mutations: mutations[0],
+		mutations: merged,
+		epoch:     s.epochAfter(merged) + 1,
+		base:      len(merged.Log),
+	}
+}
+
+// epochAfter returns the epoch of the state once mutations are incorporated.
+// Each block of the mutations advances the state by one epoch.
+func (s *State) epochAfter(mutations *Mutations) uint64 {
+	epoch := s.Epoch + mutations.Blocks
+	if mutations.Epoch > epoch {
+		return mutations.Epoch
+	}
+	return epoch
+}
+
+// resolve validates the log of merged mutations on top of s, each action at
+// the epoch it was first accepted. Mutations of a single validator are
+// returned as they are.
+func (s *State) resolve(mutations *Mutations) *Mutations {
+	if !mutations.merged {
+		return mutations
+	}
+	resolved := NewMutations()
+	resolved.Epoch = mutations.Epoch
+	resolved.Blocks = mutations.Blocks
+	resolved.Conflicts = append(resolved.Conflicts, mutations.Conflicts...)
+	validator := &MutatingState{state: s, mutations: resolved}
+	for _, entry := range mutations.Log {
+		if err := validator.revalidate(entry); err != nil {
+			slog.Warn("State.resolve: conflicting action left out of merged mutations", "hash", entry.Hashes[0], "reason", err)
+			resolved.Conflicts = append(resolved.Conflicts, Conflict{Hash: entry.Hashes[0], Reason: err})
+		}
 	}
+	return resolved
 }
 
 func (s *State) Incorporate(mutations *Mutations) {
 	if mutations == nil {
 		return
 	}
+	mutations = s.resolve(mutations)
+	if s.dataPath != "" {
+		s.persistMeta(true, crypto.ZeroValueHash)
+	}
+	s.undo.begin(s.Epoch)
 	for hash := range mutations.GrantPower {
 		s.Attorneys.InsertHash(hash)
+		s.Grants.Set(hash, grantRecord(mutations.GrantScope[hash], mutations.GrantFingerprint[hash]))
+		s.addDelegate(mutations.Delegations[hash])
 	}
 	for hash := range mutations.RevokePower {
 		s.Attorneys.RemoveHash(hash)
+		s.Grants.Remove(hash)
+		s.removeDelegate(mutations.Delegations[hash])
+	}
+	// releases go before new members so that a handle released and claimed
+	// back within the same mutations ends up claimed
+	for hash, tombstone := range mutations.Releases {
+		s.release(hash, tombstone)
 	}
 	for hash := range mutations.NewMembers {
 		s.Members.InsertHash(hash)
 	}
 	for hash := range mutations.NewCaption {
-		s.Captions.ExistsHash(hash)
+		s.Captions.InsertHash(hash)
+	}
+	for token, handle := range mutations.NewHandles {
+		s.Owners.Set(crypto.Hasher([]byte(handle)), token[:])
+		s.Handles.Set(crypto.HashToken(token), []byte(handle))
+		skeleton := crypto.Hasher([]byte(HandleSkeleton(handle)))
+		s.Skeletons.InsertHash(skeleton)
+		s.Tombstones.Remove(skeleton)
+	}
+	for token, collective := range mutations.NewCollectives {
+		s.Collectives.Set(crypto.HashToken(token), collective.Serialize())
+	}
+	for token, details := range mutations.NewProfiles {
+		s.Profiles.Set(crypto.HashToken(token), []byte(details))
 	}
+	for token, guardians := range mutations.NewGuardians {
+		s.Guardians.Set(crypto.HashToken(token), guardians.Serialize())
+	}
+	for token := range mutations.CancelRecoveries {
+		s.Recoveries.Remove(crypto.HashToken(token))
+	}
+	for token, recovery := range mutations.NewRecoveries {
+		s.Recoveries.Set(crypto.HashToken(token), recovery.Serialize())
+	}
+	// rotations go last so that everything the retired token did within the
+	// same mutations is moved to the new token
+	for old, token := range mutations.RotateKeys {
+		s.rotate(old, token)
+	}
+	for from, token := range mutations.Transfers {
+		s.transfer(from, token)
+	}
+	highest := s.addRecent(mutations.Actions)
+	for epoch, count := range mutations.Joins {
+		s.addJoins(epoch, count)
+	}
+	s.addSeeds(mutations.Log)
+	from := s.Epoch
+	s.Epoch = s.epochAfter(mutations)
+	if highest < from+ActionWindow {
+		highest = from + ActionWindow
+	}
+	s.purgeRecent(from, highest)
+	s.purgeJoins()
+	s.purgeSeeds()
+	s.undo.commit(s.Epoch)
+	if s.dataPath != "" {
+		s.persistMeta(false, crypto.ZeroValueHash)
+	}
+	s.snapshot()
 }
 
+// Checksum returns a commitment to the entire state. Each vault contributes
+// the digest of its content, kept up to date on every mutation, so the
+// checksum costs no traversal of the vaults and depends neither on the order
+// in which mutations were incorporated nor on the vaults being memory or file
+// backed.
 func (s *State) Checksum() crypto.Hash {
-	return crypto.ZeroHash
+	return checksumOf(s.digests())
 }
 
-func (s *State) Recover() error {
-	return nil
+func checksumOf(digests []crypto.Hash) crypto.Hash {
+	bytes := []byte{}
+	for _, digest := range digests {
+		bytes = append(bytes, digest[:]...)
+	}
+	return crypto.Hasher(bytes)
 }
 
 func (s *State) PowerOfAttorney(token, attorney crypto.Token) bool {
@@ -90,31 +296,149 @@ func (s *State) PowerOfAttorney(token, attorney crypto.Token) bool {
 	return s.Attorneys.ExistsHash(hash)
 }
 
+// ScopeOf returns the scope of the power of attorney granted by token to
+// attorney, and false if there is no such grant.
+func (s *State) ScopeOf(token, attorney crypto.Token) (Scope, bool) {
+	hash := attorneyHash(token, attorney)
+	if !s.Attorneys.ExistsHash(hash) {
+		return Scope{}, false
+	}
+	return s.scope(hash), true
+}
+
+// FingerprintOf returns the attestation by attorney of the power of attorney
+// granted by token. Grants incorporated before attestations were required
+// have an empty fingerprint.
+func (s *State) FingerprintOf(token, attorney crypto.Token) ([]byte, bool) {
+	hash := attorneyHash(token, attorney)
+	if !s.Attorneys.ExistsHash(hash) {
+		return nil, false
+	}
+	data, ok := s.Grants.Get(hash)
+	if !ok {
+		return nil, true
+	}
+	_, fingerprint, _ := parseGrantRecord(data)
+	return fingerprint, true
+}
+
+func (s *State) scope(hash crypto.Hash) Scope {
+	data, ok := s.Grants.Get(hash)
+	if !ok {
+		return Scope{}
+	}
+	scope, _, _ := parseGrantRecord(data)
+	return scope
+}
+
+// PowerOfAttorneyFor checks if attorney may sign an action of kind (and
+// protocol, for void actions) at epoch on behalf of token.
+func (s *State) PowerOfAttorneyFor(token, attorney crypto.Token, kind byte, protocol uint32, epoch uint64) bool {
+	if token.Equal(attorney) {
+		return true
+	}
+	if s.IsRetired(attorney) {
+		return false
+	}
+	scope, ok := s.ScopeOf(token, attorney)
+	return ok && scope.Allows(kind, protocol, epoch)
+}
+
 func (s *State) HasMember(token crypto.Token) bool {
 	hash := crypto.HashToken(token)
 	return s.Members.ExistsHash(hash)
 }
 
+// HasHandle checks if handle, or a handle confusable with it, is taken.
+// Handles are compared in canonical form. A handle that is not valid is never
+// taken.
 func (s *State) HasHandle(handle string) bool {
-	hash := crypto.Hasher([]byte(handle))
-	return s.Captions.ExistsHash(hash)
+	caption, ok := captionHash(handle)
+	if !ok {
+		return false
+	}
+	if s.Captions.ExistsHash(caption) {
+		return true
+	}
+	skeleton, _ := skeletonHash(handle)
+	return s.Skeletons.ExistsHash(skeleton)
+}
+
+// TokenOf returns the token of the member owning handle.
+func (s *State) TokenOf(handle string) (crypto.Token, bool) {
+	caption, ok := captionHash(handle)
+	if !ok {
+		return crypto.ZeroToken, false
+	}
+	data, ok := s.Owners.Get(caption)
+	if !ok {
+		return crypto.ZeroToken, false
+	}
+	var token crypto.Token
+	copy(token[:], data)
+	return token, true
+}
+
+// HandleOf returns the handle owned by token.
+func (s *State) HandleOf(token crypto.Token) (string, bool) {
+	data, ok := s.Handles.Get(crypto.HashToken(token))
+	if !ok {
+		return "", false
+	}
+	return string(data), true
+}
+
+// Profile returns the latest details published by the member token, either on
+// JoinNetwork or on a subsequent UpdateInfo.
+func (s *State) Profile(token crypto.Token) (string, bool) {
+	data, ok := s.Profiles.Get(crypto.HashToken(token))
+	if !ok {
+		return "", false
+	}
+	return string(data), true
 }
 
+// Shutdown closes the state vaults. A file-backed state compacts its record
+// journals and persists its epoch and checksum before closing so that it can
+// be recovered later.
 func (s *State) Shutdown() {
-	s.Members.Close()
-	s.Attorneys.Close()
-	s.Captions.Close()
+	s.waitSnapshot()
+	if s.dataPath != "" {
+		s.compactJournals()
+		s.persistMeta(false, s.Checksum())
+	}
+	s.close()
+}
+
+func (s *State) close() {
+	s.waitSnapshot()
+	for _, vault := range s.hashVaults() {
+		if vault != nil {
+			vault.Close()
+		}
+	}
+	for _, vault := range s.recordVaults() {
+		if vault != nil {
+			vault.Close()
+		}
+	}
 }
 
 // Clone creates a copy of the state by cloning the underlying papirus hashtable
 // stores.
 func (s *State) Clone() chan social.Stateful[*Mutations, *MutatingState] {
 	cloned := make(chan social.Stateful[*Mutations, *MutatingState], 2)
-	cloned <- &State{
-		Members:   s.Members.Clone(),
-		Captions:  s.Captions.Clone(),
-		Attorneys: s.Attorneys.Clone(),
+	hashes := make([]*hashVault, 0)
+	for _, vault := range s.hashVaults() {
+		hashes = append(hashes, vault.Clone())
 	}
+	records := make([]*recordVault, 0)
+	for _, vault := range s.recordVaults() {
+		records = append(records, vault.Clone())
+	}
+	clone := stateFromVaults(hashes, records, "")
+	clone.Epoch = s.Epoch
+	cloned <- clone
 	return cloned
 }
 
@@ -122,33 +446,38 @@ func (s *State) Clone() chan social.Stateful[*Mutations, *MutatingState] {
 // a channel to a state object.
 func (s *State) CloneAsync() chan *State {
 	output := make(chan *State)
-	members := s.Members.hs.CloneAsync()
-	captions := s.Captions.hs.CloneAsync()
-	attorneys := s.Attorneys.hs.CloneAsync()
-	clone := &State{
-		Members:   &hashVault{},
-		Captions:  &hashVault{},
-		Attorneys: &hashVault{},
-	}
+	view := s.freeze()
 	go func() {
-		count := 0
-		for {
-			select {
-			case clone.Members.hs = <-members:
-				count += 1
-			case clone.Attorneys.hs = <-attorneys:
-				count += 1
-			case clone.Captions.hs = <-captions:
-			}
-			if count == 3 {
-				output <- clone
-				return
-			}
-		}
+		clone := view.memoryState()
+		view.release()
+		output <- clone
 	}()
 	return output
 }
 
+// memoryState copies the view into a memory state.
+func (v *stateView) memoryState() *State {
+	hashes := make([]*hashVault, 0)
+	for n, view := range v.hashes {
+		vault := NewHashVault(hashVaultNames[n], v.epoch, view.bits, "")
+		view.each(func(hash crypto.Hash) {
+			vault.InsertHash(hash)
+		})
+		hashes = append(hashes, vault)
+	}
+	records := make([]*recordVault, 0)
+	for n, view := range v.records {
+		vault := NewRecordVault(recordVaultNames[n], "")
+		view.each(func(hash crypto.Hash, value []byte) {
+			vault.Set(hash, value)
+		})
+		records = append(records, vault)
+	}
+	clone := stateFromVaults(hashes, records, "")
+	clone.Epoch = v.epoch
+	return clone
+}
+
 // ChecksumHash returns the hash of the checksum of the state.
 func (s *State) ChecksumPoint() crypto.Hash {
 	membersHash := s.Members.hs.Hash(crypto.Hasher)
@@ -157,39 +486,37 @@ func (s *State) ChecksumPoint() crypto.Hash {
 	return crypto.Hasher(append(membersHash[:], append(captionsHash[:], attorneysHash[:]...)...))
 }
 
+// Serialize returns the state stream written by WriteTo. The checksum of the
+// stream header lets the receiving end detect a corrupted state. It is
+// computed by the sender, so it is no defense against a peer that crafts a
+// state: only a checksum obtained from trusted peers is.
 func (s *State) Serialize() []byte {
-	members := s.Members.Bytes()
-	captions := s.Captions.Bytes()
-	attorneys := s.Attorneys.Bytes()
-	bytes := []byte{}
-	util.PutUint64(uint64(len(members)), &bytes)
-	bytes = append(bytes, members...)
-	util.PutUint64(uint64(len(captions)), &bytes)
-	bytes = append(bytes, captions...)
-	util.PutUint64(uint64(len(attorneys)), &bytes)
-	bytes = append(bytes, attorneys...)
-	return bytes
+	var buffer bytes.Buffer
+	s.WriteTo(&buffer)
+	return buffer.Bytes()
 }
 
+// NewStateFromBytes returns a function that recreates a state from its
+// serialization. States whose recomputed checksum differs from the serialized
+// one are rejected. This only catches corruption: the caller must still
+// compare Checksum with the checkpoints of trusted peers. Large states are
+// better moved with WriteTo and ReadState directly, without holding the
+// whole serialization in memory.
 func NewStateFromBytes(datapath string) social.StateFromBytes[*Mutations, *MutatingState] {
 	return func(data []byte) (social.Stateful[*Mutations, *MutatingState], bool) {
-		membersSize, _ := util.ParseUint64(data, 0)
-		members := data[8 : 8+membersSize]
-		captionsSize, _ := util.ParseUint64(data, int(8+membersSize))
-		captions := data[16+membersSize : 16+membersSize+captionsSize]
-		attorneys := data[24+membersSize+captionsSize:]
-		if datapath == "" {
-			return &State{
-				Members:   NewMemoryHashVaultFromBytes("members", members),
-				Captions:  NewMemoryHashVaultFromBytes("captions", captions),
-				Attorneys: NewMemoryHashVaultFromBytes("attorneys", attorneys),
-			}, true
-		} else {
-			return &State{
-				Members:   NewFileHashVaultFromBytes(datapath, "members", members),
-				Captions:  NewFileHashVaultFromBytes(datapath, "captions", captions),
-				Attorneys: NewFileHashVaultFromBytes(datapath, "attorneys", attorneys),
-			}, true
+		state, ok := stateFromBytes(datapath, data)
+		if !ok {
+			return nil, false
 		}
+		return state, true
+	}
+}
+
+func stateFromBytes(datapath string, data []byte) (*State, bool) {
+	state, err := ReadState(bytes.NewReader(data), datapath, nil)
+	if err != nil {
+		slog.Error("NewStateFromBytes: invalid state", "err", err)
+		return nil, false
 	}
+	return state, true
 }
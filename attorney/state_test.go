@@ -0,0 +1,161 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// testMember holds the keys of a member in tests.
+type testMember struct {
+	token crypto.Token
+	key   crypto.PrivateKey
+}
+
+func newTestMember() testMember {
+	token, key := crypto.RandomAsymetricKey()
+	return testMember{token: token, key: key}
+}
+
+func (m testMember) join(epoch uint64, handle string) []byte {
+	join := JoinNetwork{Epoch: epoch, Author: m.token, Handle: handle, Details: `{}`}
+	join.Sign(m.key)
+	return join.Serialize()
+}
+
+func (m testMember) grant(epoch uint64, attorney testMember, scope Scope) []byte {
+	grant := GrantPowerOfAttorney{Epoch: epoch, Author: m.token, Attorney: attorney.token, Scope: scope}
+	grant.Fingerprint = NewAcceptance(m.token, scope, attorney.key)
+	grant.Sign(m.key)
+	return grant.Serialize()
+}
+
+func (m testMember) revoke(epoch uint64, attorney testMember) []byte {
+	revoke := RevokePowerOfAttorney{Epoch: epoch, Author: m.token, Attorney: attorney.token}
+	revoke.Sign(m.key)
+	return revoke.Serialize()
+}
+
+func (m testMember) update(epoch uint64, details string) []byte {
+	update := UpdateInfo{Epoch: epoch, Author: m.token, Details: details, Signer: m.token}
+	update.Sign(m.key)
+	return update.Serialize()
+}
+
+// validateBlock validates actions for a block at epoch on top of state. Every
+// action must be accepted.
+func validateBlock(t *testing.T, state *State, epoch uint64, actions ...[]byte) *MutatingState {
+	t.Helper()
+	validator := state.Validator()
+	validator.SetEpoch(epoch)
+	for n, action := range actions {
+		if err := validator.ValidateAction(action); err != nil {
+			t.Fatalf("action %v of block %v rejected: %v", n, epoch, err)
+		}
+	}
+	return validator
+}
+
+// incorporate validates actions for a block at epoch and incorporates them
+// into state.
+func incorporate(t *testing.T, state *State, epoch uint64, actions ...[]byte) {
+	t.Helper()
+	state.Incorporate(validateBlock(t, state, epoch, actions...).Mutations())
+}
+
+func TestHandleRegistry(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		state := NewGenesisState(dir)
+		alice, bob := newTestMember(), newTestMember()
+		validator := validateBlock(t, state, 1, alice.join(1, "alice"), bob.join(1, "Bob"))
+		if handle, ok := validator.HandleOf(bob.token); !ok || handle != "bob" {
+			t.Fatalf("pending handle of bob is %q", handle)
+		}
+		state.Incorporate(validator.Mutations())
+		if dir != "" {
+			state = shutdownAndRecover(t, state, dir)
+		}
+		for handle, member := range map[string]testMember{"alice": alice, "BOB": bob} {
+			if token, ok := state.TokenOf(handle); !ok || token != member.token {
+				t.Fatalf("%q does not resolve to its owner", handle)
+			}
+		}
+		if handle, ok := state.HandleOf(alice.token); !ok || handle != "alice" {
+			t.Fatalf("handle of alice is %q", handle)
+		}
+		if _, ok := state.TokenOf("carol"); ok {
+			t.Fatal("unclaimed handle resolved")
+		}
+		if _, ok := state.HandleOf(newTestMember().token); ok {
+			t.Fatal("handle of a non member resolved")
+		}
+		state.Shutdown()
+	}
+}
+
+func TestStateFromBytesChecksum(t *testing.T) {
+	state := NewGenesisState("")
+	incorporate(t, state, 1, newTestMember().join(1, "alice"))
+	data := state.Serialize()
+	if recovered := StateFromBytes(data); recovered == nil || recovered.Checksum() != state.Checksum() || recovered.Epoch != 1 {
+		t.Fatal("state does not round trip")
+	}
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)-1] ^= 1
+	if StateFromBytes(corrupted) != nil {
+		t.Fatal("corrupted state accepted")
+	}
+	if StateFromBytes(data[:len(data)/2]) != nil {
+		t.Fatal("truncated state accepted")
+	}
+}
+
+func TestChecksumOrderIndependent(t *testing.T) {
+	alice, bob := newTestMember(), newTestMember()
+	first, second := NewGenesisState(""), NewGenesisState("")
+	incorporate(t, first, 1, alice.join(1, "alice"), bob.join(1, "bob"))
+	incorporate(t, second, 1, bob.join(1, "bob"), alice.join(1, "alice"))
+	// the work seed chains actions in the order they were accepted, every
+	// other vault only depends on its content
+	if first.Seeds.Digest() == second.Seeds.Digest() {
+		t.Fatal("work seeds do not depend on the order of actions")
+	}
+	first.Seeds, second.Seeds = NewGenesisState("").Seeds, NewGenesisState("").Seeds
+	if first.Checksum() != second.Checksum() {
+		t.Fatal("checksum depends on incorporation order")
+	}
+	if first.Checksum() == NewGenesisState("").Checksum() {
+		t.Fatal("checksum does not depend on content")
+	}
+}
+
+func TestProfile(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState(dir)
+	alice, bob, attorney := newTestMember(), newTestMember(), newTestMember()
+	incorporate(t, state, 1, alice.join(1, "alice"), bob.join(1, "bob"))
+	if details, ok := state.Profile(alice.token); !ok || details != `{}` {
+		t.Fatalf("profile not seeded from the join: %q", details)
+	}
+	validator := validateBlock(t, state, 2, alice.update(2, `{"n":1}`), alice.update(2, `{"n":2}`), bob.grant(2, attorney, Scope{}))
+	if details, _ := validator.Profile(alice.token); details != `{"n":2}` {
+		t.Fatalf("pending profile is %q", details)
+	}
+	if err := validator.ValidateAction(alice.update(2, `{"n":`)); !errors.Is(err, ErrMalformedJSON) {
+		t.Fatalf("expected malformed details rejected, got %v", err)
+	}
+	state.Incorporate(validator.Mutations())
+	incorporate(t, state, 3, attorney.updateFor(3, bob, `{"by":"attorney"}`))
+	state = shutdownAndRecover(t, state, dir)
+	defer state.Shutdown()
+	if details, _ := state.Profile(alice.token); details != `{"n":2}` {
+		t.Fatalf("profile of alice is %q", details)
+	}
+	if details, _ := state.Profile(bob.token); details != `{"by":"attorney"}` {
+		t.Fatalf("profile of bob is %q", details)
+	}
+	if _, ok := state.Profile(attorney.token); ok {
+		t.Fatal("profile of a non member")
+	}
+}
@@ -0,0 +1,284 @@
+package attorney
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// A state stream is a header followed by a section for each vault, in the
+// order of hashVaultNames and recordVaultNames. The header is a magic, the
+// stream version, the state epoch and the state checksum. A section is a
+// sequence of chunks, each a 4-byte length, a payload and the hash of the
+// payload, ended by a chunk of zero length. Chunks of hash vaults carry
+// hashes, chunks of record vaults carry records as the hash, a 4-byte
+// length and the value. No item is split across chunks, so that a reader
+// never holds more than one chunk of a section in memory.
+
+// StreamVersion is the version of state streams written by this release.
+const StreamVersion = 1
+
+const (
+	streamMagic      = "HSTM"
+	streamHeaderSize = 4 + 4 + 8 + crypto.Size
+	streamChunkSize  = 1 << 16
+	// MaxStreamChunk is the largest chunk payload accepted by ReadState.
+	MaxStreamChunk = 1 << 26
+)
+
+var ErrInvalidStream = errors.New("invalid state stream")
+
+// chunkWriter buffers the items of a section and writes them in chunks.
+type chunkWriter struct {
+	w       io.Writer
+	payload []byte
+	written int64
+	err     error
+}
+
+func (c *chunkWriter) write(data []byte) {
+	if _, err := c.w.Write(data); err != nil && c.err == nil {
+		c.err = err
+	}
+	c.written += int64(len(data))
+}
+
+// item appends an item to the chunk in progress, flushing it if full.
+func (c *chunkWriter) item(data []byte) {
+	c.payload = append(c.payload, data...)
+	if len(c.payload) >= streamChunkSize {
+		c.flush()
+	}
+}
+
+func (c *chunkWriter) flush() {
+	if len(c.payload) == 0 {
+		return
+	}
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(c.payload)))
+	c.write(length)
+	c.write(c.payload)
+	hash := crypto.Hasher(c.payload)
+	c.write(hash[:])
+	c.payload = c.payload[:0]
+}
+
+// end flushes the chunk in progress and ends the section.
+func (c *chunkWriter) end() {
+	c.flush()
+	c.write([]byte{0, 0, 0, 0})
+}
+
+// WriteTo writes the state stream to w. It implements io.WriterTo. The state
+// is frozen when WriteTo is called and streamed while it keeps changing, so
+// that it may run alongside Incorporate: the writer holds a chunk and the
+// changes made since the state was frozen, and no copy of the vaults.
+func (s *State) WriteTo(w io.Writer) (int64, error) {
+	view := s.freeze()
+	defer view.release()
+	return view.WriteTo(w)
+}
+
+// stateView is the content of a state frozen at some epoch.
+type stateView struct {
+	epoch    uint64
+	checksum crypto.Hash
+	hashes   []*hashView
+	records  []*recordView
+}
+
+// freeze returns a view of the current content of the state. It must be
+// called between Incorporate calls and the view released once read.
+func (s *State) freeze() *stateView {
+	view := &stateView{epoch: s.Epoch}
+	digests := make([]crypto.Hash, 0)
+	for _, vault := range s.hashVaults() {
+		hashes := vault.freeze()
+		view.hashes = append(view.hashes, hashes)
+		digests = append(digests, hashes.digest)
+	}
+	for _, vault := range s.recordVaults() {
+		records := vault.freeze()
+		view.records = append(view.records, records)
+		digests = append(digests, records.digest)
+	}
+	view.checksum = checksumOf(digests)
+	return view
+}
+
+func (v *stateView) release() {
+	for _, view := range v.hashes {
+		view.release()
+	}
+	for _, view := range v.records {
+		view.release()
+	}
+}
+
+// WriteTo writes the state stream of the view to w. Hash vaults are written in
+// the order of their buckets, record vaults in key order.
+func (v *stateView) WriteTo(w io.Writer) (int64, error) {
+	buffered := bufio.NewWriter(w)
+	writer := &chunkWriter{w: buffered, payload: make([]byte, 0, streamChunkSize)}
+	header := []byte(streamMagic)
+	util.PutUint32(StreamVersion, &header)
+	util.PutUint64(v.epoch, &header)
+	util.PutHash(v.checksum, &header)
+	writer.write(header)
+	for _, view := range v.hashes {
+		view.each(func(hash crypto.Hash) {
+			writer.item(hash[:])
+		})
+		writer.end()
+	}
+	for _, view := range v.records {
+		view.each(func(hash crypto.Hash, value []byte) {
+			record := append([]byte{}, hash[:]...)
+			util.PutUint32(uint32(len(value)), &record)
+			writer.item(append(record, value...))
+		})
+		writer.end()
+	}
+	if writer.err != nil {
+		return writer.written, writer.err
+	}
+	return writer.written, buffered.Flush()
+}
+
+// chunkReader reads the chunks of a section.
+type chunkReader struct {
+	r io.Reader
+}
+
+// next returns the payload of the next chunk of the section, or nil at the
+// end of the section.
+func (c *chunkReader) next() ([]byte, error) {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, length); err != nil {
+		return nil, streamError(err)
+	}
+	size := binary.LittleEndian.Uint32(length)
+	if size == 0 {
+		return nil, nil
+	}
+	if size > MaxStreamChunk {
+		return nil, fmt.Errorf("%w: chunk of %v bytes", ErrInvalidStream, size)
+	}
+	payload := make([]byte, int(size)+crypto.Size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return nil, streamError(err)
+	}
+	hash := crypto.Hasher(payload[:size])
+	if !hash.Equal(crypto.Hash(payload[size:])) {
+		return nil, fmt.Errorf("%w: corrupted chunk", ErrInvalidStream)
+	}
+	return payload[:size], nil
+}
+
+// streamError reports a truncated stream as invalid and any other read error
+// as is.
+func streamError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: truncated", ErrInvalidStream)
+	}
+	return err
+}
+
+// ReadState recreates a state from a stream written by WriteTo, file-backed
+// at dataPath or in memory if dataPath is empty, with hash vaults sized for
+// the expected items. The state must match the checksum of the stream header.
+func ReadState(r io.Reader, dataPath string, items VaultItems) (*State, error) {
+	reader := &chunkReader{r: bufio.NewReader(r)}
+	data := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(reader.r, data); err != nil {
+		return nil, streamError(err)
+	}
+	if string(data[0:4]) != streamMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidStream)
+	}
+	version, position := util.ParseUint32(data, 4)
+	if version != StreamVersion {
+		return nil, fmt.Errorf("%w: version %v, expected %v", ErrInvalidStream, version, StreamVersion)
+	}
+	epoch, position := util.ParseUint64(data, position)
+	checksum, _ := util.ParseHash(data, position)
+	hashes := make([]*hashVault, len(hashVaultNames))
+	records := make([]*recordVault, len(recordVaultNames))
+	for n, name := range hashVaultNames {
+		hashes[n] = NewHashVault(name, epoch, items.bitsForBucket(name), dataPath)
+	}
+	for n, name := range recordVaultNames {
+		records[n] = NewRecordVault(name, dataPath)
+	}
+	state := stateFromVaults(hashes, records, dataPath)
+	state.Epoch = epoch
+	if err := state.readSections(reader); err != nil {
+		state.close()
+		return nil, err
+	}
+	if hash := state.Checksum(); !hash.Equal(checksum) {
+		state.close()
+		return nil, fmt.Errorf("%w: checksum does not match header", ErrInvalidStream)
+	}
+	if dataPath != "" {
+		state.persistMeta(false, checksum)
+	}
+	return state, nil
+}
+
+func (s *State) readSections(reader *chunkReader) error {
+	for n, vault := range s.hashVaults() {
+		if vault == nil {
+			return fmt.Errorf("could not create vault %v", hashVaultNames[n])
+		}
+		for {
+			payload, err := reader.next()
+			if err != nil {
+				return err
+			}
+			if payload == nil {
+				break
+			}
+			if len(payload)%crypto.Size != 0 {
+				return fmt.Errorf("%w: partial hash in %v", ErrInvalidStream, hashVaultNames[n])
+			}
+			for position := 0; position < len(payload); position += crypto.Size {
+				vault.InsertHash(crypto.Hash(payload[position : position+crypto.Size]))
+			}
+		}
+	}
+	for n, vault := range s.recordVaults() {
+		if vault == nil {
+			return fmt.Errorf("could not create vault %v", recordVaultNames[n])
+		}
+		for {
+			payload, err := reader.next()
+			if err != nil {
+				return err
+			}
+			if payload == nil {
+				break
+			}
+			for position := 0; position < len(payload); {
+				if position+crypto.Size+4 > len(payload) {
+					return fmt.Errorf("%w: partial record in %v", ErrInvalidStream, recordVaultNames[n])
+				}
+				hash := crypto.Hash(payload[position : position+crypto.Size])
+				size := binary.LittleEndian.Uint32(payload[position+crypto.Size:])
+				position += crypto.Size + 4
+				if uint64(size) > uint64(len(payload)-position) {
+					return fmt.Errorf("%w: partial record in %v", ErrInvalidStream, recordVaultNames[n])
+				}
+				vault.Set(hash, payload[position:position+int(size)])
+				position += int(size)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package attorney
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// populatedState returns a memory state with count members, enough to spread
+// the stream over several chunks.
+func populatedState(t *testing.T, count int) *State {
+	t.Helper()
+	state := NewGenesisState("")
+	for n := 0; n < count; n += 100 {
+		epoch := uint64(n/100 + 1)
+		actions := make([][]byte, 0, 100)
+		for m := n; m < n+100 && m < count; m++ {
+			actions = append(actions, newTestMember().join(epoch, fmt.Sprintf("user%d", m)))
+		}
+		incorporate(t, state, epoch, actions...)
+	}
+	return state
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	state := populatedState(t, 3000)
+	var buffer bytes.Buffer
+	written, err := state.WriteTo(&buffer)
+	if err != nil || written != int64(buffer.Len()) {
+		t.Fatalf("wrote %v bytes of %v: %v", written, buffer.Len(), err)
+	}
+	for _, dir := range []string{"", t.TempDir()} {
+		read, err := ReadState(bytes.NewReader(buffer.Bytes()), dir, nil)
+		if err != nil {
+			t.Fatalf("could not read stream: %v", err)
+		}
+		if read.Checksum() != state.Checksum() || read.Epoch != state.Epoch {
+			t.Fatal("streamed state does not match")
+		}
+		read.Shutdown()
+	}
+}
+
+func TestStreamInvalid(t *testing.T) {
+	state := populatedState(t, 100)
+	var buffer bytes.Buffer
+	if _, err := state.WriteTo(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	for _, cut := range []int{0, 10, 100, len(data) / 2, len(data) - 1} {
+		if _, err := ReadState(bytes.NewReader(data[:cut]), "", nil); !errors.Is(err, ErrInvalidStream) {
+			t.Fatalf("stream cut at %v: expected invalid stream, got %v", cut, err)
+		}
+	}
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)/2] ^= 1
+	if _, err := ReadState(bytes.NewReader(corrupted), "", nil); !errors.Is(err, ErrInvalidStream) {
+		t.Fatalf("expected invalid stream, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package attorney
+
+import (
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// Tombstone marks a released handle. Until the epoch Until (inclusive) only
+// Owner may claim the handle again.
+type Tombstone struct {
+	Owner crypto.Token
+	Until uint64
+}
+
+func (t Tombstone) Serialize() []byte {
+	bytes := []byte{}
+	util.PutToken(t.Owner, &bytes)
+	util.PutUint64(t.Until, &bytes)
+	return bytes
+}
+
+func ParseTombstone(data []byte) (Tombstone, bool) {
+	tombstone := Tombstone{}
+	position := 0
+	tombstone.Owner, position = util.ParseToken(data, position)
+	tombstone.Until, position = util.ParseUint64(data, position)
+	return tombstone, position == len(data)
+}
+
+// Allows checks if token may claim the tombstoned handle at epoch.
+func (t Tombstone) Allows(token crypto.Token, epoch uint64) bool {
+	return epoch > t.Until || t.Owner.Equal(token)
+}
+
+// ReleaseHandle frees the handle of Author, who leaves the network. The
+// handle is tombstoned for the release cooldown of the network from the epoch
+// of the block that includes the release, during which only Author may claim
+// it again.
+type ReleaseHandle struct {
+	Epoch     uint64
+	Author    crypto.Token
+	Handle    string
+	Signature crypto.Signature
+}
+
+func (r *ReleaseHandle) Tokens() []crypto.Token {
+	return []crypto.Token{r.Author}
+}
+
+func (r *ReleaseHandle) Validate(v ActionValidator) error {
+	if !v.HasMember(r.Author) {
+		return ErrNotMember
+	}
+	if !v.SetNewRelease(r.Author, r.Handle) {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (r *ReleaseHandle) Kind() byte {
+	return ReleaseHandleType
+}
+
+func (r *ReleaseHandle) serializeToSign() []byte {
+	bytes := axeHeader(r.Epoch, ReleaseHandleType)
+	util.PutToken(r.Author, &bytes)
+	util.PutString(r.Handle, &bytes)
+	return bytes
+}
+
+func (r *ReleaseHandle) Serialize() []byte {
+	bytes := r.serializeToSign()
+	util.PutSignature(r.Signature, &bytes)
+	return bytes
+}
+
+func (r *ReleaseHandle) Sign(pk crypto.PrivateKey) {
+	r.Signature = pk.Sign(r.serializeToSign())
+}
+
+func ParseReleaseHandle(data []byte) *ReleaseHandle {
+	action, _ := ParseAction(data).(*ReleaseHandle)
+	return action
+}
+
+func parseReleaseHandle(data []byte) (*ReleaseHandle, error) {
+	epoch, position, ok := parseAxeHeader(data, ReleaseHandleType)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	release := ReleaseHandle{Epoch: epoch}
+	release.Author, position = util.ParseToken(data, position)
+	release.Handle, position = util.ParseString(data, position)
+	canonical, ok := CanonicalHandle(release.Handle)
+	if !ok {
+		return nil, ErrInvalidHandle
+	}
+	hashPosition := position
+	release.Signature, position = util.ParseSignature(data, position)
+	if position != len(data) {
+		return nil, ErrMalformed
+	}
+	if !release.Author.Verify(data[0:hashPosition], release.Signature) {
+		return nil, ErrBadSignature
+	}
+	release.Handle = canonical
+	return &release, nil
+}
+
+// TombstoneOf returns the tombstone of a released handle, or of a released
+// handle confusable with it. Expired tombstones are kept until the handle is
+// claimed again.
+func (s *State) TombstoneOf(handle string) (Tombstone, bool) {
+	hash, ok := skeletonHash(handle)
+	if !ok {
+		return Tombstone{}, false
+	}
+	data, ok := s.Tombstones.Get(hash)
+	if !ok {
+		return Tombstone{}, false
+	}
+	return ParseTombstone(data)
+}
+
+// release frees the handle of the tombstone owner and removes the owner from
+// the network. The tombstone is keyed by the skeleton of the handle.
+func (s *State) release(skeleton crypto.Hash, tombstone Tombstone) {
+	ownerHash := crypto.HashToken(tombstone.Owner)
+	if handle, ok := s.Handles.Get(ownerHash); ok {
+		caption := crypto.Hasher(handle)
+		s.Captions.RemoveHash(caption)
+		s.Owners.Remove(caption)
+	}
+	s.Skeletons.RemoveHash(skeleton)
+	s.Members.RemoveHash(ownerHash)
+	s.Handles.Remove(ownerHash)
+	s.Profiles.Remove(ownerHash)
+	s.dropAuthority(tombstone.Owner)
+	s.Tombstones.Set(skeleton, tombstone.Serialize())
+}
+
+// dropAuthority revokes every grant, guardian and pending recovery of a
+// token leaving the network.
+func (s *State) dropAuthority(token crypto.Token) {
+	hash := crypto.HashToken(token)
+	for _, attorney := range s.Delegates(token) {
+		grant := attorneyHash(token, attorney)
+		s.Attorneys.RemoveHash(grant)
+		s.Grants.Remove(grant)
+	}
+	s.setDelegates(token, nil)
+	s.Guardians.Remove(hash)
+	s.Recoveries.Remove(hash)
+}
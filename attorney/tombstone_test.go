@@ -0,0 +1,74 @@
+package attorney
+
+import (
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func (m testMember) release(epoch uint64, handle string) []byte {
+	release := ReleaseHandle{Epoch: epoch, Author: m.token, Handle: handle}
+	release.Sign(m.key)
+	return release.Serialize()
+}
+
+func TestReleaseHandle(t *testing.T) {
+	state := NewGenesisStateWithParameters("", Parameters{ReleaseCooldown: 50})
+	owner, squatter := newTestMember(), newTestMember()
+	incorporate(t, state, 1, owner.join(1, "neat"))
+	// the tombstone runs from the block of the release, whatever epoch the
+	// release was signed for
+	incorporate(t, state, 10, owner.release(10+ActionWindow/2, "neat"))
+	if state.HasHandle("neat") || state.HasMember(owner.token) {
+		t.Fatal("handle not released")
+	}
+	if tombstone, ok := state.TombstoneOf("neat"); !ok || tombstone.Until != 60 {
+		t.Fatalf("unexpected tombstone %+v", tombstone)
+	}
+	// a join signed past the tombstone is still checked at the block epoch
+	validator := state.Validator()
+	validator.SetEpoch(60)
+	if err := validator.ValidateAction(squatter.join(61, "neat")); err == nil {
+		t.Fatal("tombstoned handle claimed by another token")
+	}
+	if err := validator.ValidateAction(owner.join(60, "neat")); err != nil {
+		t.Fatalf("previous owner could not claim the handle back: %v", err)
+	}
+	state.Incorporate(validator.Mutations())
+	if _, ok := state.TombstoneOf("neat"); ok || !state.HasHandle("neat") {
+		t.Fatal("tombstone left after the handle was claimed back")
+	}
+	incorporate(t, state, 70, owner.release(70, "neat"))
+	validator = state.Validator()
+	validator.SetEpoch(121)
+	if err := validator.ValidateAction(squatter.join(121, "neat")); err != nil {
+		t.Fatalf("expired tombstone rejected a join: %v", err)
+	}
+}
+
+func TestReleaseHandleCrowdedBucket(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState(dir)
+	owner, attorney := newTestMember(), newTestMember()
+	incorporate(t, state, 1, owner.join(1, "neat"))
+	incorporate(t, state, 2, owner.grant(2, attorney, Scope{}))
+	member := crypto.HashToken(owner.token)
+	caption, _ := captionHash("neat")
+	skeleton, _ := skeletonHash("neat")
+	grant := attorneyHash(owner.token, attorney.token)
+	members := crowdBucket(state.Members, member, 8)
+	captions := crowdBucket(state.Captions, caption, 8)
+	skeletons := crowdBucket(state.Skeletons, skeleton, 8)
+	grants := crowdBucket(state.Attorneys, grant, 8)
+	incorporate(t, state, 3, owner.release(3, "neat"))
+	checkCrowd(t, state.Members, member, members)
+	checkCrowd(t, state.Captions, caption, captions)
+	checkCrowd(t, state.Skeletons, skeleton, skeletons)
+	checkCrowd(t, state.Attorneys, grant, grants)
+	recovered := shutdownAndRecover(t, state, dir)
+	checkCrowd(t, recovered.Members, member, members)
+	checkCrowd(t, recovered.Captions, caption, captions)
+	checkCrowd(t, recovered.Skeletons, skeleton, skeletons)
+	checkCrowd(t, recovered.Attorneys, grant, grants)
+	recovered.Shutdown()
+}
@@ -0,0 +1,126 @@
+package attorney
+
+import (
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/util"
+)
+
+// TransferHandle hands the handle of Author over to Recipient. It carries the
+// signatures of both the current owner and the recipient. Recipient must not
+// be a member: it becomes the member owning the handle and inherits its
+// profile, while Author leaves the network. Grants, guardians and pending
+// recoveries of Author are dropped.
+//
+// Transfers to an existing member are rejected with ErrRecipientIsMember. A
+// token owns a single handle, so a member taking over another handle would
+// have to give up its own in the same action. Members that want to merge
+// accounts release or transfer one of the handles first.
+type TransferHandle struct {
+	Epoch              uint64
+	Author             crypto.Token
+	Handle             string
+	Recipient          crypto.Token
+	Signature          crypto.Signature
+	RecipientSignature crypto.Signature
+}
+
+func (t *TransferHandle) Tokens() []crypto.Token {
+	return []crypto.Token{t.Author, t.Recipient}
+}
+
+func (t *TransferHandle) Validate(v ActionValidator) error {
+	if !v.HasMember(t.Author) {
+		return ErrNotMember
+	}
+	if v.HasMember(t.Recipient) {
+		return ErrRecipientIsMember
+	}
+	if !v.SetNewTransfer(t.Author, t.Handle, t.Recipient) {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (t *TransferHandle) Kind() byte {
+	return TransferHandleType
+}
+
+func (t *TransferHandle) serializeToSign() []byte {
+	bytes := axeHeader(t.Epoch, TransferHandleType)
+	util.PutToken(t.Author, &bytes)
+	util.PutString(t.Handle, &bytes)
+	util.PutToken(t.Recipient, &bytes)
+	return bytes
+}
+
+func (t *TransferHandle) Serialize() []byte {
+	bytes := t.serializeToSign()
+	util.PutSignature(t.Signature, &bytes)
+	util.PutSignature(t.RecipientSignature, &bytes)
+	return bytes
+}
+
+// Sign signs the transfer with the key of the current owner.
+func (t *TransferHandle) Sign(pk crypto.PrivateKey) {
+	t.Signature = pk.Sign(t.serializeToSign())
+}
+
+// Accept signs the transfer with the key of the recipient.
+func (t *TransferHandle) Accept(pk crypto.PrivateKey) {
+	t.RecipientSignature = pk.Sign(t.serializeToSign())
+}
+
+func ParseTransferHandle(data []byte) *TransferHandle {
+	action, _ := ParseAction(data).(*TransferHandle)
+	return action
+}
+
+func parseTransferHandle(data []byte) (*TransferHandle, error) {
+	epoch, position, ok := parseAxeHeader(data, TransferHandleType)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	transfer := TransferHandle{Epoch: epoch}
+	transfer.Author, position = util.ParseToken(data, position)
+	transfer.Handle, position = util.ParseString(data, position)
+	canonical, ok := CanonicalHandle(transfer.Handle)
+	if !ok {
+		return nil, ErrInvalidHandle
+	}
+	transfer.Recipient, position = util.ParseToken(data, position)
+	hashPosition := position
+	transfer.Signature, position = util.ParseSignature(data, position)
+	transfer.RecipientSignature, position = util.ParseSignature(data, position)
+	if position != len(data) || transfer.Author.Equal(transfer.Recipient) {
+		return nil, ErrMalformed
+	}
+	if !transfer.Author.Verify(data[0:hashPosition], transfer.Signature) {
+		return nil, ErrBadSignature
+	}
+	if !transfer.Recipient.Verify(data[0:hashPosition], transfer.RecipientSignature) {
+		return nil, ErrBadSignature
+	}
+	transfer.Handle = canonical
+	return &transfer, nil
+}
+
+// transfer moves membership, handle and profile of from to token. The caption
+// of the handle is left untouched since the handle remains taken.
+func (s *State) transfer(from, token crypto.Token) {
+	fromHash := crypto.HashToken(from)
+	newHash := crypto.HashToken(token)
+	handle, ok := s.Handles.Get(fromHash)
+	if !ok {
+		return
+	}
+	s.Members.RemoveHash(fromHash)
+	s.Members.InsertHash(newHash)
+	s.Handles.Remove(fromHash)
+	s.Handles.Set(newHash, handle)
+	s.Owners.Set(crypto.Hasher(handle), token[:])
+	if details, ok := s.Profiles.Get(fromHash); ok {
+		s.Profiles.Remove(fromHash)
+		s.Profiles.Set(newHash, details)
+	}
+	s.dropAuthority(from)
+}
@@ -0,0 +1,62 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func (m testMember) transfer(epoch uint64, handle string, recipient testMember) []byte {
+	transfer := TransferHandle{Epoch: epoch, Author: m.token, Handle: handle, Recipient: recipient.token}
+	transfer.Sign(m.key)
+	transfer.Accept(recipient.key)
+	return transfer.Serialize()
+}
+
+func TestTransferHandle(t *testing.T) {
+	state := NewGenesisState("")
+	acme, buyer := newTestMember(), newTestMember()
+	incorporate(t, state, 1, acme.join(1, "acme"))
+	validator := validateBlock(t, state, 2, acme.transfer(2, "acme", buyer))
+	if err := validator.ValidateAction(acme.transfer(2, "acme", newTestMember())); err == nil {
+		t.Fatal("handle transferred twice on the same block")
+	}
+	state.Incorporate(validator.Mutations())
+	if token, _ := state.TokenOf("acme"); token != buyer.token {
+		t.Fatal("handle not transferred")
+	}
+	if state.HasMember(acme.token) || !state.HasMember(buyer.token) || !state.HasHandle("acme") {
+		t.Fatal("membership not transferred")
+	}
+	if details, _ := state.Profile(buyer.token); details != `{}` {
+		t.Fatal("profile not transferred")
+	}
+}
+
+func TestTransferToMember(t *testing.T) {
+	state := NewGenesisState("")
+	acme, bob := newTestMember(), newTestMember()
+	incorporate(t, state, 1, acme.join(1, "acme"), bob.join(1, "bob"))
+	err := state.Validator().ValidateAction(acme.transfer(2, "acme", bob))
+	if !errors.Is(err, ErrRecipientIsMember) {
+		t.Fatalf("expected transfer to a member rejected, got %v", err)
+	}
+}
+
+func TestTransferHandleCrowdedBucket(t *testing.T) {
+	dir := t.TempDir()
+	state := NewGenesisState(dir)
+	acme, buyer := newTestMember(), newTestMember()
+	incorporate(t, state, 1, acme.join(1, "acme"))
+	member := crypto.HashToken(acme.token)
+	members := crowdBucket(state.Members, member, 8)
+	incorporate(t, state, 2, acme.transfer(2, "acme", buyer))
+	checkCrowd(t, state.Members, member, members)
+	recovered := shutdownAndRecover(t, state, dir)
+	checkCrowd(t, recovered.Members, member, members)
+	if !recovered.HasMember(buyer.token) {
+		t.Fatal("recovered state lost the recipient")
+	}
+	recovered.Shutdown()
+}
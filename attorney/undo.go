@@ -0,0 +1,148 @@
+package attorney
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// UndoWindow is the number of epochs of incorporated mutations that can be
+// rolled back.
+const UndoWindow = 100
+
+// ErrRollbackTooDeep is returned by Rollback when the undo journal does not
+// reach back to the requested epoch.
+var ErrRollbackTooDeep = errors.New("rollback beyond the undo journal")
+
+// undoJournal keeps, for every Incorporate within UndoWindow, the inverse of
+// each change made to the vaults. It is shared by all vaults of a state and
+// kept in memory only: a reopened state cannot be rolled back past the epoch
+// it was opened at.
+type undoJournal struct {
+	entries []undoEntry
+	current *undoEntry // entry of the Incorporate in progress
+}
+
+type undoEntry struct {
+	from  uint64   // epoch of the state before the Incorporate
+	epoch uint64   // epoch of the state after the Incorporate
+	steps []func() // inverse of each change, in the order changes were made
+}
+
+// record keeps step to revert a change made by the Incorporate in progress.
+// Changes made outside Incorporate, including those of Rollback, are not
+// recorded.
+func (u *undoJournal) record(step func()) {
+	if u == nil || u.current == nil {
+		return
+	}
+	u.current.steps = append(u.current.steps, step)
+}
+
+func (u *undoJournal) begin(epoch uint64) {
+	u.current = &undoEntry{from: epoch}
+}
+
+// commit closes the entry in progress and forgets entries that fell out of
+// the undo window.
+func (u *undoJournal) commit(epoch uint64) {
+	if u.current == nil {
+		return
+	}
+	u.current.epoch = epoch
+	u.entries = append(u.entries, *u.current)
+	u.current = nil
+	keep := 0
+	for keep < len(u.entries) && u.entries[keep].epoch+UndoWindow < epoch {
+		keep++
+	}
+	u.entries = u.entries[keep:]
+}
+
+func (s *State) attachUndo() {
+	for _, vault := range s.hashVaults() {
+		if vault != nil {
+			vault.undo = &s.undo
+		}
+	}
+	for _, vault := range s.recordVaults() {
+		if vault != nil {
+			vault.undo = &s.undo
+		}
+	}
+}
+
+// Rollback reverts the mutations incorporated after toEpoch, so that a node
+// can drop blocks invalidated by a later commit and incorporate the right
+// ones. It returns ErrRollbackTooDeep, leaving the state untouched, if the
+// mutations were incorporated more than UndoWindow epochs ago or before the
+// state was opened.
+func (s *State) Rollback(toEpoch uint64) error {
+	if toEpoch >= s.Epoch {
+		return nil
+	}
+	first := len(s.undo.entries)
+	for first > 0 && s.undo.entries[first-1].epoch > toEpoch {
+		first--
+	}
+	if first == len(s.undo.entries) || s.undo.entries[first].from > toEpoch {
+		return fmt.Errorf("%w: cannot roll back from epoch %v to %v", ErrRollbackTooDeep, s.Epoch, toEpoch)
+	}
+	if s.dataPath != "" {
+		s.persistMeta(true, crypto.ZeroValueHash)
+	}
+	for n := len(s.undo.entries) - 1; n >= first; n-- {
+		steps := s.undo.entries[n].steps
+		for step := len(steps) - 1; step >= 0; step-- {
+			steps[step]()
+		}
+	}
+	s.Epoch = s.undo.entries[first].from
+	s.undo.entries = s.undo.entries[:first]
+	if s.dataPath != "" {
+		s.persistMeta(false, crypto.ZeroValueHash)
+	}
+	return nil
+}
+
+// IncorporateBlock validates the actions of the block at epoch on top of the
+// state and incorporates the valid ones. It lets a state follow sealed blocks
+// before they are committed, see Invalidate.
+func (s *State) IncorporateBlock(epoch uint64, actions [][]byte) {
+	validator := s.Validator()
+	validator.SetEpoch(epoch)
+	for _, action := range actions {
+		validator.ValidateAction(action)
+	}
+	s.Incorporate(validator.Mutations())
+}
+
+// Invalidate drops the actions invalidated by the commit of the block at
+// epoch from a state that incorporated the block as sealed. The state is
+// rolled back to the epoch before the block, then the block without the
+// invalidated actions and every later block, given as the actions of each
+// block from epoch on, are incorporated again. Later actions that are no
+// longer valid without the invalidated ones are left out.
+func (s *State) Invalidate(epoch uint64, invalidated []crypto.Hash, blocks ...[][]byte) error {
+	if epoch == 0 {
+		return fmt.Errorf("%w: cannot roll back before genesis", ErrRollbackTooDeep)
+	}
+	if err := s.Rollback(epoch - 1); err != nil {
+		return err
+	}
+	dropped := make(map[crypto.Hash]struct{})
+	for _, hash := range invalidated {
+		dropped[hash] = struct{}{}
+	}
+	for n, actions := range blocks {
+		valid := make([][]byte, 0, len(actions))
+		for _, action := range actions {
+			if _, ok := dropped[crypto.Hasher(action)]; !ok {
+				valid = append(valid, action)
+			}
+		}
+		s.IncorporateBlock(epoch+uint64(n), valid)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+func TestRollback(t *testing.T) {
+	for _, dir := range []string{"", t.TempDir()} {
+		state := NewGenesisState(dir)
+		alice, attorney, fresh := newTestMember(), newTestMember(), newTestMember()
+		genesis := state.Checksum()
+		incorporate(t, state, 1, alice.join(1, "alice"))
+		first := state.Checksum()
+		incorporate(t, state, 2, alice.grant(2, attorney, Scope{}))
+		second := state.Checksum()
+		incorporate(t, state, 3, alice.update(3, `{"n":1}`), alice.rotate(3, fresh))
+		if err := state.Rollback(2); err != nil || state.Epoch != 2 || state.Checksum() != second {
+			t.Fatalf("rollback to epoch 2 does not match: %v", err)
+		}
+		if err := state.Rollback(1); err != nil || state.Checksum() != first || !state.HasMember(alice.token) {
+			t.Fatalf("rollback to epoch 1 does not match: %v", err)
+		}
+		if err := state.Rollback(0); err != nil || state.Checksum() != genesis || state.HasHandle("alice") {
+			t.Fatalf("rollback to genesis does not match: %v", err)
+		}
+		if dir != "" {
+			shutdownAndRecover(t, state, dir).Shutdown()
+		}
+	}
+}
+
+func TestRollbackTooDeep(t *testing.T) {
+	state := NewGenesisState("")
+	incorporate(t, state, 1, newTestMember().join(1, "alice"))
+	for epoch := uint64(2); epoch < UndoWindow+3; epoch++ {
+		incorporate(t, state, epoch)
+	}
+	checksum := state.Checksum()
+	if err := state.Rollback(0); !errors.Is(err, ErrRollbackTooDeep) {
+		t.Fatalf("expected rollback too deep, got %v", err)
+	}
+	if state.Epoch != UndoWindow+2 || state.Checksum() != checksum {
+		t.Fatal("failed rollback changed the state")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	alice, bob, carol := newTestMember(), newTestMember(), newTestMember()
+	blocks := [][][]byte{
+		{alice.join(1, "alice"), bob.join(1, "bob")},
+		{carol.join(2, "carol"), alice.update(2, `{"n":1}`)},
+		{bob.update(3, `{"n":2}`)},
+	}
+	invalidated := []crypto.Hash{crypto.Hasher(blocks[0][0])}
+
+	followed := NewGenesisState("")
+	for n, actions := range blocks {
+		followed.IncorporateBlock(uint64(n+1), actions)
+	}
+	if err := followed.Invalidate(1, invalidated, blocks...); err != nil {
+		t.Fatalf("could not invalidate: %v", err)
+	}
+
+	// the state built from the committed blocks alone, where the update of
+	// alice is no longer valid
+	committed := NewGenesisState("")
+	committed.IncorporateBlock(1, blocks[0][1:])
+	committed.IncorporateBlock(2, blocks[1])
+	committed.IncorporateBlock(3, blocks[2])
+	if followed.Epoch != 3 || followed.Checksum() != committed.Checksum() {
+		t.Fatal("invalidated state does not match the committed blocks")
+	}
+	if followed.HasMember(alice.token) || !followed.HasMember(carol.token) {
+		t.Fatal("unexpected members after invalidation")
+	}
+}
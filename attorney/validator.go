@@ -1,129 +1,573 @@
 package attorney
 
 import (
-	"fmt"
+	"log/slog"
 
 	"github.com/freehandle/breeze/crypto"
 )
 
+var _ ActionValidator = &MutatingState{}
+
+// MutatingState accumulates the mutations of validated actions on top of a
+// state. It implements ActionValidator.
 type MutatingState struct {
 	state     *State
 	mutations *Mutations
+	epoch     uint64 // epoch of the block being validated
+	base      int    // log entries of the mutations the validator was created with
+}
+
+// Epoch returns the epoch of the block being validated. Actions signed for an
+// epoch outside Epoch() ± ActionWindow are rejected. It is derived from the
+// blocks behind the validator, never from the epochs signed in actions.
+func (m *MutatingState) Epoch() uint64 {
+	return m.epoch
+}
+
+// SetEpoch advances the epoch of the validator to the epoch of the block
+// being validated, for callers that know it. The validator otherwise assumes
+// the block right after those it was created with.
+func (m *MutatingState) SetEpoch(epoch uint64) {
+	if epoch > m.epoch {
+		m.epoch = epoch
+	}
+}
+
+// IsRecent checks if an action with the given hash was accepted within the
+// validity window either in the pending mutations or in the state.
+func (m *MutatingState) IsRecent(hash crypto.Hash) bool {
+	if _, ok := m.mutations.Actions[hash]; ok {
+		return true
+	}
+	return m.state.IsRecent(hash)
+}
+
+// Atomic validates a group of actions all-or-nothing. The mutations of the
+// group are recorded on a clone of the pending mutations that replaces them
+// only if validate succeeds.
+func (m *MutatingState) Atomic(validate func(ActionValidator) error) error {
+	scratch := &MutatingState{state: m.state, mutations: m.mutations.Clone(), epoch: m.epoch, base: m.base}
+	if err := validate(scratch); err != nil {
+		return err
+	}
+	*m.mutations = *scratch.mutations
+	return nil
 }
 
+// Mutations returns the mutations of the actions accepted by the validator,
+// without those it was created with. They span the single block being
+// validated. On top of pending mutations only the log of the block is
+// returned, which is resolved against the state that incorporates it.
 func (m *MutatingState) Mutations() *Mutations {
-	return m.mutations
+	mutations := m.mutations
+	if m.base > 0 {
+		mutations = NewMutations()
+		mutations.Log = append(mutations.Log, m.mutations.Log[m.base:]...)
+		mutations.merged = true
+	}
+	mutations.Epoch = m.epoch
+	mutations.Blocks = 1
+	return mutations
 }
 
-func (s *MutatingState) SetNewGrantPower(token, attorney crypto.Token) bool {
-	join := append(token[:], attorney[:]...)
-	hash := crypto.Hasher(join)
+func (s *MutatingState) SetNewGrantPower(token, attorney crypto.Token, scope Scope, fingerprint []byte) bool {
+	hash := attorneyHash(token, attorney)
 	s.mutations.GrantPower[hash] = struct{}{}
+	s.mutations.GrantScope[hash] = scope
+	s.mutations.GrantFingerprint[hash] = fingerprint
+	s.mutations.Delegations[hash] = Delegation{Author: token, Attorney: attorney}
+	delete(s.mutations.RevokePower, hash)
 	return true
 }
 
 func (s *MutatingState) SetNewRevokePower(token, attorney crypto.Token) bool {
-	join := append(token[:], attorney[:]...)
-	hash := crypto.Hasher(join)
-	s.mutations.GrantPower[hash] = struct{}{}
+	hash := attorneyHash(token, attorney)
+	s.mutations.RevokePower[hash] = struct{}{}
+	s.mutations.Delegations[hash] = Delegation{Author: token, Attorney: attorney}
+	delete(s.mutations.GrantPower, hash)
+	delete(s.mutations.GrantScope, hash)
+	delete(s.mutations.GrantFingerprint, hash)
 	return true
 }
 
-func (s *MutatingState) SetNewMember(token crypto.Token, handle string) bool {
-	if (!s.HasHandle(handle)) && (!s.state.HasMember(token)) {
-		captionHash := crypto.Hasher([]byte(handle))
-		tokenHash := crypto.HashToken(token)
+// SetNewMember registers token as the owner of handle. A tombstoned handle
+// may only be claimed by its previous owner until the epoch of the validator
+// passes the tombstone. A reserved handle may only be claimed by its
+// authority. The join must carry the proof of work required for handle and
+// fit the cap of new members of the block.
+func (s *MutatingState) SetNewMember(token crypto.Token, handle string, work uint64) bool {
+	if s.IsRetired(token) || s.isPendingTarget(token) {
+		return false
+	}
+	handle, ok := CanonicalHandle(handle)
+	if !ok {
+		return false
+	}
+	if work < s.RequiredWork(handle) || !s.CanJoin() {
+		return false
+	}
+	tokenHash := crypto.HashToken(token)
+	if tombstone, ok := s.TombstoneOf(handle); ok && !tombstone.Allows(token, s.epoch) {
+		return false
+	}
+	if authority, ok := s.state.ReservedFor(handle); ok && (authority.Equal(crypto.ZeroToken) || !authority.Equal(token)) {
+		return false
+	}
+	if (!s.HasHandle(handle)) && (!s.state.HasMember(token)) && (!s.mutations.HasMember(tokenHash)) {
 		s.mutations.NewMembers[tokenHash] = struct{}{}
-		s.mutations.NewCaption[captionHash] = struct{}{}
+		s.mutations.NewCaption[crypto.Hasher([]byte(handle))] = struct{}{}
+		s.mutations.NewHandles[token] = handle
+		s.mutations.Joins[s.epoch]++
+		return true
+	}
+	return false
+}
+
+// RequiredWork returns the proof of work required to claim handle.
+func (s *MutatingState) RequiredWork(handle string) uint64 {
+	if canonical, ok := CanonicalHandle(handle); ok {
+		handle = canonical
+	}
+	return s.state.RequiredWork(handle)
+}
+
+// IsWorkSeed checks if a join may bind its work to seed: either the latest
+// work seed or one produced by an action still within the validity window,
+// considering the actions of pending blocks.
+func (s *MutatingState) IsWorkSeed(seed crypto.Hash) bool {
+	if epoch, ok := s.state.seedEpoch(seed); ok && !IsStale(epoch, s.epoch) {
+		return true
+	}
+	latest := s.state.WorkSeed()
+	for _, entry := range s.mutations.Log[:s.base] {
+		latest = nextSeed(latest, entry.Hashes[0])
+		if seed == latest && !IsStale(entry.Epoch, s.epoch) {
+			return true
+		}
+	}
+	return seed == latest
+}
+
+// WorkSeed returns the latest work seed considering the actions of pending
+// blocks.
+func (s *MutatingState) WorkSeed() crypto.Hash {
+	seed := s.state.WorkSeed()
+	for _, entry := range s.mutations.Log[:s.base] {
+		seed = nextSeed(seed, entry.Hashes[0])
+	}
+	return seed
+}
+
+// CanJoin checks if one more member may join on the block being validated.
+func (s *MutatingState) CanJoin() bool {
+	max := s.state.parameter(maxJoinsKey)
+	return max == 0 || s.state.JoinsAt(s.epoch)+s.mutations.Joins[s.epoch] < max
+}
+
+// SetNewRotateKey retires old in favour of token. The new token becomes a
+// member only once the mutations are incorporated.
+func (s *MutatingState) SetNewRotateKey(old, token crypto.Token) bool {
+	if !s.HasMember(old) || !s.canReceive(token) {
+		return false
+	}
+	s.mutations.RotateKeys[old] = token
+	return true
+}
+
+// IsRetired checks if token was replaced by a key rotation either in the
+// pending mutations or in the underlying state.
+func (s *MutatingState) IsRetired(token crypto.Token) bool {
+	if _, ok := s.mutations.RotateKeys[token]; ok {
 		return true
 	}
+	return s.state.IsRetired(token)
+}
+
+// isPendingTarget checks if token receives a membership by a rotation or a
+// transfer within these mutations.
+func (s *MutatingState) isPendingTarget(token crypto.Token) bool {
+	for _, target := range s.mutations.RotateKeys {
+		if target.Equal(token) {
+			return true
+		}
+	}
+	for _, target := range s.mutations.Transfers {
+		if target.Equal(token) {
+			return true
+		}
+	}
 	return false
 }
 
+// canReceive checks if token may become a member by rotation, recovery or
+// transfer.
+func (s *MutatingState) canReceive(token crypto.Token) bool {
+	return !s.HasMember(token) && !s.IsRetired(token) && !s.isPendingTarget(token)
+}
+
+// SetNewTransfer hands the handle of author over to recipient.
+func (s *MutatingState) SetNewTransfer(author crypto.Token, handle string, recipient crypto.Token) bool {
+	if !s.HasMember(author) || !s.canReceive(recipient) {
+		return false
+	}
+	if owned, ok := s.HandleOf(author); !ok || owned != handle {
+		return false
+	}
+	// a handle transferred again goes straight from its first owner to the
+	// last recipient
+	from := author
+	for first, to := range s.mutations.Transfers {
+		if to.Equal(author) {
+			from = first
+			break
+		}
+	}
+	if from.Equal(recipient) {
+		delete(s.mutations.Transfers, from)
+	} else {
+		s.mutations.Transfers[from] = recipient
+	}
+	return true
+}
+
+// SetNewGuardians replaces the guardians of token.
+func (s *MutatingState) SetNewGuardians(token crypto.Token, guardians Guardians) bool {
+	s.mutations.NewGuardians[token] = guardians
+	return true
+}
+
+// GuardiansOf returns the guardians of token considering both pending
+// mutations and the underlying state.
+func (s *MutatingState) GuardiansOf(token crypto.Token) (Guardians, bool) {
+	if guardians, ok := s.mutations.NewGuardians[token]; ok {
+		return guardians, true
+	}
+	return s.state.GuardiansOf(token)
+}
+
+// PendingRecovery returns the pending recovery of token considering both
+// pending mutations and the underlying state.
+func (s *MutatingState) PendingRecovery(token crypto.Token) (Recovery, bool) {
+	if _, ok := s.mutations.CancelRecoveries[token]; ok {
+		return Recovery{}, false
+	}
+	if recovery, ok := s.mutations.NewRecoveries[token]; ok {
+		return recovery, true
+	}
+	return s.state.PendingRecovery(token)
+}
+
+// recoveryChanged checks if the pending recovery of token was already
+// requested or cancelled within these mutations. It changes at most once per
+// mutations.
+func (s *MutatingState) recoveryChanged(token crypto.Token) bool {
+	_, requested := s.mutations.NewRecoveries[token]
+	_, cancelled := s.mutations.CancelRecoveries[token]
+	return requested || cancelled
+}
+
+// SetNewRecovery starts the recovery of author to token if enough of its
+// guardians signed the request and there is no other pending recovery. The
+// recovery is due the delay of the guardians after the epoch of the
+// validator.
+func (s *MutatingState) SetNewRecovery(request *RequestRecovery) bool {
+	if s.recoveryChanged(request.Author) {
+		return false
+	}
+	if _, ok := s.PendingRecovery(request.Author); ok {
+		return false
+	}
+	if !s.canReceive(request.Token) {
+		return false
+	}
+	guardians, ok := s.GuardiansOf(request.Author)
+	if !ok {
+		return false
+	}
+	signed := 0
+	for _, guardian := range request.Guardians() {
+		if guardians.Has(guardian) {
+			signed++
+		}
+	}
+	if signed < int(guardians.Threshold) {
+		return false
+	}
+	s.mutations.NewRecoveries[request.Author] = Recovery{Token: request.Token, Due: s.epoch + guardians.Delay}
+	return true
+}
+
+// SetCancelRecovery cancels the pending recovery of token.
+func (s *MutatingState) SetCancelRecovery(token crypto.Token) bool {
+	if s.recoveryChanged(token) {
+		return false
+	}
+	if _, ok := s.PendingRecovery(token); !ok {
+		return false
+	}
+	s.mutations.CancelRecoveries[token] = struct{}{}
+	return true
+}
+
+// SetCompleteRecovery rotates author to token if it matches a pending
+// recovery that is due at the epoch of the validator.
+func (s *MutatingState) SetCompleteRecovery(author, token crypto.Token) bool {
+	if s.recoveryChanged(author) {
+		return false
+	}
+	recovery, ok := s.PendingRecovery(author)
+	if !ok || !recovery.Token.Equal(token) || s.epoch < recovery.Due {
+		return false
+	}
+	return s.SetNewRotateKey(author, token)
+}
+
+// SetNewRelease frees the handle of author and tombstones it from the epoch
+// of the validator on.
+func (s *MutatingState) SetNewRelease(author crypto.Token, handle string) bool {
+	if !s.HasMember(author) || s.recoveryChanged(author) {
+		return false
+	}
+	if owned, ok := s.HandleOf(author); !ok || owned != handle {
+		return false
+	}
+	skeleton, ok := skeletonHash(handle)
+	if !ok {
+		return false
+	}
+	s.mutations.Releases[skeleton] = Tombstone{Owner: author, Until: s.epoch + s.state.parameter(releaseCooldownKey)}
+	// a handle claimed earlier within the mutations and released now was
+	// never part of the state
+	if claimed, ok := s.mutations.NewHandles[author]; ok {
+		delete(s.mutations.NewMembers, crypto.HashToken(author))
+		delete(s.mutations.NewCaption, crypto.Hasher([]byte(claimed)))
+		delete(s.mutations.NewHandles, author)
+		delete(s.mutations.NewProfiles, author)
+	}
+	return true
+}
+
+// TombstoneOf returns the tombstone of handle considering both pending
+// mutations and the underlying state.
+func (s *MutatingState) TombstoneOf(handle string) (Tombstone, bool) {
+	if skeleton, ok := skeletonHash(handle); ok {
+		if tombstone, ok := s.mutations.Releases[skeleton]; ok {
+			return tombstone, true
+		}
+	}
+	return s.state.TombstoneOf(handle)
+}
+
+func (s *MutatingState) isReleasing(token crypto.Token) bool {
+	for _, tombstone := range s.mutations.Releases {
+		if tombstone.Owner.Equal(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetNewCollective registers the collective controlling the new member token.
+func (s *MutatingState) SetNewCollective(token crypto.Token, collective Collective) bool {
+	if _, ok := s.CollectiveOf(token); ok {
+		return false
+	}
+	s.mutations.NewCollectives[token] = collective
+	return true
+}
+
+// CollectiveOf returns the collective controlling token considering both
+// pending mutations and the underlying state.
+func (s *MutatingState) CollectiveOf(token crypto.Token) (Collective, bool) {
+	if collective, ok := s.mutations.NewCollectives[token]; ok {
+		return collective, true
+	}
+	return s.state.CollectiveOf(token)
+}
+
+// SetNewProfile replaces the profile details of token.
+func (s *MutatingState) SetNewProfile(token crypto.Token, details string) bool {
+	s.mutations.NewProfiles[token] = details
+	return true
+}
+
+// PowerOfAttorney checks if attorney holds a power of attorney of token,
+// whatever its scope. Every token is an attorney of itself.
 func (s *MutatingState) PowerOfAttorney(token, attorney crypto.Token) bool {
 	if token.Equal(attorney) {
 		return true
 	}
 	join := append(token[:], attorney[:]...)
 	hash := crypto.Hasher(join)
-	_, ok := s.mutations.GrantPower[hash]
-	return ok || s.state.Attorneys.ExistsHash(hash)
+	if _, ok := s.mutations.GrantPower[hash]; ok {
+		return true
+	}
+	if _, ok := s.mutations.RevokePower[hash]; ok {
+		return false
+	}
+	return s.state.Attorneys.ExistsHash(hash)
+}
+
+// ScopeOf returns the scope of the power of attorney granted by token to
+// attorney considering both pending mutations and the underlying state.
+func (s *MutatingState) ScopeOf(token, attorney crypto.Token) (Scope, bool) {
+	hash := attorneyHash(token, attorney)
+	if _, ok := s.mutations.GrantPower[hash]; ok {
+		return s.mutations.GrantScope[hash], true
+	}
+	if _, ok := s.mutations.RevokePower[hash]; ok {
+		return Scope{}, false
+	}
+	return s.state.ScopeOf(token, attorney)
+}
+
+// PowerOfAttorneyFor checks if attorney may sign an action of kind (and
+// protocol, for void actions) on behalf of token. The expiry of the scope is
+// checked against the epoch of the validator, not the epoch of the action.
+func (s *MutatingState) PowerOfAttorneyFor(token, attorney crypto.Token, kind byte, protocol uint32) bool {
+	if token.Equal(attorney) {
+		return true
+	}
+	if s.IsRetired(attorney) {
+		return false
+	}
+	scope, ok := s.ScopeOf(token, attorney)
+	return ok && scope.Allows(kind, protocol, s.epoch)
 }
 
 func (s *MutatingState) HasMember(token crypto.Token) bool {
+	if _, ok := s.mutations.RotateKeys[token]; ok {
+		return false
+	}
+	if _, ok := s.mutations.Transfers[token]; ok {
+		return false
+	}
+	if s.isReleasing(token) {
+		return false
+	}
 	hash := crypto.HashToken(token)
 	_, ok := s.mutations.NewMembers[hash]
 	return ok || s.state.Members.ExistsHash(hash)
 }
 
+// HasHandle checks if handle, or a handle confusable with it, is taken
+// considering both pending mutations and the underlying state.
 func (s *MutatingState) HasHandle(handle string) bool {
-	hash := crypto.Hasher([]byte(handle))
-	_, ok := s.mutations.NewCaption[hash]
-	return ok || s.state.Captions.ExistsHash(hash)
+	canonical, ok := CanonicalHandle(handle)
+	if !ok {
+		return false
+	}
+	if _, ok := s.mutations.NewCaption[crypto.Hasher([]byte(canonical))]; ok {
+		return true
+	}
+	skeleton := HandleSkeleton(canonical)
+	for _, claimed := range s.mutations.NewHandles {
+		if HandleSkeleton(claimed) == skeleton {
+			return true
+		}
+	}
+	return s.state.HasHandle(canonical)
 }
 
-func (v *MutatingState) Validate(data []byte) bool {
-	kind := Kind(data)
-	if kind == Invalid {
-		fmt.Println("axe node: invalid kind", data)
-		return false
-	}
-	var ok bool
-	switch kind {
-	case JoinNetworkType:
-		join := ParseJoinNetwork(data)
-		if join != nil {
-			ok = v.SetNewMember(join.Author, join.Handle)
-			//fmt.Printf("axe node %v:%+v\n", ok, *join)
-		} else {
-			//fmt.Printf("axe node: could not parse join\n %v\n", data)
-		}
-	case UpdateInfoType:
-		update := ParseUpdateInfo(data)
-		if update != nil {
-			ok = v.PowerOfAttorney(update.Author, update.Signer)
-			if ok {
-				ok = v.HasMember(update.Author)
-			}
-			//fmt.Printf("axe node %v:%+v\n", ok, *update)
-		} else {
-			//fmt.Printf("axe node %v: could not parse update\n", ok)
-		}
-	case GrantPowerOfAttorneyType:
-		grant := ParseGrantPowerOfAttorney(data)
-		if grant != nil {
-			ok = v.HasMember(grant.Author)
-			if ok {
-				ok = v.SetNewGrantPower(grant.Author, grant.Attorney)
-			}
-			//fmt.Printf("axe node %v:%+v\n", ok, *grant)
-		} else {
-			//fmt.Printf("axe node %v: could not parse grant\n", ok)
-		}
-	case RevokePowerOfAttorneyType:
-		revoke := ParseRevokePowerOfAttorney(data)
-		if revoke != nil {
-			ok = v.HasMember(revoke.Author)
-			if ok {
-				ok = v.SetNewRevokePower(revoke.Author, revoke.Attorney)
-			}
-			//fmt.Printf("axe node revoke %v:%+v\n", ok, *revoke)
-		} else {
-			//fmt.Printf("axe node %v: could not parse revoke\n", ok)
-		}
-	case VoidType:
-		fmt.Println("void", data)
-		void := ParseVoid(data)
-		if void != nil {
-			fmt.Println(void)
-			ok = v.HasMember(void.Author)
-			if ok {
-				ok = v.PowerOfAttorney(void.Author, void.Signer)
-			}
-			fmt.Printf("axe node void %v:%+v\n", ok, *void)
-		} else {
-			//fmt.Printf("axe node %v: could not parse void\n", ok)
+// TokenOf returns the owner of handle considering both pending mutations and
+// the underlying state.
+func (s *MutatingState) TokenOf(handle string) (crypto.Token, bool) {
+	handle, ok := CanonicalHandle(handle)
+	if !ok {
+		return crypto.ZeroToken, false
+	}
+	for token, claimed := range s.mutations.NewHandles {
+		if claimed == handle {
+			return token, true
 		}
 	}
-	return ok
+	return s.state.TokenOf(handle)
+}
+
+// HandleOf returns the handle of token considering both pending mutations and
+// the underlying state.
+func (s *MutatingState) HandleOf(token crypto.Token) (string, bool) {
+	if handle, ok := s.mutations.HandleOf(token); ok {
+		return handle, true
+	}
+	return s.state.HandleOf(token)
+}
+
+// Profile returns the latest details of token considering both pending
+// mutations and the underlying state.
+func (s *MutatingState) Profile(token crypto.Token) (string, bool) {
+	if details, ok := s.mutations.Profile(token); ok {
+		return details, true
+	}
+	return s.state.Profile(token)
+}
+
+// Validate checks an action against the state and the mutations accepted so
+// far and, if valid, records its mutations. It implements the breeze validator
+// interface on top of ValidateAction.
+func (v *MutatingState) Validate(data []byte) bool {
+	if err := v.ValidateAction(data); err != nil {
+		slog.Debug("MutatingState.Validate: action rejected", "reason", err, "kind", Kind(data))
+		return false
+	}
+	return true
+}
+
+// ValidateAction is like Validate but returns the reason an action was
+// rejected, or nil if it was accepted. Protocol rules are implemented by the
+// Validate method of each action.
+func (v *MutatingState) ValidateAction(data []byte) error {
+	if Kind(data) == Invalid {
+		return ErrUnknownKind
+	}
+	epoch := ActionEpoch(data)
+	if IsStale(epoch, v.epoch) {
+		return ErrStaleEpoch
+	}
+	if IsFuture(epoch, v.epoch) {
+		return ErrFutureEpoch
+	}
+	action, bare, err := parseAction(data)
+	if err != nil {
+		return err
+	}
+	hash := crypto.Hasher(bare)
+	if v.IsRecent(hash) {
+		return ErrReplayed
+	}
+	entry := LogEntry{Epoch: epoch, Hashes: []crypto.Hash{hash}, action: action}
+	if batch, ok := action.(*Batch); ok {
+		entry.Hashes = append(entry.Hashes, batch.Hashes()...)
+	}
+	return v.accept(entry)
+}
+
+// revalidate validates again the action of entry, accepted by another
+// validator, at the epoch it was accepted. Signatures were checked when the
+// action was parsed and are not checked again.
+func (v *MutatingState) revalidate(entry LogEntry) error {
+	v.epoch = entry.block
+	if IsStale(entry.Epoch, v.epoch) {
+		return ErrStaleEpoch
+	}
+	if IsFuture(entry.Epoch, v.epoch) {
+		return ErrFutureEpoch
+	}
+	if v.IsRecent(entry.Hashes[0]) {
+		return ErrReplayed
+	}
+	return v.accept(entry)
+}
+
+// accept validates the parsed action of entry and, if valid, logs it.
+func (v *MutatingState) accept(entry LogEntry) error {
+	if err := entry.action.Validate(v); err != nil {
+		return err
+	}
+	entry.block = v.epoch
+	for _, hash := range entry.Hashes {
+		v.mutations.Actions[hash] = entry.Epoch
+	}
+	v.mutations.Log = append(v.mutations.Log, entry)
+	return nil
 }
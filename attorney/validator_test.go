@@ -0,0 +1,125 @@
+package attorney
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freehandle/breeze/crypto"
+)
+
+// mockValidator implements the part of ActionValidator used by joins and
+// grants of single members. Other methods panic.
+type mockValidator struct {
+	ActionValidator
+	members   map[crypto.Token]string
+	attorneys map[[2]crypto.Token]bool
+	profiles  map[crypto.Token]string
+}
+
+func newMockValidator() *mockValidator {
+	return &mockValidator{
+		members:   make(map[crypto.Token]string),
+		attorneys: make(map[[2]crypto.Token]bool),
+		profiles:  make(map[crypto.Token]string),
+	}
+}
+
+func (m *mockValidator) HasMember(token crypto.Token) bool {
+	_, ok := m.members[token]
+	return ok
+}
+
+func (m *mockValidator) HasHandle(handle string) bool {
+	for _, taken := range m.members {
+		if taken == handle {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *mockValidator) IsRetired(crypto.Token) bool      { return false }
+func (m *mockValidator) RequiredWork(string) uint64       { return 0 }
+func (m *mockValidator) CanJoin() bool                    { return true }
+func (m *mockValidator) IsWorkSeed(seed crypto.Hash) bool { return true }
+
+func (m *mockValidator) SetNewMember(token crypto.Token, handle string, work uint64) bool {
+	m.members[token] = handle
+	return true
+}
+
+func (m *mockValidator) SetNewProfile(token crypto.Token, details string) bool {
+	m.profiles[token] = details
+	return true
+}
+
+func (m *mockValidator) PowerOfAttorney(token, attorney crypto.Token) bool {
+	return token.Equal(attorney) || m.attorneys[[2]crypto.Token{token, attorney}]
+}
+
+func (m *mockValidator) SetNewGrantPower(token, attorney crypto.Token, scope Scope, fingerprint []byte) bool {
+	m.attorneys[[2]crypto.Token{token, attorney}] = true
+	return true
+}
+
+func (m testMember) joinWithDetails(epoch uint64, handle, details string) []byte {
+	join := JoinNetwork{Epoch: epoch, Author: m.token, Handle: handle, Details: details}
+	join.Sign(m.key)
+	return join.Serialize()
+}
+
+func TestJoinNetworkValidate(t *testing.T) {
+	alice, bob := newTestMember(), newTestMember()
+	v := newMockValidator()
+	if err := ParseAction(alice.joinWithDetails(1, "alice", `{"a":1}`)).Validate(v); err != nil {
+		t.Fatalf("join rejected: %v", err)
+	}
+	if v.members[alice.token] != "alice" || v.profiles[alice.token] != `{"a":1}` {
+		t.Fatal("join did not record the member and its profile")
+	}
+	rejected := map[error]Action{
+		ErrMalformedJSON: &JoinNetwork{Epoch: 1, Author: bob.token, Handle: "bob", Details: `{"a":`},
+		ErrConflict:      ParseAction(alice.join(1, "alice2")),
+		ErrHandleTaken:   ParseAction(bob.join(1, "alice")),
+	}
+	for expected, action := range rejected {
+		if err := action.Validate(v); !errors.Is(err, expected) {
+			t.Fatalf("expected %v, got %v", expected, err)
+		}
+	}
+	if v.HasMember(bob.token) {
+		t.Fatal("rejected join recorded a member")
+	}
+}
+
+func TestGrantPowerOfAttorneyValidate(t *testing.T) {
+	alice, attorney := newTestMember(), newTestMember()
+	v := newMockValidator()
+	if err := ParseAction(alice.grant(1, attorney, Scope{})).Validate(v); !errors.Is(err, ErrNotMember) {
+		t.Fatalf("expected grant of a non member rejected, got %v", err)
+	}
+	v.members[alice.token] = "alice"
+	if err := ParseAction(alice.grant(1, attorney, Scope{})).Validate(v); err != nil {
+		t.Fatalf("grant rejected: %v", err)
+	}
+	if err := ParseAction(alice.grant(2, attorney, Scope{})).Validate(v); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected duplicate grant rejected, got %v", err)
+	}
+}
+
+// The rules of each action are the rules of the live path.
+func TestMutatingStateDispatch(t *testing.T) {
+	alice, bob, attorney := newTestMember(), newTestMember(), newTestMember()
+	state := NewGenesisState("")
+	incorporate(t, state, 1, alice.join(1, "alice"), alice.grant(1, attorney, Scope{}))
+	validator := state.Validator()
+	if validator.Epoch() != 2 {
+		t.Fatalf("validator at epoch %v", validator.Epoch())
+	}
+	if err := validator.ValidateAction(alice.grant(2, attorney, Scope{})); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected duplicate grant rejected, got %v", err)
+	}
+	if err := validator.ValidateAction(bob.joinWithDetails(2, "bob", `not json`)); !errors.Is(err, ErrMalformedJSON) {
+		t.Fatalf("expected malformed details rejected, got %v", err)
+	}
+}
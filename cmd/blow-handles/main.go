@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/freehandle/breeze/consensus/chain"
+	"github.com/freehandle/breeze/consensus/messages"
 	"github.com/freehandle/breeze/crypto"
 	"github.com/freehandle/breeze/middleware/config"
 	"github.com/freehandle/breeze/middleware/social"
@@ -38,6 +39,34 @@ type HandleConfig struct {
 	Genesis bool // `json:"genesis"`
 	// Trusted peers for the node to sync state
 	TrustedPeers []config.Peer // `json:"trustedPeers"`
+	// Epochs a released handle is kept for its previous owner (genesis only,
+	// zero for the default)
+	ReleaseCooldown uint64 // `json:"releaseCooldown"`
+	// Reserved handles (genesis only)
+	Reserved []ReservedConfig // `json:"reserved"`
+	// New members per block (genesis only, zero for no limit)
+	MaxJoinsPerEpoch uint64 // `json:"maxJoinsPerEpoch"`
+	// Bits of proof of work to join the network (genesis only, zero for none)
+	JoinWork uint64 // `json:"joinWork"`
+	// Extra bits of proof of work per character of handles shorter than
+	// attorney.WorkHandleLength (genesis only)
+	JoinWorkStep uint64 // `json:"joinWorkStep"`
+	// Directory for state snapshots (empty for no snapshots)
+	SnapshotPath string // `json:"snapshotPath"`
+	// Epochs between snapshots
+	SnapshotEvery uint64 // `json:"snapshotEvery"`
+	// Number of snapshots to keep (zero for all)
+	SnapshotKeep int // `json:"snapshotKeep"`
+	// Expected number of items of each hash vault (members, captions,
+	// attorneys, retired, skeletons) to size new vaults up front
+	VaultItems map[string]int64 // `json:"vaultItems"`
+}
+
+type ReservedConfig struct {
+	// Reserved handle
+	Handle string // `json:"handle"`
+	// Token that may claim the handle (empty for no one)
+	Authority string // `json:"authority"`
 }
 
 func (c HandleConfig) Check() error {
@@ -66,6 +95,40 @@ func (c HandleConfig) Check() error {
 	if (!c.Genesis) && len(c.TrustedPeers) == 0 {
 		return fmt.Errorf("no trusted peers for non-genesis node")
 	}
+	for _, reserved := range c.Reserved {
+		if _, ok := attorney.CanonicalHandle(reserved.Handle); !ok {
+			return fmt.Errorf("invalid reserved handle: %v", reserved.Handle)
+		}
+		if reserved.Authority != "" && crypto.TokenFromString(reserved.Authority) == crypto.ZeroToken {
+			return fmt.Errorf("invalid authority for reserved handle %v", reserved.Handle)
+		}
+	}
+	if c.JoinWork+c.JoinWorkStep*(attorney.WorkHandleLength-1) > 8*crypto.Size {
+		return fmt.Errorf("join work %v with step %v exceeds %v bits", c.JoinWork, c.JoinWorkStep, 8*crypto.Size)
+	}
+	if c.SnapshotPath != "" {
+		if c.SnapshotEvery == 0 {
+			return fmt.Errorf("no snapshot interval for snapshot path %v", c.SnapshotPath)
+		}
+		if stat, err := os.Stat(c.SnapshotPath); err != nil || !stat.IsDir() {
+			return fmt.Errorf("invalid snapshot path: %v", c.SnapshotPath)
+		}
+	}
+	if c.SnapshotKeep < 0 {
+		return fmt.Errorf("invalid snapshot keep: %v", c.SnapshotKeep)
+	}
+	vaults := make(map[string]struct{})
+	for _, name := range attorney.HashVaultNames() {
+		vaults[name] = struct{}{}
+	}
+	for name, items := range c.VaultItems {
+		if _, ok := vaults[name]; !ok {
+			return fmt.Errorf("unknown hash vault: %v", name)
+		}
+		if items < 0 {
+			return fmt.Errorf("invalid expected items for vault %v: %v", name, items)
+		}
+	}
 	return nil
 }
 
@@ -89,22 +152,145 @@ func HandleConfigToConfig(hdl *HandleConfig, pk crypto.PrivateKey) Config {
 			ProvidersSize:      hdl.ProvidersSize,
 			MaxCheckpointLag:   10,
 		},
-		Genesis:      hdl.Genesis,
-		TurstedPeers: config.PeersToTokenAddr(hdl.TrustedPeers),
-		NotaryPath:   hdl.NotaryPath,
+		Genesis:       hdl.Genesis,
+		TurstedPeers:  config.PeersToTokenAddr(hdl.TrustedPeers),
+		NotaryPath:    hdl.NotaryPath,
+		Parameters:    attorney.DefaultParameters,
+		SnapshotPath:  hdl.SnapshotPath,
+		SnapshotEvery: hdl.SnapshotEvery,
+		SnapshotKeep:  hdl.SnapshotKeep,
+		VaultItems:    hdl.VaultItems,
+	}
+	if hdl.ReleaseCooldown > 0 {
+		cfg.Parameters.ReleaseCooldown = hdl.ReleaseCooldown
+	}
+	for _, reserved := range hdl.Reserved {
+		authority := crypto.ZeroToken
+		if reserved.Authority != "" {
+			authority = crypto.TokenFromString(reserved.Authority)
+		}
+		cfg.Parameters.Reserved = append(cfg.Parameters.Reserved, attorney.ReservedHandle{Handle: reserved.Handle, Authority: authority})
 	}
+	cfg.Parameters.MaxJoinsPerEpoch = hdl.MaxJoinsPerEpoch
+	cfg.Parameters.JoinWork = hdl.JoinWork
+	cfg.Parameters.JoinWorkStep = hdl.JoinWorkStep
 	return cfg
 }
 
 type Config struct {
-	Node         social.Configuration
-	Genesis      bool
-	TurstedPeers []socket.TokenAddr
-	NotaryPath   string
+	Node          social.Configuration
+	Genesis       bool
+	TurstedPeers  []socket.TokenAddr
+	NotaryPath    string
+	Parameters    attorney.Parameters
+	SnapshotPath  string
+	SnapshotEvery uint64
+	SnapshotKeep  int
+	VaultItems    attorney.VaultItems
+}
+
+// scheduleSnapshots makes state write periodic snapshots if configured.
+func scheduleSnapshots(cfg Config, state *attorney.State) {
+	if cfg.SnapshotPath != "" {
+		state.SnapshotEvery(cfg.SnapshotPath, cfg.SnapshotEvery, cfg.SnapshotKeep)
+	}
+}
+
+// checkpoint is the epoch and state checksum of the last checkpoint of a
+// trusted peer.
+type checkpoint struct {
+	epoch uint64
+	hash  crypto.Hash
+}
+
+// trustedCheckpoints asks every reachable trusted peer for its checkpoint. A
+// peer answers a sync request with its checkpoint before its state, so the
+// connection is dropped once the checkpoint is read.
+func trustedCheckpoints(cfg Config) []checkpoint {
+	checkpoints := make([]checkpoint, 0)
+	for _, peer := range cfg.TurstedPeers {
+		addr := fmt.Sprintf("%v:%v", peer.Addr, cfg.Node.BlocksSourcePort)
+		conn, err := socket.Dial(cfg.Node.Hostname, addr, cfg.Node.Credentials, peer.Token)
+		if err != nil {
+			continue
+		}
+		if msg, err := conn.Read(); err != nil || len(msg) == 0 || msg[0] != messages.MsgClockSync {
+			conn.Shutdown()
+			continue
+		}
+		conn.Send([]byte{messages.MsgProtocolSyncReq})
+		msg, err := conn.Read()
+		conn.Shutdown()
+		if err != nil || len(msg) != 1+8+2*crypto.Size || msg[0] != messages.MsgProtocolChecksumSync {
+			continue
+		}
+		point := checkpoint{}
+		point.epoch, _ = util.ParseUint64(msg, 1)
+		point.hash, _ = util.ParseHash(msg, 1+8+crypto.Size)
+		checkpoints = append(checkpoints, point)
+	}
+	return checkpoints
+}
+
+// verifyCheckpoint checks state against the checkpoints of trusted peers.
+// At least one of them must be at the epoch of the state, and every one at
+// that epoch must agree with its checksum. With a single trusted peer, the
+// state it sent is only checked against its own word.
+func verifyCheckpoint(cfg Config, state *attorney.State) error {
+	hash := state.Checksum()
+	matched := false
+	for _, point := range trustedCheckpoints(cfg) {
+		if point.epoch != state.Epoch {
+			continue
+		}
+		if !point.hash.Equal(hash) {
+			return fmt.Errorf("state checksum at epoch %v does not match a trusted checkpoint", state.Epoch)
+		}
+		matched = true
+	}
+	if !matched {
+		return fmt.Errorf("no trusted checkpoint at epoch %v", state.Epoch)
+	}
+	return nil
+}
+
+// verifySnapshot checks a state booted from a snapshot, whose header is only
+// its own word. With a checksum given on the command line, obtained from a
+// trusted source, the state must match it. Otherwise it must match the
+// checkpoints of trusted peers.
+func verifySnapshot(cfg Config, state *attorney.State, args []string) error {
+	if len(args) == 0 {
+		return verifyCheckpoint(cfg, state)
+	}
+	if !crypto.DecodeHash(args[0]).Equal(state.Checksum()) {
+		return fmt.Errorf("state checksum at epoch %v does not match %v", state.Epoch, args[0])
+	}
+	return nil
+}
+
+// stateFromBytes recreates the state synced from trusted peers and schedules
+// its snapshots. The state must match the checkpoints of trusted peers.
+func stateFromBytes(cfg Config) social.StateFromBytes[*attorney.Mutations, *attorney.MutatingState] {
+	fromBytes := attorney.NewStateFromBytes(cfg.NotaryPath)
+	return func(data []byte) (social.Stateful[*attorney.Mutations, *attorney.MutatingState], bool) {
+		synced, ok := fromBytes(data)
+		if !ok {
+			return nil, false
+		}
+		state := synced.(*attorney.State)
+		if err := verifyCheckpoint(cfg, state); err != nil {
+			fmt.Printf("rejected state from trusted peers: %v\n", err)
+			state.Discard()
+			return nil, false
+		}
+		scheduleSnapshots(cfg, state)
+		return state, true
+	}
 }
 
 func launchGenesis(ctx context.Context, cfg Config) chan error {
-	genesis := attorney.NewGenesisState(cfg.NotaryPath)
+	genesis := attorney.NewSizedGenesisState(cfg.NotaryPath, cfg.Parameters, cfg.VaultItems)
+	scheduleSnapshots(cfg, genesis)
 	bytes := []byte{}
 	util.PutUint32(cfg.Node.NodeProtocolCode, &bytes)
 	util.PutUint32(cfg.Node.ParentProtocolCode, &bytes)
@@ -114,7 +300,7 @@ func launchGenesis(ctx context.Context, cfg Config) chan error {
 		Epoch:         0,
 		State:         genesis,
 		LastBlockHash: genesisHash,
-		Hash:          genesisHash,
+		Hash:          hash,
 	}
 	clock := chain.ClockSyncronization{
 		Epoch:     0,
@@ -123,6 +309,49 @@ func launchGenesis(ctx context.Context, cfg Config) chan error {
 	return social.LaunchNodeFromState[*attorney.Mutations, *attorney.MutatingState](ctx, cfg.Node, checksum, clock)
 }
 
+// syncClock obtains the clock synchronization from the first reachable
+// trusted peer.
+func syncClock(cfg Config) (chain.ClockSyncronization, error) {
+	for _, peer := range cfg.TurstedPeers {
+		addr := fmt.Sprintf("%v:%v", peer.Addr, cfg.Node.BlocksSourcePort)
+		conn, err := socket.Dial(cfg.Node.Hostname, addr, cfg.Node.Credentials, peer.Token)
+		if err != nil {
+			continue
+		}
+		msg, err := conn.Read()
+		conn.Shutdown()
+		if err != nil || len(msg) < 17 || msg[0] != messages.MsgClockSync {
+			continue
+		}
+		clock := chain.ClockSyncronization{}
+		position := 1
+		clock.Epoch, position = util.ParseUint64(msg, position)
+		clock.TimeStamp, _ = util.ParseTime(msg, position)
+		return clock, nil
+	}
+	return chain.ClockSyncronization{}, errors.New("could not get clock sync from trusted peers")
+}
+
+// launchRecovery resumes a node from the state recovered from its notary
+// path or booted from a snapshot. The node continues from the last epoch
+// incorporated into the state.
+func launchRecovery(ctx context.Context, cfg Config, state *attorney.State) chan error {
+	scheduleSnapshots(cfg, state)
+	clock, err := syncClock(cfg)
+	if err != nil {
+		state.Shutdown()
+		finalize := make(chan error, 1)
+		finalize <- err
+		return finalize
+	}
+	checksum := &social.Checksum[*attorney.Mutations, *attorney.MutatingState]{
+		Epoch: state.Epoch,
+		State: state,
+		Hash:  state.Checksum(),
+	}
+	return social.LaunchNodeFromState[*attorney.Mutations, *attorney.MutatingState](ctx, cfg.Node, checksum, clock)
+}
+
 func main() {
 	specs, err := config.LoadConfig[HandleConfig](os.Args[1])
 	if err != nil || specs == nil {
@@ -150,10 +379,35 @@ func main() {
 	cfg := HandleConfigToConfig(specs, secret)
 
 	var finalize chan error
-	if cfg.Genesis {
+	if len(os.Args) > 2 {
+		state, err := attorney.OpenSnapshot(os.Args[2], cfg.NotaryPath)
+		if err != nil {
+			fmt.Printf("could not boot from snapshot: %v\n", err)
+			cancel()
+			os.Exit(1)
+		}
+		if err := verifySnapshot(cfg, state, os.Args[3:]); err != nil {
+			fmt.Printf("could not boot from snapshot: %v\n", err)
+			state.Discard()
+			cancel()
+			os.Exit(1)
+		}
+		fmt.Printf("state booted from snapshot at epoch %v\n", state.Epoch)
+		finalize = launchRecovery(ctx, cfg, state)
+	} else if cfg.Genesis {
 		finalize = launchGenesis(ctx, cfg)
 	} else {
-		finalize = social.LaunchSyncNode(ctx, cfg.Node, cfg.TurstedPeers, attorney.NewStateFromBytes(cfg.NotaryPath))
+		if cfg.NotaryPath != "" && attorney.HasPersistedState(cfg.NotaryPath) {
+			if state, err := attorney.RecoverState(cfg.NotaryPath); err != nil {
+				fmt.Printf("could not recover state, syncing from trusted peers: %v\n", err)
+			} else {
+				fmt.Printf("state recovered at epoch %v\n", state.Epoch)
+				finalize = launchRecovery(ctx, cfg, state)
+			}
+		}
+		if finalize == nil {
+			finalize = social.LaunchSyncNode(ctx, cfg.Node, cfg.TurstedPeers, stateFromBytes(cfg))
+		}
 	}
 
 	err = <-finalize
@@ -2,7 +2,7 @@ package handles
 
 import (
 	"context"
-	"io"
+	"log/slog"
 
 	"github.com/freehandle/breeze/crypto"
 	"github.com/freehandle/breeze/middleware/social"
@@ -11,26 +11,47 @@ import (
 )
 
 type HandlesBlock struct {
-	Epoch    uint64
-	Seal     crypto.Hash
-	Grant    map[crypto.Hash]*attorney.GrantPowerOfAttorney
-	Revoke   map[crypto.Hash]*attorney.RevokePowerOfAttorney
-	Join     map[crypto.Hash]*attorney.JoinNetwork
-	Update   map[crypto.Hash]*attorney.UpdateInfo
-	Void     map[crypto.Hash]*attorney.Void
-	Commited bool
+	Epoch      uint64
+	Seal       crypto.Hash
+	Grant      map[crypto.Hash]*attorney.GrantPowerOfAttorney
+	Revoke     map[crypto.Hash]*attorney.RevokePowerOfAttorney
+	Join       map[crypto.Hash]*attorney.JoinNetwork
+	Update     map[crypto.Hash]*attorney.UpdateInfo
+	Void       map[crypto.Hash]*attorney.Void
+	Rotate     map[crypto.Hash]*attorney.RotateKey
+	Guard      map[crypto.Hash]*attorney.RegisterGuardians
+	Request    map[crypto.Hash]*attorney.RequestRecovery
+	Cancel     map[crypto.Hash]*attorney.CancelRecovery
+	Complete   map[crypto.Hash]*attorney.CompleteRecovery
+	Transfer   map[crypto.Hash]*attorney.TransferHandle
+	Release    map[crypto.Hash]*attorney.ReleaseHandle
+	Exchange   map[crypto.Hash]*attorney.KeyExchange
+	Collective map[crypto.Hash]*attorney.JoinCollective
+	Batch      map[crypto.Hash][]crypto.Hash // hash of batch -> hashes of its actions
+	Commited   bool
+	actions    [][]byte // actions not invalidated, in block order
 }
 
 func newHandlesBlock(block *social.SocialBlock) *HandlesBlock {
 	handlesBlock := &HandlesBlock{
-		Epoch:    block.Epoch,
-		Seal:     block.SealHash,
-		Grant:    make(map[crypto.Hash]*attorney.GrantPowerOfAttorney),
-		Revoke:   make(map[crypto.Hash]*attorney.RevokePowerOfAttorney),
-		Join:     make(map[crypto.Hash]*attorney.JoinNetwork),
-		Update:   make(map[crypto.Hash]*attorney.UpdateInfo),
-		Void:     make(map[crypto.Hash]*attorney.Void),
-		Commited: block.CommitHash != crypto.ZeroValueHash,
+		Epoch:      block.Epoch,
+		Seal:       block.SealHash,
+		Grant:      make(map[crypto.Hash]*attorney.GrantPowerOfAttorney),
+		Revoke:     make(map[crypto.Hash]*attorney.RevokePowerOfAttorney),
+		Join:       make(map[crypto.Hash]*attorney.JoinNetwork),
+		Update:     make(map[crypto.Hash]*attorney.UpdateInfo),
+		Void:       make(map[crypto.Hash]*attorney.Void),
+		Rotate:     make(map[crypto.Hash]*attorney.RotateKey),
+		Guard:      make(map[crypto.Hash]*attorney.RegisterGuardians),
+		Request:    make(map[crypto.Hash]*attorney.RequestRecovery),
+		Cancel:     make(map[crypto.Hash]*attorney.CancelRecovery),
+		Complete:   make(map[crypto.Hash]*attorney.CompleteRecovery),
+		Transfer:   make(map[crypto.Hash]*attorney.TransferHandle),
+		Release:    make(map[crypto.Hash]*attorney.ReleaseHandle),
+		Exchange:   make(map[crypto.Hash]*attorney.KeyExchange),
+		Collective: make(map[crypto.Hash]*attorney.JoinCollective),
+		Batch:      make(map[crypto.Hash][]crypto.Hash),
+		Commited:   block.CommitHash != crypto.ZeroValueHash,
 	}
 	invalidated := make(map[crypto.Hash]struct{})
 	for _, hash := range block.Invalidated {
@@ -43,55 +64,204 @@ func newHandlesBlock(block *social.SocialBlock) *HandlesBlock {
 		if _, ok := invalidated[hash]; ok {
 			continue
 		}
-		switch attorney.Kind(action) {
-		case attorney.GrantPowerOfAttorneyType:
-			if grant := attorney.ParseGrantPowerOfAttorney(action); grant != nil {
-				handlesBlock.Grant[hash] = grant
-			}
-		case attorney.RevokePowerOfAttorneyType:
-			if revoke := attorney.ParseRevokePowerOfAttorney(action); revoke != nil {
-				handlesBlock.Revoke[hash] = revoke
-			}
-		case attorney.JoinNetworkType:
-			if join := attorney.ParseJoinNetwork(action); join != nil {
-				handlesBlock.Join[hash] = join
-			}
-		case attorney.UpdateInfoType:
-			if update := attorney.ParseUpdateInfo(action); update != nil {
-				handlesBlock.Update[hash] = update
+		handlesBlock.actions = append(handlesBlock.actions, action)
+		handlesBlock.add(hash, action)
+	}
+	return handlesBlock
+}
+
+// add indexes an action of the block. The actions of a batch are indexed by
+// their own hashes.
+func (h *HandlesBlock) add(hash crypto.Hash, action []byte) {
+	switch attorney.Kind(action) {
+	case attorney.GrantPowerOfAttorneyType:
+		if grant := attorney.ParseGrantPowerOfAttorney(action); grant != nil {
+			h.Grant[hash] = grant
+		}
+	case attorney.RevokePowerOfAttorneyType:
+		if revoke := attorney.ParseRevokePowerOfAttorney(action); revoke != nil {
+			h.Revoke[hash] = revoke
+		}
+	case attorney.JoinNetworkType:
+		if join := attorney.ParseJoinNetwork(action); join != nil {
+			h.Join[hash] = join
+		}
+	case attorney.UpdateInfoType:
+		if update := attorney.ParseUpdateInfo(action); update != nil {
+			h.Update[hash] = update
+		}
+	case attorney.VoidType:
+		if void := attorney.ParseVoid(action); void != nil {
+			h.Void[hash] = void
+		}
+	case attorney.RotateKeyType:
+		if rotate := attorney.ParseRotateKey(action); rotate != nil {
+			h.Rotate[hash] = rotate
+		}
+	case attorney.RegisterGuardiansType:
+		if register := attorney.ParseRegisterGuardians(action); register != nil {
+			h.Guard[hash] = register
+		}
+	case attorney.RequestRecoveryType:
+		if request := attorney.ParseRequestRecovery(action); request != nil {
+			h.Request[hash] = request
+		}
+	case attorney.CancelRecoveryType:
+		if cancel := attorney.ParseCancelRecovery(action); cancel != nil {
+			h.Cancel[hash] = cancel
+		}
+	case attorney.CompleteRecoveryType:
+		if complete := attorney.ParseCompleteRecovery(action); complete != nil {
+			h.Complete[hash] = complete
+		}
+	case attorney.TransferHandleType:
+		if transfer := attorney.ParseTransferHandle(action); transfer != nil {
+			h.Transfer[hash] = transfer
+		}
+	case attorney.ReleaseHandleType:
+		if release := attorney.ParseReleaseHandle(action); release != nil {
+			h.Release[hash] = release
+		}
+	case attorney.KeyExchangeType:
+		if exchange := attorney.ParseKeyExchange(action); exchange != nil {
+			h.Exchange[hash] = exchange
+		}
+	case attorney.BatchType:
+		if batch := attorney.ParseBatch(action); batch != nil {
+			h.Batch[hash] = batch.Hashes()
+			for n, inner := range batch.Actions {
+				h.add(h.Batch[hash][n], inner)
 			}
-		case attorney.VoidType:
-			if void := attorney.ParseVoid(action); void != nil {
-				handlesBlock.Void[hash] = void
+		}
+	case attorney.JoinCollectiveType:
+		if join := attorney.ParseJoinCollective(action); join != nil {
+			h.Collective[hash] = join
+		}
+	}
+}
+
+// remove drops an invalidated action, and every action of an invalidated
+// batch, from the block.
+func (h *HandlesBlock) remove(hash crypto.Hash) {
+	for _, inner := range h.Batch[hash] {
+		h.remove(inner)
+	}
+	delete(h.Batch, hash)
+	delete(h.Grant, hash)
+	delete(h.Revoke, hash)
+	delete(h.Join, hash)
+	delete(h.Update, hash)
+	delete(h.Void, hash)
+	delete(h.Rotate, hash)
+	delete(h.Guard, hash)
+	delete(h.Request, hash)
+	delete(h.Cancel, hash)
+	delete(h.Complete, hash)
+	delete(h.Transfer, hash)
+	delete(h.Release, hash)
+	delete(h.Exchange, hash)
+	delete(h.Collective, hash)
+}
+
+// invalidate drops invalidated actions of the first of blocks from state,
+// which incorporated blocks as sealed. Epochs without a block are
+// incorporated again as empty blocks.
+func invalidate(state *attorney.State, invalidated []crypto.Hash, blocks []*HandlesBlock) {
+	first := blocks[0].Epoch
+	actions := make([][][]byte, 0, len(blocks))
+	for _, block := range blocks {
+		for uint64(len(actions)) < block.Epoch-first {
+			actions = append(actions, nil)
+		}
+		actions = append(actions, block.actions)
+	}
+	if err := state.Invalidate(first, invalidated, actions...); err != nil {
+		slog.Error("HandlesListener: could not drop invalidated actions", "epoch", first, "err", err)
+	}
+}
+
+// blockFollower keeps the blocks of the handles protocol until they are
+// committed, and a state, if any, following them as they arrive.
+type blockFollower struct {
+	state  *attorney.State
+	recent []*HandlesBlock // blocks since the oldest block waiting for its commit
+	last   uint64          // epoch of the last committed block
+}
+
+// block takes a new block and returns it if it is committed. A committed block
+// that arrives after its sealed version commits it, one that arrives again is
+// ignored. Blocks are incorporated into the state only once, as they arrive.
+func (f *blockFollower) block(block *social.SocialBlock) []*HandlesBlock {
+	handlesBlock := newHandlesBlock(block)
+	if handlesBlock.Commited {
+		for _, sealed := range f.recent {
+			if sealed.Seal == handlesBlock.Seal {
+				return f.commit(block.SealHash, block.Invalidated)
 			}
 		}
+		if handlesBlock.Epoch <= f.last {
+			return nil
+		}
+		f.last = handlesBlock.Epoch
 	}
-	return handlesBlock
+	if f.state != nil && handlesBlock.Epoch > f.state.Epoch {
+		f.state.IncorporateBlock(handlesBlock.Epoch, handlesBlock.actions)
+	}
+	if !handlesBlock.Commited || len(f.recent) > 0 {
+		f.recent = append(f.recent, handlesBlock)
+	}
+	if handlesBlock.Commited {
+		return []*HandlesBlock{handlesBlock}
+	}
+	return nil
 }
 
-func HandlesLocal(ctx context.Context, persist io.ReadWriteCloser, receiver chan []byte, listeners []chan []byte) chan error {
-	chain := social.LocalBlockChain[*attorney.Mutations, *attorney.MutatingState]{
-		Interval:  1,
-		Receiver:  receiver,
-		Listeners: listeners,
-		IO:        persist,
-		Epoch:     0,
+// commit drops the invalidated actions of the block sealed with seal and
+// returns the block. The state drops them too, rolling back and incorporating
+// again the blocks that followed.
+func (f *blockFollower) commit(seal crypto.Hash, invalidated []crypto.Hash) []*HandlesBlock {
+	for n, block := range f.recent {
+		if block.Seal != seal || block.Commited {
+			continue
+		}
+		for _, hash := range invalidated {
+			block.remove(hash)
+		}
+		if f.state != nil && len(invalidated) > 0 {
+			invalidate(f.state, invalidated, f.recent[n:])
+		}
+		block.Commited = true
+		if block.Epoch > f.last {
+			f.last = block.Epoch
+		}
+		for len(f.recent) > 0 && f.recent[0].Commited {
+			f.recent = f.recent[1:]
+		}
+		return []*HandlesBlock{block}
 	}
-	genesis := attorney.NewGenesisState("")
-	chain.LoadState(genesis, persist, listeners)
-	return chain.Start(ctx)
+	return nil
 }
 
+// HandlesListener returns the blocks of the handles protocol once committed.
 func HandlesListener(ctx context.Context, sources *socket.TrustedAggregator) chan *HandlesBlock {
+	return HandlesStateListener(ctx, sources, nil)
+}
+
+// HandlesStateListener returns the blocks of the handles protocol once
+// committed, like HandlesListener. If state is not nil, it follows sealed
+// blocks as they arrive and drops the actions invalidated by their commits,
+// rolling back and incorporating again the blocks that followed.
+func HandlesStateListener(ctx context.Context, sources *socket.TrustedAggregator, state *attorney.State) chan *HandlesBlock {
 	blocks := make(chan *social.SocialBlock)
 	commits := make(chan *social.SocialBlockCommit)
 	social.SocialProtocolBlockListener(ctx, 1, sources, blocks, commits)
 	newblock := make(chan *HandlesBlock)
 	go func() {
 		defer close(newblock)
-		recent := make([]*HandlesBlock, 0)
+		follower := &blockFollower{state: state}
 		done := ctx.Done()
 		for {
+			var committed []*HandlesBlock
 			select {
 			case <-done:
 				return
@@ -99,29 +269,15 @@ func HandlesListener(ctx context.Context, sources *socket.TrustedAggregator) cha
 				if !ok {
 					return
 				}
-				handlesBlock := newHandlesBlock(block)
-				if handlesBlock.Commited {
-					newblock <- handlesBlock
-				} else {
-					recent = append(recent, handlesBlock)
-				}
+				committed = follower.block(block)
 			case commit, ok := <-commits:
 				if !ok {
 					return
 				}
-				for n, block := range recent {
-					if block.Seal == commit.SealHash {
-						for _, hash := range commit.Invalidated {
-							delete(block.Grant, hash)
-							delete(block.Revoke, hash)
-							delete(block.Join, hash)
-							delete(block.Update, hash)
-							delete(block.Void, hash)
-						}
-					}
-					newblock <- block
-					recent = append(recent[:n], recent[n+1:]...)
-				}
+				committed = follower.commit(commit.SealHash, commit.Invalidated)
+			}
+			for _, block := range committed {
+				newblock <- block
 			}
 		}
 	}()
@@ -0,0 +1,78 @@
+package handles
+
+import (
+	"testing"
+
+	"github.com/freehandle/breeze/consensus/chain"
+	"github.com/freehandle/breeze/crypto"
+	"github.com/freehandle/breeze/middleware/social"
+	"github.com/freehandle/handles/attorney"
+)
+
+func join(epoch uint64, handle string) []byte {
+	token, key := crypto.RandomAsymetricKey()
+	join := attorney.JoinNetwork{Epoch: epoch, Author: token, Handle: handle, Details: `{}`}
+	join.Sign(key)
+	return join.Serialize()
+}
+
+func sealedBlock(epoch uint64, actions ...[]byte) *social.SocialBlock {
+	array := chain.NewActionArray()
+	for _, action := range actions {
+		array.Append(action)
+	}
+	return &social.SocialBlock{Epoch: epoch, Actions: array, SealHash: crypto.Hasher(array.Serialize())}
+}
+
+func committedBlock(sealed *social.SocialBlock, invalidated ...crypto.Hash) *social.SocialBlock {
+	committed := *sealed
+	committed.Invalidated = invalidated
+	committed.CommitHash = crypto.Hasher(append(sealed.SealHash[:], 1))
+	return &committed
+}
+
+func TestFollowSealedCommittedRollback(t *testing.T) {
+	state := attorney.NewGenesisState("")
+	follower := &blockFollower{state: state}
+	alice, bob, carol := join(1, "alice"), join(2, "bob"), join(2, "alice")
+	first, second := sealedBlock(1, alice), sealedBlock(2, bob, carol)
+	if committed := follower.block(first); len(committed) != 0 {
+		t.Fatal("sealed block returned before its commit")
+	}
+	follower.block(second)
+	if token, _ := state.TokenOf("alice"); token != attorney.ParseJoinNetwork(alice).Author || state.Epoch != 2 {
+		t.Fatal("state does not follow sealed blocks")
+	}
+	// the commit of the first block invalidates the join of alice, the state
+	// rolls back and the join of carol in the second block takes the handle
+	committed := follower.commit(first.SealHash, []crypto.Hash{crypto.Hasher(alice)})
+	if len(committed) != 1 || committed[0].Epoch != 1 || len(committed[0].Join) != 0 {
+		t.Fatal("unexpected committed block")
+	}
+	if token, _ := state.TokenOf("alice"); token != attorney.ParseJoinNetwork(carol).Author || state.Epoch != 2 {
+		t.Fatal("state did not drop the invalidated action")
+	}
+	// the committed second block commits the sealed one without being
+	// incorporated again, and is returned once
+	checksum := state.Checksum()
+	if committed := follower.block(committedBlock(second)); len(committed) != 1 || committed[0].Epoch != 2 {
+		t.Fatal("committed block not returned")
+	}
+	if committed := follower.block(committedBlock(second)); len(committed) != 0 {
+		t.Fatal("committed block returned twice")
+	}
+	if state.Checksum() != checksum || len(follower.recent) != 0 {
+		t.Fatal("committed block incorporated again")
+	}
+	dave := join(3, "dave")
+	if committed := follower.block(committedBlock(sealedBlock(3, dave))); len(committed) != 1 || !state.HasHandle("dave") {
+		t.Fatal("committed block not followed")
+	}
+	expected := attorney.NewGenesisState("")
+	expected.IncorporateBlock(1, nil)
+	expected.IncorporateBlock(2, [][]byte{bob, carol})
+	expected.IncorporateBlock(3, [][]byte{dave})
+	if state.Epoch != 3 || state.Checksum() != expected.Checksum() {
+		t.Fatal("followed state does not match the committed blocks")
+	}
+}